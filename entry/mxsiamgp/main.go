@@ -1,32 +1,50 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"net"
 	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	audit_business "wawa_b.v1/module/audit/business"
+	audit_service "wawa_b.v1/module/audit/service"
 	competition_business "wawa_b.v1/module/competition/business"
 	competition_service "wawa_b.v1/module/competition/service"
 	"wawa_b.v1/module/config"
+	"wawa_b.v1/module/cors"
+	"wawa_b.v1/module/lifecycle"
+	"wawa_b.v1/module/log"
 	merchant_business "wawa_b.v1/module/merchant/business"
 	merchant_service "wawa_b.v1/module/merchant/service"
 	"wawa_b.v1/module/mobile_captcha"
 	order_business "wawa_b.v1/module/order/business"
 	order_service "wawa_b.v1/module/order/service"
+	"wawa_b.v1/module/password"
+	"wawa_b.v1/module/rate_limit"
 	"wawa_b.v1/module/rest_json_rpc"
 	"wawa_b.v1/module/session"
+	"wawa_b.v1/module/tenant"
 	"wawa_b.v1/module/top"
 	user_business "wawa_b.v1/module/user/business"
+	"wawa_b.v1/module/user/domain/permission"
 	user_service "wawa_b.v1/module/user/service"
 	wechat_service "wawa_b.v1/module/wechat/service"
 	"wawa_b.v1/module/wechat_client"
 	wechat_pay_service "wawa_b.v1/module/wechat_pay/service"
 	"wawa_b.v1/module/wechat_pay_client"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
-	echo_fasthttp "github.com/labstack/echo/engine/fasthttp"
+	"github.com/labstack/echo/engine"
+	echo_standard "github.com/labstack/echo/engine/standard"
 	"github.com/labstack/echo/middleware"
+	"github.com/spf13/viper"
 	"github.com/valyala/fasthttp"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/redis.v4"
@@ -35,46 +53,346 @@ import (
 func main() {
 	argConfDir := flag.String("confDir", "/etc/mxsiamgp", "配置文件目录")
 	argProf := flag.String("profile", "production", "配置环境")
+	argSecrets := flag.String("secrets", "", "密钥文件，应存放在配置文件目录以外")
+	argSets := config.SetFlags{}
+	flag.Var(&argSets, "set", "覆盖配置项，形如key=value，可重复指定")
+	argCheckCfg := flag.Bool("check-config", false, "仅校验配置，通过时以0退出，否则列出全部问题并以1退出")
 	flag.Parse()
 
-	v := config.NewConfig(*argConfDir, *argProf)
+	v, err := config.NewConfig(*argConfDir, *argProf, &config.Overrides{
+		SecretsFile: *argSecrets,
+		Sets: argSets,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取配置失败：%v\n", err)
+		os.Exit(1)
+	}
+	cfg, err := config.LoadAppConfig(v)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *argCheckCfg {
+		fmt.Printf("配置%s校验通过\n", *argProf)
+		return
+	}
+	trustedProxies, err := rest_json_rpc.ParseTrustedProxies(cfg.Listen.TrustedProxies)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "解析受信任的代理失败：%v\n", err)
+		os.Exit(1)
+	}
+	metricsAllowFrom, err := rest_json_rpc.ParseIPNets(cfg.Listen.MetricsAllowFrom)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "解析允许访问指标的地址失败：%v\n", err)
+		os.Exit(1)
+	}
 
-	e := echo.New()
+	// 配置热更新，各模块在创建后订阅
+	cfgWatcher := config.NewWatcher(*argConfDir, *argProf, &config.Overrides{
+		SecretsFile: *argSecrets,
+		Sets: argSets,
+	}, cfg)
 
-	originURL := &url.URL{
-		Scheme: "http",
-		Host: v.GetString("frontend.host"),
+	logDefLvl, logLvls := cfg.LogLevels()
+	log.SetLevels(logDefLvl, logLvls)
+	logger := log.GetLogger("main")
+	cfgWatcher.Subscribe(func(_ *viper.Viper, cfg *config.AppConfig) {
+		log.SetLevels(cfg.LogLevels())
+	})
+
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: fmt.Sprintf("%s:%d", cfg.Session.Storage.Redis.Host, cfg.Session.Storage.Redis.Port),
+	})
+
+	// 先开始监听，连接MongoDB期间存活检查即可通过，就绪检查返回503，其余请求返回503
+	lc := lifecycle.NewLifecycle()
+	lc.AddCheck("redis", func() error {
+		return redisCli.Ping().Err()
+	})
+
+	ln, err := listen(&cfg.Listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "监听失败：%v\n", err)
+		os.Exit(1)
 	}
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{
-			originURL.String(),
-		},
+	srv := echo_standard.WithConfig(engine.Config{
+		Listener: ln,
+	})
+	srv.SetHandler(lc)
+	srvErrCh := make(chan error, 1)
+	go func() {
+		srvErrCh <- srv.Start()
+	}()
+	logger.WithField("address", ln.Addr().String()).WithField("tls", cfg.Listen.TLS.Enabled()).Info("开始监听")
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+
+	mgoConnCh := make(chan *mgo.Session, 1)
+	go func() {
+		mgoConnCh <- dialMongoDB(logger, fmt.Sprintf("mongodb://%s:%d", cfg.Database.MongoDB.Host, cfg.Database.MongoDB.Port))
+	}()
+	var mgoConn *mgo.Session
+	select {
+	case mgoConn = <-mgoConnCh:
+	case sig := <-sigCh:
+		logger.WithField("signal", sig.String()).Info("连接MongoDB期间收到停机信号")
+		ln.Close()
+		redisCli.Close()
+		return
+	case err := <-srvErrCh:
+		// 尚未处理业务请求，无需等待
+		logger.WithError(err).Error("监听异常结束")
+		redisCli.Close()
+		os.Exit(1)
+	}
+	lc.AddCheck("mongodb", mgoConn.Ping)
+
+	app := &application{
+		v: v,
+		cfg: cfg,
+		cfgWatcher: cfgWatcher,
+		redisCli: redisCli,
+		mgoConn: mgoConn,
+		sessOpts: session.OptionsFromConfig(v),
+		passwordHasher: password.HasherFromConfig(v),
+		lifecycle: lc,
+		trustedProxies: trustedProxies,
+		metricsAllowFrom: metricsAllowFrom,
+	}
+
+	// 按Host头或路径前缀将请求分派到各租户
+	// 未配置tenants时只有默认租户，所有请求都由其处理
+	router := tenant.NewRouter()
+	for _, tenantCfg := range cfg.AllTenants() {
+		e := newTenantEcho(app, tenantCfg)
+		for _, host := range tenantCfg.Hosts {
+			router.AddHost(host, e)
+		}
+		if len(tenantCfg.PathPrefix) != 0 {
+			router.AddPathPrefix(tenantCfg.PathPrefix, e)
+		}
+		if len(tenantCfg.Hosts) == 0 && len(tenantCfg.PathPrefix) == 0 {
+			router.SetDefault(e)
+		}
+	}
+
+	cfgWatcher.Watch()
+
+	lc.SetHandler(router)
+	logger.Info("开始处理请求")
+
+	// 监听异常结束时同样等待进行中的请求完成，之后以状态码1退出
+	exitCode := 0
+	select {
+	case sig := <-sigCh:
+		logger.WithField("signal", sig.String()).Info("收到停机信号，开始优雅停机")
+	case err := <-srvErrCh:
+		logger.WithError(err).Error("监听异常结束，开始停机")
+		exitCode = 1
+	}
+
+	// 停止接受新连接，等待进行中的RPC及微信支付回调完成，已建立的连接上的请求仍照常处理
+	ln.Close()
+	shutdownTimeout := time.Duration(cfg.Listen.ShutdownTimeout) * time.Millisecond
+	if lc.Drain(shutdownTimeout) {
+		logger.Info("进行中的请求已全部完成")
+	} else {
+		logger.WithField("timeout", shutdownTimeout.String()).Warn("等待进行中的请求超时，强制停机")
+	}
+	mgoConn.Close()
+	redisCli.Close()
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// 按监听配置创建监听器，配置了TLS时以TLS监听
+func listen(listenCfg *config.ListenConfig) (net.Listener, error) {
+	addr := net.JoinHostPort(listenCfg.Address, strconv.Itoa(listenCfg.Port))
+	if !listenCfg.TLS.Enabled() {
+		return net.Listen("tcp", addr)
+	}
+
+	cert, err := tls.LoadX509KeyPair(listenCfg.TLS.CertFile, listenCfg.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion: tls.VersionTLS12,
+	})
+}
+
+// 连接MongoDB，失败时定期重试直至成功
+func dialMongoDB(logger *logrus.Logger, mgoURL string) *mgo.Session {
+	for {
+		mgoConn, err := mgo.DialWithTimeout(mgoURL, 10 * time.Second)
+		if err == nil {
+			logger.Info("已连接MongoDB")
+			return mgoConn
+		}
+		logger.WithError(err).Warn("连接MongoDB失败，5秒后重试")
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// 各租户共享的资源
+type application struct {
+	// 启动时读取的配置
+	v                *viper.Viper
+
+	// 启动时读取的应用配置
+	cfg              *config.AppConfig
+
+	// 配置监视器
+	cfgWatcher       *config.Watcher
+
+	// Redis客户端，各租户以键前缀隔离
+	redisCli         *redis.Client
+
+	// MongoDB连接，各租户使用各自的数据库
+	mgoConn          *mgo.Session
+
+	// 会话选项
+	sessOpts         *session.SessionOptions
+
+	// 密码散列器
+	passwordHasher   password.PasswordHasher
+
+	// 生命周期处理器，各租户在其上添加就绪检查
+	lifecycle        *lifecycle.Lifecycle
+
+	// 受信任的代理，各租户的RPC据此取调用者的IP
+	trustedProxies   rest_json_rpc.TrustedProxies
+
+	// 允许访问/metrics的地址
+	metricsAllowFrom []*net.IPNet
+}
+
+// 租户的Redis键前缀，未配置tenants时为空，与单租户部署的键保持一致
+func (app *application) keyPrefix(tenantCfg *config.TenantConfig) string {
+	if len(app.cfg.Tenants) == 0 {
+		return ""
+	}
+	return tenantCfg.ID + ":"
+}
+
+// 订阅租户的配置变更
+func (app *application) subscribe(tenantID string, sub func(v *viper.Viper, tenantCfg *config.TenantConfig)) {
+	app.cfgWatcher.Subscribe(func(v *viper.Viper, cfg *config.AppConfig) {
+		// 租户的增减须重启生效
+		if tenantCfg := cfg.Tenant(tenantID); tenantCfg != nil {
+			sub(v, tenantCfg)
+		}
+	})
+}
+
+// 创建租户的Echo实例，注册租户的全部路由及过程
+// 每个租户有各自的客户端、管理器及RPC
+func newTenantEcho(app *application, tenantCfg *config.TenantConfig) *echo.Echo {
+	db := app.mgoConn.DB(tenantCfg.Database)
+
+	e := echo.New()
+
+	corsMw := cors.NewCORS(middleware.CORSConfig{
+		AllowOrigins: tenantCfg.AllowOrigins(),
 		AllowMethods: []string{echo.OPTIONS, echo.POST},
 		AllowCredentials: true,
 		MaxAge: 86400,
-	}))
+	})
+	app.subscribe(tenantCfg.ID, func(_ *viper.Viper, tenantCfg *config.TenantConfig) {
+		corsMw.SetAllowOrigins(tenantCfg.AllowOrigins())
+	})
+	e.Use(corsMw.MiddlewareFunc())
 
 	e.Use(middleware.Recover())
 
-	sessMgr := session.NewManager("mxsiamgp.sid", session.NewRedisSessionStore(redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%d", v.GetString("session.storage.redis.host"), v.GetInt("session.storage.redis.port")),
-	}), time.Duration(v.GetInt("session.storage.expiration")) * time.Millisecond))
-	e.Use(sessMgr.HandlerFunc())
+	// 会话存储器按session.storage.kind选择，过期时间为空闲超时
+	// 多租户时Redis键以租户ID为前缀，按路径前缀识别的租户的Cookie限定在前缀下
+	sessOpts := *app.sessOpts
+	if len(tenantCfg.PathPrefix) != 0 && sessOpts.Path == "/" {
+		sessOpts.Path = tenantCfg.PathPrefix
+	}
+	sessExp := sessOpts.IdleTimeout
+	// 刷新令牌存储器与会话存储器同类但键空间分开，过期时间为刷新令牌的有效期
+	refreshExp := sessOpts.RefreshTimeout
+	var sessStore session.SessionStore
+	var refreshStore session.RefreshTokenStore
+	var sessIndex session.UserSessionIndex
+	switch kind := app.cfg.Session.Storage.Kind; kind {
+	case "redis":
+		sessStore = session.NewRedisSessionStore(app.redisCli, app.keyPrefix(tenantCfg), sessExp)
+		refreshStore = session.NewRedisSessionStore(app.redisCli, app.keyPrefix(tenantCfg) + "refresh:", refreshExp)
+		sessIndex = session.NewRedisUserSessionIndex(app.redisCli, app.keyPrefix(tenantCfg) + "user_sessions:", refreshExp)
+	case "memory":
+		sessStore = session.NewMemorySessionStore(sessExp)
+		refreshStore = session.NewMemorySessionStore(refreshExp)
+		sessIndex = session.NewMemoryUserSessionIndex()
+	case "mongodb":
+		sessStore = session.NewMongoDBSessionStore(db, session.MONGODB_COLLECTION_SESSIONS, sessExp)
+		refreshStore = session.NewMongoDBSessionStore(db, session.MONGODB_COLLECTION_REFRESH_TOKENS, refreshExp)
+		sessIndex = session.NewMongoDBUserSessionIndex(db)
+	default:
+		panic(fmt.Sprintf("不支持的会话存储器类型：%s", kind))
+	}
+	sessMgr := session.NewManager(&sessOpts, sessStore, refreshStore, sessIndex)
 
+	// 会话中间件按路由挂载，WebSocket路由使用net/http的会话中间件
 	rpc := rest_json_rpc.NewRPC()
-	e.POST("/rest_json_rpc", rpc.HandlerFunc())
+	rpc.SetTrustedProxies(app.trustedProxies)
+	rpc.SetMaxBatchSize(app.cfg.Listen.MaxBatchSize)
+	e.POST("/rest_json_rpc", rpc.HandlerFunc(), sessMgr.HandlerFunc())
+	e.GET("/rest_json_rpc/ws", echo_standard.WrapHandler(sessMgr.HTTPHandler(rpc.WebSocketHandler(corsMw.AllowOrigins))))
+	e.GET("/metrics", echo_standard.WrapHandler(rpc.MetricsHandler(app.metricsAllowFrom)))
 
-	mgoConn, err := mgo.Dial(fmt.Sprintf("mongodb://%s:%d", v.GetString("database.mongodb.host"), v.GetInt("database.mongodb.port")))
-	if err != nil {
-		panic(err)
+	topCli := top.NewTOPClient(&fasthttp.Client{}, top.HTTP_OFFICIAL, tenantCfg.TOP.AppKey, tenantCfg.TOP.AppSecret)
+	captCdGen := mobile_captcha.NewRandDigitalCaptchaGenerator(6)
+
+	wcCli := wechat_client.NewWechatClient(&fasthttp.Client{}, tenantCfg.Wechat.AppID, tenantCfg.Wechat.AppSecret)
+	app.lifecycle.AddCheck("wechat." + tenantCfg.ID, wcCli.CheckTokenCache)
+
+	// 限流
+	rateLimiter := rate_limit.NewRedisTokenBucketLimiter(app.redisCli, app.keyPrefix(tenantCfg) + "rate_limit:")
+	rateLimitKeys := map[string]rate_limit.KeyFunc{
+		rate_limit.SCOPE_SESSION: rate_limit.SessionKey,
+		rate_limit.SCOPE_IP: rate_limit.RemoteIPKey,
+		rate_limit.SCOPE_USER: user_service.UserNameRateLimitKey,
 	}
+	rateLimitRuleSets := map[string]*rate_limit.RuleSet{}
+	rateLimitRuleSet := func(procName string) *rate_limit.RuleSet {
+		ruleSet := rate_limit.NewRuleSet(rate_limit.RulesFromConfig(app.v, procName, rateLimitKeys))
+		rateLimitRuleSets[procName] = ruleSet
+		return ruleSet
+	}
+	app.subscribe(tenantCfg.ID, func(v *viper.Viper, _ *config.TenantConfig) {
+		for procName, ruleSet := range rateLimitRuleSets {
+			ruleSet.SetRules(rate_limit.RulesFromConfig(v, procName, rateLimitKeys))
+		}
+	})
 
-	topCli := top.NewTOPClient(&fasthttp.Client{}, top.HTTP_OFFICIAL, v.GetString("top.appKey"), v.GetString("top.appSecret"))
-	captCdGen := mobile_captcha.NewRandDigitalCaptchaGenerator(6)
+	// 手机验证码
+	registerMobiCaptMgr := mobile_captcha.NewTOPMobileCaptchaManager(topCli, captCdGen,
+		tenantCfg.TOP.MobileCaptchaManager.User.Register.TemplateCode,
+		tenantCfg.TOP.MobileCaptchaManager.User.Register.Product,
+		tenantCfg.TOP.MobileCaptchaManager.User.Register.Sign)
+	retakePasswordMobiCaptMgr := mobile_captcha.NewTOPMobileCaptchaManager(topCli, captCdGen,
+		tenantCfg.TOP.MobileCaptchaManager.User.RetakePassword.TemplateCode,
+		tenantCfg.TOP.MobileCaptchaManager.User.RetakePassword.Product,
+		tenantCfg.TOP.MobileCaptchaManager.User.RetakePassword.Sign)
+	app.subscribe(tenantCfg.ID, func(_ *viper.Viper, tenantCfg *config.TenantConfig) {
+		registerTpl := &tenantCfg.TOP.MobileCaptchaManager.User.Register
+		registerMobiCaptMgr.SetTemplate(registerTpl.TemplateCode, registerTpl.Product, registerTpl.Sign)
+		retakePasswordTpl := &tenantCfg.TOP.MobileCaptchaManager.User.RetakePassword
+		retakePasswordMobiCaptMgr.SetTemplate(retakePasswordTpl.TemplateCode, retakePasswordTpl.Product, retakePasswordTpl.Sign)
+	})
 
-	wcCli := wechat_client.NewWechatClient(&fasthttp.Client{}, v.GetString("wechat.appId"), v.GetString("wechat.appSecret"))
+	// 审计
+	auditMgr := audit_business.NewMongoDBAuditLogManager(db)
 
-	userMgr := user_business.NewMongoDBUserManager(mgoConn.DB("mxsiamgp"), map[string][]string{
+	roleMgr := user_business.NewMongoDBRoleManager(db)
+	// 注册时按用户类型分配的角色
+	userMgr := user_business.NewMongoDBUserManager(db, app.passwordHasher, roleMgr, map[string][]string{
 		"ANONYMOUS_USER": []string{},
 	})
 
@@ -87,27 +405,37 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &user_service.GetParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
 	})
 
 	rpc.RegisterProcess("user.send_mobile_captcha_for_register", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
-			user_service.SendMobileCaptchaForRegisterProcessHandler(
-				mobile_captcha.NewTOPMobileCaptchaManager(topCli, captCdGen,
-					v.GetString("top.mobileCaptchaManager.user.register.templateCode"),
-					v.GetString("top.mobileCaptchaManager.user.register.product"),
-					v.GetString("top.mobileCaptchaManager.user.register.sign"))),
+			rate_limit.RateLimitProcessHandler(rateLimiter, "user.send_mobile_captcha_for_register", rateLimitRuleSet("user.send_mobile_captcha_for_register")),
+			user_service.SendMobileCaptchaForRegisterProcessHandler(registerMobiCaptMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &user_service.SendMobileCaptchaForRegisterParam{}
 		},
+		FailCodes: []string{
+			rate_limit.FAIL_CD_RATE_LIMITED,
+			mobile_captcha.FAIL_CD_SEND_MOBILE_CAPTCHA_FAIL,
+		},
 	})
 	rpc.RegisterProcess("user.register", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.register"),
 			user_service.RegisterProcessHandler(userMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &user_service.RegisterParam{}
 		},
+		FailCodes: []string{
+			mobile_captcha.FAIL_CD_INCORRECT_MOBILE_CAPTCHA,
+			user_business.FAIL_CD_INVALID_USER_KIND,
+			user_business.FAIL_CD_DUPLICATE_USER_NAME,
+		},
 	})
 	rpc.RegisterProcess("user.retrieve", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
@@ -120,31 +448,41 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &user_service.RetrieveParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 	rpc.RegisterProcess("user.send_mobile_captcha_for_retake_password", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
-			user_service.SendMobileCaptchaForRetakePasswordProcessHandler(
-				userMgr, mobile_captcha.NewTOPMobileCaptchaManager(topCli, captCdGen,
-					v.GetString("top.mobileCaptchaManager.user.retakePassword.templateCode"),
-					v.GetString("top.mobileCaptchaManager.user.retakePassword.product"),
-					v.GetString("top.mobileCaptchaManager.user.retakePassword.sign"))),
+			rate_limit.RateLimitProcessHandler(rateLimiter, "user.send_mobile_captcha_for_retake_password", rateLimitRuleSet("user.send_mobile_captcha_for_retake_password")),
+			user_service.SendMobileCaptchaForRetakePasswordProcessHandler(userMgr, retakePasswordMobiCaptMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &user_service.SendMobileCaptchaForRetakePasswordParam{}
 		},
+		FailCodes: []string{
+			rate_limit.FAIL_CD_RATE_LIMITED,
+			mobile_captcha.FAIL_CD_SEND_MOBILE_CAPTCHA_FAIL,
+		},
 	})
 	rpc.RegisterProcess("user.retake_password", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
-			user_service.RetakePasswordProcessHandler(userMgr),
+			audit_service.AuditProcessHandler(auditMgr, "user.retake_password"),
+			user_service.RetakePasswordProcessHandler(userMgr, sessMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &user_service.RetakePasswordParam{}
 		},
+		FailCodes: []string{
+			mobile_captcha.FAIL_CD_INCORRECT_MOBILE_CAPTCHA,
+		},
 	})
 	rpc.RegisterProcess("user.update", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.update"),
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ echo.Context, p interface{}, userID string) bool {
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
 				param := p.(*user_service.UpdateParam)
 				return param.ID == userID
 			}),
@@ -153,36 +491,52 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &user_service.UpdateParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+		},
 	})
 	rpc.RegisterProcess("user.update_password", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.update_password"),
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ echo.Context, p interface{}, userID string) bool {
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
 				param := p.(*user_service.UpdatePasswordParam)
 				return param.ID == userID
 			}),
-			user_service.UpdatePasswordProcessHandler(userMgr),
+			user_service.UpdatePasswordProcessHandler(userMgr, sessMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &user_service.UpdatePasswordParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+		},
 	})
 	rpc.RegisterProcess("user.delete", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.delete"),
 			user_service.EnsureLoggedInProcessHandler(),
 			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
 				"USER.MODIFY",
 			}),
-			user_service.DeleteProcessHandler(userMgr),
+			user_service.DeleteProcessHandler(userMgr, sessMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &user_service.DeleteParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			user_service.FAIL_CD_CANNOT_DELETE_SPONSOR_MANAGER,
+		},
 	})
 	rpc.RegisterProcess("user.grant_flat_permissions", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.grant_flat_permissions"),
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ echo.Context, p interface{}, userID string) bool {
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
 				param := p.(*user_service.GrantFlatPermissionsParam)
 				return param.GranterID == userID
 			}),
@@ -191,6 +545,113 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &user_service.GrantFlatPermissionsParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_NO_SUCH_USER,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+			user_business.FAIL_CD_UNKNOWN_PERMISSIONS,
+		},
+		Deprecation: &rest_json_rpc.Deprecation{
+			Sunset: time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC),
+			Successor: "user.grant_permissions",
+		},
+	})
+	rpc.RegisterProcess("user.grant_permissions", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.grant_permissions"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
+				param := p.(*user_service.GrantPermissionsParam)
+				return param.GranterID == userID
+			}),
+			user_service.GrantPermissionsProcessHandler(userMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.GrantPermissionsParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+			user_business.FAIL_CD_UNKNOWN_PERMISSIONS,
+		},
+	})
+	rpc.RegisterProcess("user.revoke_permissions", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.revoke_permissions"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
+				param := p.(*user_service.RevokePermissionsParam)
+				return param.RevokerID == userID
+			}),
+			user_service.RevokePermissionsProcessHandler(userMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.RevokePermissionsParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+			user_business.FAIL_CD_UNKNOWN_PERMISSIONS,
+		},
+	})
+	rpc.RegisterProcess("user.grant_resource_permissions", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.grant_resource_permissions"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
+				param := p.(*user_service.GrantResourcePermissionsParam)
+				return param.GranterID == userID
+			}),
+			user_service.GrantResourcePermissionsProcessHandler(userMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.GrantResourcePermissionsParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+			user_business.FAIL_CD_UNKNOWN_PERMISSIONS,
+		},
+	})
+	rpc.RegisterProcess("user.revoke_resource_permissions", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.revoke_resource_permissions"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
+				param := p.(*user_service.RevokeResourcePermissionsParam)
+				return param.RevokerID == userID
+			}),
+			user_service.RevokeResourcePermissionsProcessHandler(userMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.RevokeResourcePermissionsParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+			user_business.FAIL_CD_UNKNOWN_PERMISSIONS,
+		},
+	})
+	rpc.RegisterProcess("user.permission_history", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"USER.PERMISSION.RETRIEVE",
+			}),
+			user_service.PermissionHistoryProcessHandler(userMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.PermissionHistoryParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 	rpc.RegisterProcess("user.get_current_user", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
@@ -200,12 +661,159 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &user_service.GetCurrentUserParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
+	})
+	rpc.RegisterProcess("user.get_current_user_permissions", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.GetCurrentUserPermissionsProcessHandler(userMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.GetCurrentUserPermissionsParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
+	})
+
+	// 角色
+	rpc.RegisterProcess("user.role.list", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"USER.ROLE.RETRIEVE",
+			}),
+			user_service.ListRolesProcessHandler(roleMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.ListRolesParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
+	})
+	rpc.RegisterProcess("user.role.get", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"USER.ROLE.RETRIEVE",
+			}),
+			user_service.GetRoleProcessHandler(roleMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.GetRoleParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
+	})
+	rpc.RegisterProcess("user.role.create", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.role.create"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"USER.ROLE.MODIFY",
+			}),
+			user_service.CreateRoleProcessHandler(userMgr, roleMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.CreateRoleParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+			user_business.FAIL_CD_UNKNOWN_PERMISSIONS,
+			user_business.FAIL_CD_DUPLICATE_ROLE_NAME,
+		},
+	})
+	rpc.RegisterProcess("user.role.update", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.role.update"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"USER.ROLE.MODIFY",
+			}),
+			user_service.UpdateRoleProcessHandler(userMgr, roleMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.UpdateRoleParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+			user_business.FAIL_CD_UNKNOWN_PERMISSIONS,
+			user_business.FAIL_CD_NO_SUCH_ROLE,
+			user_business.FAIL_CD_CANNOT_MODIFY_BUILTIN_ROLE,
+		},
+	})
+	rpc.RegisterProcess("user.role.delete", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.role.delete"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"USER.ROLE.MODIFY",
+			}),
+			user_service.DeleteRoleProcessHandler(userMgr, roleMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.DeleteRoleParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			user_business.FAIL_CD_CANNOT_DELETE_BUILTIN_ROLE,
+			user_business.FAIL_CD_ROLE_IN_USE,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+		},
+	})
+	rpc.RegisterProcess("user.role.assign", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.role.assign"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"USER.ROLE.ASSIGN",
+			}),
+			user_service.AssignRoleProcessHandler(userMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.AssignRoleParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+			user_business.FAIL_CD_NO_SUCH_ROLE,
+		},
+	})
+	rpc.RegisterProcess("user.role.unassign", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.role.unassign"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"USER.ROLE.ASSIGN",
+			}),
+			user_service.UnassignRoleProcessHandler(userMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.UnassignRoleParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+		},
 	})
 
 	wcAuthURL := &url.URL{
 		Scheme: "http",
-		Host: v.GetString("backend.host"),
-		Path: "/user/wechat_auth",
+		Host: tenantCfg.Backend.Host,
+		Path: tenantCfg.BackendPath("/user/wechat_auth"),
 	}
 
 	rpc.RegisterProcess("user.get_current_wechat_user", &rest_json_rpc.Process{
@@ -217,22 +825,45 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &user_service.GetCurrentUserParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_WECHAT_REDIRECT,
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
 	})
 	rpc.RegisterProcess("user.login", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
-			user_service.LoginProcessHandler(userMgr),
+			rate_limit.RateLimitProcessHandler(rateLimiter, "user.login", rateLimitRuleSet("user.login")),
+			user_service.LoginProcessHandler(userMgr, sessMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &user_service.LoginParam{}
 		},
+		FailCodes: []string{
+			rate_limit.FAIL_CD_RATE_LIMITED,
+			user_service.FAIL_CD_NO_SUCH_USER,
+			user_service.FAIL_CD_INCORRECT_PASSWORD,
+			user_service.FAIL_CD_TOKEN_UNSUPPORTED,
+		},
+	})
+	rpc.RegisterProcess("user.refresh_token", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			user_service.RefreshTokenProcessHandler(sessMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.RefreshTokenParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_TOKEN_UNSUPPORTED,
+			user_service.FAIL_CD_INVALID_REFRESH_TOKEN,
+		},
 	})
 	wcLoginURL := &url.URL{
 		Scheme: "http",
-		Host: v.GetString("frontend.host"),
+		Host: tenantCfg.Frontend.Host,
 		Path: "/module/wechat/html/index.html",
 		Fragment: "/user/wechat/login",
 	}
-	e.GET("/user/wechat_auth", user_service.WechatAuthHandlerFunc(userMgr, wcCli, wcLoginURL.String()))
+	e.GET("/user/wechat_auth", user_service.WechatAuthHandlerFunc(userMgr, wcCli, wcLoginURL.String()), sessMgr.HandlerFunc())
 	rpc.RegisterProcess("user.logout", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
 			user_service.LogoutProcessHandler(),
@@ -241,6 +872,19 @@ func main() {
 			return &user_service.LogoutParam{}
 		},
 	})
+	rpc.RegisterProcess("user.logout_everywhere", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "user.logout_everywhere"),
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.LogoutEverywhereProcessHandler(sessMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &user_service.LogoutEverywhereParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
+	})
 	rpc.RegisterProcess("user.logout_for_wechat", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
 			user_service.EnsureWechatAuthorizedProcessHandler(wcCli, wcAuthURL.String(), "snsapi_userinfo", ""),
@@ -249,12 +893,18 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &user_service.LogoutForWechatParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_WECHAT_REDIRECT,
+		},
 	})
 
 	// 赛事模块过程
-	cmptMgr := competition_business.NewMongoDBCompetitionManager(mgoConn.DB("mxsiamgp"))
+	cmptMgr := competition_business.NewMongoDBCompetitionManager(db, rpc)
+	rpc.RegisterTopic(competition_business.TOPIC_NAME_COMPETITION_FINISHED, nil)
+
 	rpc.RegisterProcess("competition.add", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "competition.add"),
 			user_service.EnsureLoggedInProcessHandler(),
 			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
 				"COMPETITION.MODIFY",
@@ -264,30 +914,48 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &competition_service.AddParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			competition_business.FAIL_CD_DUPLICATE_COMPETITION_NAME,
+		},
 	})
 
-	wcPayCli := wechat_pay_client.NewWechatPayClient(&fasthttp.Client{}, v.GetString("wechat.appId"), v.GetString("wechat.mchId"), v.GetString("wechat.partnerKey"))
-
-	wcH5PayWpTitle := "海南超跑赛车收费服务平台"
+	wcPayCli := wechat_pay_client.NewWechatPayClient(&fasthttp.Client{}, tenantCfg.Wechat.AppID, tenantCfg.Wechat.MchID, tenantCfg.Wechat.PartnerKey)
 
 	pncbist := map[string]order_business.OrderItemPayNotifyCallback{}
-	orderMgr := order_business.NewMongoDBOrderManager(mgoConn.DB("mxsiamgp"), pncbist, wcPayCli, &wcH5PayWpTitle)
+	orderMgr := order_business.NewMongoDBOrderManager(db, pncbist, wcPayCli, tenantCfg.Wechat.H5PayWebpageTitle, rpc)
+	app.subscribe(tenantCfg.ID, func(_ *viper.Viper, tenantCfg *config.TenantConfig) {
+		orderMgr.SetWechatH5PayWebpageTitle(tenantCfg.Wechat.H5PayWebpageTitle)
+	})
+	rpc.RegisterTopic(order_business.TOPIC_NAME_ORDER_PAID, &rest_json_rpc.Topic{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			user_service.EnsureLoggedInProcessHandler(),
+			order_service.SubscribeOrderPaidProcessHandler(),
+		},
+		Filter: order_service.OrderPaidTopicFilter,
+	})
 
-	ticketMgr := competition_business.NewMongoDBDrawnTicketManager(mgoConn.DB("mxsiamgp"), cmptMgr, orderMgr)
+	ticketMgr := competition_business.NewMongoDBDrawnTicketManager(db, cmptMgr, orderMgr)
 
 	pncbist["COMPETITION.TICKET"] = ticketMgr.OrderItemPayNotifyCallback()
 
 	rpc.RegisterProcess("competition.create_order", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "competition.create_order"),
 			user_service.EnsureLoggedInProcessHandler(),
 			competition_service.CreateOrderProcessHandler(ticketMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &competition_service.CreateOrderParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
 	})
 	rpc.RegisterProcess("competition.delete", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "competition.delete"),
 			user_service.EnsureLoggedInProcessHandler(),
 			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
 				"COMPETITION.MODIFY",
@@ -297,9 +965,14 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &competition_service.DeleteParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 	rpc.RegisterProcess("competition.finish", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "competition.finish"),
 			user_service.EnsureLoggedInProcessHandler(),
 			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
 				"COMPETITION.MODIFY",
@@ -309,6 +982,10 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &competition_service.FinishParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 	rpc.RegisterProcess("competition.get", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
@@ -318,6 +995,9 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &competition_service.GetParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
 	})
 	rpc.RegisterProcess("competition.list_in_progress", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
@@ -327,6 +1007,9 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &competition_service.ListInProgressParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
 	})
 	rpc.RegisterProcess("competition.retrieve", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
@@ -339,9 +1022,14 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &competition_service.RetrieveParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 	rpc.RegisterProcess("competition.update_tickets", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "competition.update_tickets"),
 			user_service.EnsureLoggedInProcessHandler(),
 			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
 				"COMPETITION.MODIFY",
@@ -351,39 +1039,69 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &competition_service.UpdateTicketsParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 	rpc.RegisterProcess("competition.drawn_ticket.get_all_by_competition_id_and_user_id", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+			user_service.EnsureRequiredResourcePermissionsProcessHandler(userMgr, []string{
 				"COMPETITION.DRAWN_TICKET.INSPECT",
+			}, func(_ rest_json_rpc.Context, p interface{}) (string, error) {
+				return p.(*competition_service.GetAllDrawnTicketsByCompetitionIDAndUserIDParam).CompetitionID, nil
 			}),
 			competition_service.GetAllDrawnTicketsByCompetitionIDAndUserIDProcessHandler(ticketMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &competition_service.GetAllDrawnTicketsByCompetitionIDAndUserIDParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 
-	inspectMgr := competition_business.NewMongoDBInspectionManager(mgoConn.DB("mxsiamgp"))
+	inspectMgr := competition_business.NewMongoDBInspectionManager(db, rpc)
+	// 订阅标签为发布时当前用户的有效权限集，仅推送其有验票权限的赛事的事件
+	rpc.RegisterTopic(competition_business.TOPIC_NAME_INSPECTION_INSPECTED, &rest_json_rpc.Topic{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.GetCurrentUserPermissionsProcessHandler(userMgr),
+		},
+		Filter: func(tag interface{}, event interface{}) bool {
+			perms, _ := tag.([]string)
+			return permission.IsAllowedOn(perms, "COMPETITION.DRAWN_TICKET.INSPECT", event.(*competition_business.InspectedEvent).CompetitionID)
+		},
+	})
 
 	rpc.RegisterProcess("competition.inspection.mark_inspected", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "competition.inspection.mark_inspected"),
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+			user_service.EnsureRequiredResourcePermissionsProcessHandler(userMgr, []string{
 				"COMPETITION.DRAWN_TICKET.INSPECT",
+			}, func(_ rest_json_rpc.Context, p interface{}) (string, error) {
+				return p.(*competition_service.MarkInspectedParam).CompetitionID, nil
 			}),
 			competition_service.MarkInspectedProcessHandler(inspectMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &competition_service.MarkInspectedParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			competition_business.FAIL_CD_INSPECTION_INSPECTED,
+		},
 	})
 
 	// 商家模块过程
-	mcMgr := merchant_business.NewMongoDBMerchantManager(mgoConn.DB("mxsiamgp"), userMgr)
+	mcMgr := merchant_business.NewMongoDBMerchantManager(db, userMgr)
 	rpc.RegisterProcess("merchant.delete", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "merchant.delete"),
 			user_service.EnsureLoggedInProcessHandler(),
 			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
 				"MERCHANT.MODIFY",
@@ -393,6 +1111,11 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.DeleteParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+		},
 	})
 	rpc.RegisterProcess("merchant.get", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
@@ -402,6 +1125,9 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.GetParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+		},
 	})
 	rpc.RegisterProcess("merchant.get_current_merchant", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
@@ -411,26 +1137,47 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.GetCurrentMerchantParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			merchant_service.FAIL_CD_CURRENT_USER_UNBOUNDED_MERCHANTS,
+		},
 	})
 	rpc.RegisterProcess("merchant.kick_out_staff", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "merchant.kick_out_staff"),
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+			user_service.EnsureRequiredResourcePermissionsProcessHandler(userMgr, []string{
 				"MERCHANT.STAFF.MODIFY",
+			}, func(_ rest_json_rpc.Context, p interface{}) (string, error) {
+				// 资源为员工所属的商家
+				mc, err := mcMgr.GetByUserID(p.(*merchant_service.KickOutStaffParam).UserID)
+				if err != nil || mc == nil {
+					return "", err
+				}
+				return mc.ID.Hex(), nil
 			}),
 			merchant_service.KickOutStaffProcessHandler(mcMgr),
 		},
 		ParamFactory: func() interface{} {
 			return &merchant_service.KickOutStaffParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			merchant_business.FAIL_CD_CANNOT_KICK_OUT_MANAGER,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+		},
 	})
 	rpc.RegisterProcess("merchant.pull_in_staff", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "merchant.pull_in_staff"),
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+			user_service.EnsureRequiredResourcePermissionsProcessHandler(userMgr, []string{
 				"MERCHANT.STAFF.MODIFY",
+			}, func(_ rest_json_rpc.Context, p interface{}) (string, error) {
+				return p.(*merchant_service.PullInStaffParam).MerchantID, nil
 			}),
-			merchant_service.EnsureResourceBelongToCurrentMerchantProcessHandler(mcMgr, func(_ echo.Context, p interface{}, merchantID string) bool {
+			merchant_service.EnsureResourceBelongToCurrentMerchantProcessHandler(mcMgr, func(_ rest_json_rpc.Context, p interface{}, merchantID string) bool {
 				param := p.(*merchant_service.PullInStaffParam)
 				return param.MerchantID == merchantID
 			}),
@@ -439,9 +1186,19 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.PullInStaffParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			merchant_service.FAIL_CD_CURRENT_USER_UNBOUNDED_MERCHANTS,
+			merchant_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_MERCHANT,
+			merchant_service.FAIL_CD_NO_SUCH_USER,
+			merchant_business.FAIL_CD_USER_IS_BOUND,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+		},
 	})
 	rpc.RegisterProcess("merchant.register", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "merchant.register"),
 			user_service.EnsureLoggedInProcessHandler(),
 			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
 				"MERCHANT.MODIFY",
@@ -451,6 +1208,14 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.RegisterParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			merchant_service.FAIL_CD_NO_SUCH_USER,
+			merchant_business.FAIL_CD_DUPLICATE_MERCHANT_NAME,
+			merchant_business.FAIL_CD_USER_IS_BOUND,
+			user_business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS,
+		},
 	})
 	rpc.RegisterProcess("merchant.retrieve", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
@@ -463,14 +1228,20 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.RetrieveParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 	rpc.RegisterProcess("merchant.retrieve_staffs", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+			user_service.EnsureRequiredResourcePermissionsProcessHandler(userMgr, []string{
 				"MERCHANT.STAFF.RETRIEVE",
+			}, func(_ rest_json_rpc.Context, p interface{}) (string, error) {
+				return p.(*merchant_service.RetrieveStaffsParam).MerchantID, nil
 			}),
-			merchant_service.EnsureResourceBelongToCurrentMerchantProcessHandler(mcMgr, func(_ echo.Context, p interface{}, merchantID string) bool {
+			merchant_service.EnsureResourceBelongToCurrentMerchantProcessHandler(mcMgr, func(_ rest_json_rpc.Context, p interface{}, merchantID string) bool {
 				param := p.(*merchant_service.RetrieveStaffsParam)
 				return param.MerchantID == merchantID
 			}),
@@ -479,9 +1250,16 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.RetrieveStaffsParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+			merchant_service.FAIL_CD_CURRENT_USER_UNBOUNDED_MERCHANTS,
+			merchant_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_MERCHANT,
+		},
 	})
 	rpc.RegisterProcess("merchant.update", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "merchant.update"),
 			user_service.EnsureLoggedInProcessHandler(),
 			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
 				"MERCHANT.MODIFY",
@@ -491,14 +1269,19 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.UpdateParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
 	})
 
-	mcOrderMgr := merchant_business.NewMongoDBMerchantOrderManager(mgoConn.DB("mxsiamgp"), mcMgr, orderMgr)
+	mcOrderMgr := merchant_business.NewMongoDBMerchantOrderManager(db, mcMgr, orderMgr)
 
 	rpc.RegisterProcess("merchant.create_merchant_order", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "merchant.create_merchant_order"),
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ echo.Context, p interface{}, userID string) bool {
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
 				param := p.(*merchant_service.CreateMerchantOrderParam)
 				return param.UserID == userID
 			}),
@@ -507,6 +1290,10 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &merchant_service.CreateMerchantOrderParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+		},
 	})
 
 	// 微信模块过程
@@ -534,7 +1321,7 @@ func main() {
 		Handlers: []rest_json_rpc.ProcessHandler{
 			user_service.EnsureWechatAuthorizedProcessHandler(wcCli, wcAuthURL.String(), "snsapi_userinfo", ""),
 			user_service.EnsureLoggedInProcessHandler(),
-			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ echo.Context, p interface{}, userID string) bool {
+			user_service.EnsureResourceBelongToCurrentUserProcessHandler(userMgr, func(_ rest_json_rpc.Context, p interface{}, userID string) bool {
 				param := p.(*order_service.GetAllOrdersByUserIDParam)
 				return param.UserID == userID
 			}),
@@ -543,15 +1330,21 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &order_service.GetAllOrdersByUserIDParam{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_WECHAT_REDIRECT,
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER,
+		},
 	})
 
 	wcPayNotifyCbURL := &url.URL{
 		Scheme: "http",
-		Host: v.GetString("backend.host"),
-		Path: "/order/wechat_pay_notify_callback",
+		Host: tenantCfg.Backend.Host,
+		Path: tenantCfg.BackendPath("/order/wechat_pay_notify_callback"),
 	}
 	rpc.RegisterProcess("order.pay_by_wechat_h5", &rest_json_rpc.Process{
 		Handlers: []rest_json_rpc.ProcessHandler{
+			audit_service.AuditProcessHandler(auditMgr, "order.pay_by_wechat_h5"),
 			user_service.EnsureWechatAuthorizedProcessHandler(wcCli, wcAuthURL.String(), "snsapi_userinfo", ""),
 			user_service.EnsureLoggedInProcessHandler(),
 			order_service.PayByWechatH5ProcessHandler(orderMgr, wcPayNotifyCbURL.String()),
@@ -559,8 +1352,33 @@ func main() {
 		ParamFactory: func() interface{} {
 			return &order_service.PayByWechatH5Param{}
 		},
+		FailCodes: []string{
+			user_service.FAIL_CD_WECHAT_REDIRECT,
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			order_business.FAIL_CD_NO_SUCH_ORDER,
+			order_business.FAIL_CD_ORDER_STATUS_NOT_BE_UNPAID,
+			order_business.FAIL_CD_CREATE_WECHAT_PAY_ORDER_FAIL,
+		},
 	})
 	e.POST("/order/wechat_pay_notify_callback", order_service.WechatPayNotifyCallbackHandlerFunc(orderMgr, wcPayCli))
 
-	e.Run(echo_fasthttp.New(fmt.Sprintf("127.0.0.1:%d", v.GetInt("listen.port"))))
+	// 审计模块过程
+	rpc.RegisterProcess("audit.retrieve", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			user_service.EnsureLoggedInProcessHandler(),
+			user_service.EnsureRequiredPermissionsProcessHandler(userMgr, []string{
+				"AUDIT.RETRIEVE",
+			}),
+			audit_service.RetrieveProcessHandler(auditMgr),
+		},
+		ParamFactory: func() interface{} {
+			return &audit_service.RetrieveParam{}
+		},
+		FailCodes: []string{
+			user_service.FAIL_CD_USER_NOT_LOGGED_IN,
+			user_service.FAIL_CD_PERMISSION_DENIED,
+		},
+	})
+
+	return e
 }
@@ -0,0 +1,54 @@
+package business
+
+import (
+	"wawa_b.v1/module/audit/domain"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// 审计日志管理器
+type AuditLogManager interface {
+	// 插入一条审计日志
+	Insert(log *domain.AuditLog) error
+
+	// 检索审计日志，按时间倒序
+	Retrieve(lastID *string, limit int, userID, process string) ([]*domain.AuditLog, error)
+}
+
+// MongoDB审计日志管理器
+type MongoDBAuditLogManager struct {
+	// 审计日志集合
+	auditLogCollection *mgo.Collection
+}
+
+// 创建一个MongoDB审计日志管理器
+func NewMongoDBAuditLogManager(mxsDB *mgo.Database) *MongoDBAuditLogManager {
+	return &MongoDBAuditLogManager{
+		auditLogCollection: mxsDB.C("AuditLogs"),
+	}
+}
+
+func (mgr *MongoDBAuditLogManager) Insert(log *domain.AuditLog) error {
+	return mgr.auditLogCollection.Insert(log)
+}
+
+func (mgr *MongoDBAuditLogManager) Retrieve(lastID *string, limit int, userID, process string) ([]*domain.AuditLog, error) {
+	logs := make([]*domain.AuditLog, 0)
+	query := bson.M{}
+	if lastID != nil {
+		query["_id"] = bson.M{
+			"$lt": bson.ObjectIdHex(*lastID),
+		}
+	}
+	if len(userID) != 0 {
+		query["userId"] = bson.ObjectIdHex(userID)
+	}
+	if len(process) != 0 {
+		query["process"] = process
+	}
+	if err := mgr.auditLogCollection.Find(query).Sort("-_id").Limit(limit).All(&logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// 审计日志
+type AuditLog struct {
+	// ID
+	ID          bson.ObjectId `bson:"_id,omitempty" json:"id"`
+
+	// 调用者用户ID，未登录时为空
+	UserID      *bson.ObjectId `bson:"userId" json:"user_id"`
+
+	// 过程名
+	Process     string `bson:"process" json:"process"`
+
+	// 脱敏后的参数
+	Param       interface{} `bson:"param" json:"param"`
+
+	// 状态代码
+	StatusCode  string `bson:"statusCode" json:"status_code"`
+
+	// 脱敏后的结果，仅成功时记录
+	Result      interface{} `bson:"result,omitempty" json:"result,omitempty"`
+
+	// 失败代码，仅失败时记录
+	FailCode    string `bson:"failCode,omitempty" json:"fail_code,omitempty"`
+
+	// 耗时（毫秒）
+	Duration    int64 `bson:"duration" json:"duration"`
+
+	// 远端IP
+	RemoteIP    string `bson:"remoteIp" json:"remote_ip"`
+
+	// 创建时间
+	CreatedTime time.Time `bson:"createdTime" json:"created_time"`
+}
@@ -0,0 +1,17 @@
+db.createCollection('AuditLogs');
+db.AuditLogs.ensureIndex({
+    userId: 1,
+    _id: -1
+});
+db.AuditLogs.ensureIndex({
+    process: 1,
+    _id: -1
+});
+
+db.Roles.update({
+    name: 'SPONSOR_ADMIN'
+}, {
+    $addToSet: {
+        permissions: 'AUDIT.RETRIEVE'
+    }
+});
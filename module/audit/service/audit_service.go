@@ -0,0 +1,145 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"wawa_b.v1/module/audit/business"
+	"wawa_b.v1/module/audit/domain"
+	"wawa_b.v1/module/log"
+	"wawa_b.v1/module/rest_json_rpc"
+	"wawa_b.v1/module/rest_json_rpc/failure"
+	user_service "wawa_b.v1/module/user/service"
+
+	"github.com/pquerna/ffjson/ffjson"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// 脱敏后的替代值
+const REDACTED = "***"
+
+// 需要脱敏的字段名关键字，字段名包含任一关键字即脱敏
+var sensitiveKeywords = []string{
+	"password",
+	"captcha",
+	"secret",
+	"token",
+}
+
+// 审计
+// 记录调用者、过程名、脱敏后的参数、结果或失败代码、耗时与远端IP，应置于处理器链的首位
+// 审计日志写入失败时仅记录日志，不影响调用
+func AuditProcessHandler(auditMgr business.AuditLogManager, procName string) rest_json_rpc.ProcessHandler {
+	logger := log.GetLogger("audit.auditService")
+
+	return func(ctx rest_json_rpc.Context, p interface{}, ch *rest_json_rpc.ProcessChain) (result interface{}, err error) {
+		auditLog := &domain.AuditLog{
+			Process: procName,
+			Param: Sanitize(p),
+			RemoteIP: ctx.RemoteIP(),
+			CreatedTime: time.Now(),
+		}
+		userID := new(bson.ObjectId)
+		if ctx.Session().Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
+			auditLog.UserID = userID
+		}
+
+		defer func() {
+			r := recover()
+
+			auditLog.Duration = int64(time.Since(auditLog.CreatedTime) / time.Millisecond)
+			switch {
+			case r != nil:
+				auditLog.StatusCode = rest_json_rpc.STAT_CD_FAIL
+				auditLog.FailCode = rest_json_rpc.FAIL_CD_INTERNAL_ERROR
+				if f, ok := r.(*failure.Failure); ok {
+					auditLog.FailCode = f.Code
+				}
+			case err != nil:
+				auditLog.StatusCode = rest_json_rpc.STAT_CD_FAIL
+				auditLog.FailCode = rest_json_rpc.FAIL_CD_INTERNAL_ERROR
+				if f, ok := err.(*failure.Failure); ok {
+					auditLog.FailCode = f.Code
+				}
+			default:
+				auditLog.StatusCode = rest_json_rpc.STAT_CD_OK
+				auditLog.Result = Sanitize(result)
+			}
+
+			if insErr := auditMgr.Insert(auditLog); insErr != nil {
+				logger.WithField("process", procName).WithError(insErr).Error("写入审计日志失败")
+			}
+
+			if r != nil {
+				panic(r)
+			}
+		}()
+
+		return ch.Next()
+	}
+}
+
+// 脱敏
+// 将值按JSON结构展开，字段名包含敏感关键字的值替换为REDACTED
+func Sanitize(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	vJSON, err := ffjson.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var tree interface{}
+	if err := ffjson.Unmarshal(vJSON, &tree); err != nil {
+		return nil
+	}
+	return sanitizeTree(tree)
+}
+
+func sanitizeTree(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, val := range node {
+			if isSensitiveKey(key) {
+				node[key] = REDACTED
+			} else {
+				node[key] = sanitizeTree(val)
+			}
+		}
+	case []interface{}:
+		for i, val := range node {
+			node[i] = sanitizeTree(val)
+		}
+	}
+	return v
+}
+
+func isSensitiveKey(key string) bool {
+	lowerKey := strings.ToLower(key)
+	for _, kw := range sensitiveKeywords {
+		if strings.Contains(lowerKey, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+type RetrieveParam struct {
+	// 最后一个ID
+	LastID  *string `json:"last_id" validate:"objectid"`
+
+	// 调用者用户ID
+	UserID  string `json:"user_id" validate:"objectid"`
+
+	// 过程名
+	Process string `json:"process"`
+}
+
+// 检索审计日志
+func RetrieveProcessHandler(auditMgr business.AuditLogManager) rest_json_rpc.ProcessHandler {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*RetrieveParam)
+		return auditMgr.Retrieve(param.LastID, 15, param.UserID, param.Process)
+	}
+}
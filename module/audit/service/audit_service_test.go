@@ -0,0 +1,41 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sanitizeTestParam struct {
+	Name              string              `json:"name"`
+	Password          string              `json:"password"`
+	MobileCaptchaCode string              `json:"mobile_captcha_code"`
+	Items             []map[string]string `json:"items"`
+}
+
+func TestSanitize(t *testing.T) {
+	assert.Nil(t, Sanitize(nil))
+	assert.Equal(t, "text", Sanitize("text"))
+
+	assert.Equal(t, map[string]interface{}{
+		"name": "admin",
+		"password": REDACTED,
+		"mobile_captcha_code": REDACTED,
+		"items": []interface{}{
+			map[string]interface{}{
+				"access_token": REDACTED,
+				"open_id": "o1",
+			},
+		},
+	}, Sanitize(&sanitizeTestParam{
+		Name: "admin",
+		Password: "secret",
+		MobileCaptchaCode: "123456",
+		Items: []map[string]string{
+			{
+				"access_token": "t",
+				"open_id": "o1",
+			},
+		},
+	}))
+}
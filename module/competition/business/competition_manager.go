@@ -3,6 +3,7 @@ package business
 import (
 	"wawa_b.v1/module/competition/domain"
 	"wawa_b.v1/module/js_regex"
+	"wawa_b.v1/module/rest_json_rpc/event"
 	"wawa_b.v1/module/rest_json_rpc/failure"
 
 	"gopkg.in/mgo.v2"
@@ -15,48 +16,68 @@ const (
 	FAIL_CD_DUPLICATE_COMPETITION_NAME = "COMPETITION.DUPLICATE_COMPETITION_NAME"
 )
 
+// 主题名
+const (
+	// 赛事已完成，事件为*CompetitionFinishedEvent
+	TOPIC_NAME_COMPETITION_FINISHED = "competition.finished"
+)
+
+// 赛事已完成事件
+type CompetitionFinishedEvent struct {
+	// 赛事ID
+	CompetitionID string `json:"competition_id"`
+}
+
 // 赛事管理器
 type CompetitionManager interface {
 	// 添加一个新赛事
 	Add(name string, tickets []*domain.Ticket) error
 
 	// 删除一个赛事
-	Delete(id string)
+	Delete(id string) error
 
 	// 完成一个赛事
-	Finish(id string)
+	Finish(id string) error
 
-	// 获取一个赛事
-	Get(id string) *domain.Competition
+	// 获取一个赛事，不存在时返回nil
+	Get(id string) (*domain.Competition, error)
 
-	// 根据赛事名获取一个赛事
-	GetByName(name string) *domain.Competition
+	// 根据赛事名获取一个赛事，不存在时返回nil
+	GetByName(name string) (*domain.Competition, error)
 
 	// 列出正在进行的赛事
-	ListInProgress() []*domain.Competition
+	ListInProgress() ([]*domain.Competition, error)
 
 	// 检索赛事
-	Retrieve(lastID *string, limit int, name string) []*domain.Competition
+	Retrieve(lastID *string, limit int, name string) ([]*domain.Competition, error)
 
 	// 更新一个赛事的门票集
-	UpdateTickets(id string, tickets []*domain.Ticket)
+	UpdateTickets(id string, tickets []*domain.Ticket) error
 }
 
 // MongoDB赛事管理器
 type MongoDBCompetitionManager struct {
 	// 赛事集合
 	competitionCollection *mgo.Collection
+
+	// 事件发布器
+	eventPublisher        event.Publisher
 }
 
 // 创建一个MongoDB赛事管理器
-func NewMongoDBCompetitionManager(mxsDB *mgo.Database) *MongoDBCompetitionManager {
+func NewMongoDBCompetitionManager(mxsDB *mgo.Database, evtPub event.Publisher) *MongoDBCompetitionManager {
 	return &MongoDBCompetitionManager{
 		competitionCollection: mxsDB.C("Competitions"),
+		eventPublisher: evtPub,
 	}
 }
 
 func (mgr *MongoDBCompetitionManager) Add(name string, tickets []*domain.Ticket) error {
-	if mgr.GetByName(name) != nil {
+	existing, err := mgr.GetByName(name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
 		return failure.New(FAIL_CD_DUPLICATE_COMPETITION_NAME)
 	}
 
@@ -64,68 +85,67 @@ func (mgr *MongoDBCompetitionManager) Add(name string, tickets []*domain.Ticket)
 		ticket.ID = bson.NewObjectId()
 	}
 
-	if err := mgr.competitionCollection.Insert(&domain.Competition{
+	return mgr.competitionCollection.Insert(&domain.Competition{
 		Name: name,
 		IsFinished: false,
 		Tickets: tickets,
-	}); err != nil {
-		panic(err)
-	}
-
-	return nil
+	})
 }
 
-func (mgr *MongoDBCompetitionManager) Delete(id string) {
-	if err := mgr.competitionCollection.RemoveId(bson.ObjectIdHex(id)); err != nil {
-		panic(err)
-	}
+func (mgr *MongoDBCompetitionManager) Delete(id string) error {
+	return mgr.competitionCollection.RemoveId(bson.ObjectIdHex(id))
 }
 
-func (mgr *MongoDBCompetitionManager) Finish(id string) {
+func (mgr *MongoDBCompetitionManager) Finish(id string) error {
 	if err := mgr.competitionCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
 		"$set": bson.M{
 			"isFinished": true,
 		},
 	}); err != nil {
-		panic(err)
+		return err
 	}
+
+	mgr.eventPublisher.Publish(TOPIC_NAME_COMPETITION_FINISHED, &CompetitionFinishedEvent{
+		CompetitionID: id,
+	})
+	return nil
 }
 
-func (mgr *MongoDBCompetitionManager) Get(id string) *domain.Competition {
+func (mgr *MongoDBCompetitionManager) Get(id string) (*domain.Competition, error) {
 	competitions := make([]*domain.Competition, 0)
 	if err := mgr.competitionCollection.FindId(bson.ObjectIdHex(id)).All(&competitions); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(competitions) == 0 {
-		return nil
+		return nil, nil
 	}
-	return competitions[0]
+	return competitions[0], nil
 }
 
-func (mgr *MongoDBCompetitionManager) GetByName(name string) *domain.Competition {
+func (mgr *MongoDBCompetitionManager) GetByName(name string) (*domain.Competition, error) {
 	competitions := make([]*domain.Competition, 0)
 	if err := mgr.competitionCollection.Find(bson.M{
 		"name": name,
 	}).All(&competitions); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(competitions) == 0 {
-		return nil
+		return nil, nil
 	}
-	return competitions[0]
+	return competitions[0], nil
 }
 
-func (mgr *MongoDBCompetitionManager) ListInProgress() []*domain.Competition {
+func (mgr *MongoDBCompetitionManager) ListInProgress() ([]*domain.Competition, error) {
 	competitions := make([]*domain.Competition, 0)
 	if err := mgr.competitionCollection.Find(bson.M{
 		"isFinished": false,
 	}).Sort("_id").All(&competitions); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return competitions
+	return competitions, nil
 }
 
-func (mgr *MongoDBCompetitionManager) Retrieve(lastID *string, limit int, name string) []*domain.Competition {
+func (mgr *MongoDBCompetitionManager) Retrieve(lastID *string, limit int, name string) ([]*domain.Competition, error) {
 	competitions := make([]*domain.Competition, 0)
 	query := bson.M{}
 	if lastID != nil {
@@ -139,20 +159,18 @@ func (mgr *MongoDBCompetitionManager) Retrieve(lastID *string, limit int, name s
 		}
 	}
 	if err := mgr.competitionCollection.Find(query).Sort("_id").Limit(limit).All(&competitions); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return competitions
+	return competitions, nil
 }
 
-func (mgr *MongoDBCompetitionManager) UpdateTickets(id string, tickets []*domain.Ticket) {
+func (mgr *MongoDBCompetitionManager) UpdateTickets(id string, tickets []*domain.Ticket) error {
 	for _, ticket := range tickets {
 		ticket.ID = bson.NewObjectId()
 	}
-	if err := mgr.competitionCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
+	return mgr.competitionCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
 		"$set": bson.M{
 			"tickets": tickets,
 		},
-	}); err != nil {
-		panic(err)
-	}
+	})
 }
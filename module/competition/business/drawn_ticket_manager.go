@@ -15,18 +15,18 @@ import (
 // 门票管理器
 type DrawnTicketManager interface {
 	// 创建订单
-	CreateOrder(userID string, competitionID string, tickets []*CreateOrderTicket)
+	CreateOrder(userID string, competitionID string, tickets []*CreateOrderTicket) error
 
 	// 根据赛事ID与用户ID获取所有门票
-	GetAllByCompetitionIDAndUserID(competitionID, userID string) []*competition_domain.DrawnTicket
+	GetAllByCompetitionIDAndUserID(competitionID, userID string) ([]*competition_domain.DrawnTicket, error)
 
 	// 订单项目支付通知回调
 	OrderItemPayNotifyCallback() order_business.OrderItemPayNotifyCallback
 }
 
 type CreateOrderTicket struct {
-	TicketID string `json:"ticket_id"`
-	Quantity int `json:"quantity"`
+	TicketID string `json:"ticket_id" validate:"required,objectid"`
+	Quantity int `json:"quantity" validate:"min=1"`
 }
 
 // MongoDB门票管理器
@@ -50,11 +50,14 @@ func NewMongoDBDrawnTicketManager(mxsDB *mgo.Database, cmptMgr CompetitionManage
 	}
 }
 
-func (mgr *MongoDBDrawnTicketManager) CreateOrder(userID string, cmptID string, ts []*CreateOrderTicket) {
+func (mgr *MongoDBDrawnTicketManager) CreateOrder(userID string, cmptID string, ts []*CreateOrderTicket) error {
 	orderItems := make([]*order_domain.OrderItem, 0, len(ts))
-	cmpt := mgr.competitionManager.Get(cmptID)
+	cmpt, err := mgr.competitionManager.Get(cmptID)
+	if err != nil {
+		return err
+	}
 	if cmpt == nil {
-		panic(errors.New("无效的赛事ID"))
+		return errors.New("无效的赛事ID")
 	}
 
 	tickets := map[string]*competition_domain.Ticket{}
@@ -66,7 +69,7 @@ func (mgr *MongoDBDrawnTicketManager) CreateOrder(userID string, cmptID string,
 	for _, item := range ts {
 		t, ok := tickets[item.TicketID]
 		if !ok {
-			panic(errors.New("无效的门票ID"))
+			return errors.New("无效的门票ID")
 		}
 
 		valBytes, err := ffjson.Marshal(&competition_domain.DrawnTicket{
@@ -78,7 +81,7 @@ func (mgr *MongoDBDrawnTicketManager) CreateOrder(userID string, cmptID string,
 			Quantity: item.Quantity,
 		})
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		orderItems = append(orderItems, &order_domain.OrderItem{
@@ -89,26 +92,30 @@ func (mgr *MongoDBDrawnTicketManager) CreateOrder(userID string, cmptID string,
 		})
 	}
 
-	mgr.orderManager.Create(userID, orderItems)
+	_, err = mgr.orderManager.Create(userID, orderItems)
+	return err
 }
 
-func (mgr *MongoDBDrawnTicketManager) GetAllByCompetitionIDAndUserID(cmptID, userID string) []*competition_domain.DrawnTicket {
+func (mgr *MongoDBDrawnTicketManager) GetAllByCompetitionIDAndUserID(cmptID, userID string) ([]*competition_domain.DrawnTicket, error) {
 	tickets := make([]*competition_domain.DrawnTicket, 0)
 	query := bson.M{
 		"competitionId": bson.ObjectIdHex(cmptID),
 		"userId": bson.ObjectIdHex(userID),
 	}
 	if err := mgr.drawnTicketCollection.Find(query).Sort("_id").All(&tickets); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return tickets
+	return tickets, nil
 }
 
 func (mgr *MongoDBDrawnTicketManager) OrderItemPayNotifyCallback() order_business.OrderItemPayNotifyCallback {
-	return func(orderID, orderItemID string) {
-		order := mgr.orderManager.Get(orderID)
+	return func(orderID, orderItemID string) error {
+		order, err := mgr.orderManager.Get(orderID)
+		if err != nil {
+			return err
+		}
 		if order == nil {
-			return
+			return nil
 		}
 
 		var orderItem *order_domain.OrderItem
@@ -119,12 +126,12 @@ func (mgr *MongoDBDrawnTicketManager) OrderItemPayNotifyCallback() order_busines
 			}
 		}
 		if orderItem == nil || orderItem.SellableType != "COMPETITION.TICKET" {
-			return
+			return nil
 		}
 
 		ticket := &competition_domain.DrawnTicket{}
 		if err := ffjson.Unmarshal([]byte(orderItem.SellableValue), ticket); err != nil {
-			return
+			return nil
 		}
 		ticket.OrderID = order.ID
 		ticket.OrderItemID = orderItem.ID
@@ -137,12 +144,14 @@ func (mgr *MongoDBDrawnTicketManager) OrderItemPayNotifyCallback() order_busines
 				"orderItemId": ticket.OrderItemID,
 			}).Count()
 			if findErr != nil {
-				panic(findErr)
+				return findErr
 			}
 
 			if n == 0 {
-				panic(insErr)
+				return insErr
 			}
 		}
+
+		return nil
 	}
 }
@@ -1,6 +1,7 @@
 package business
 
 import (
+	"wawa_b.v1/module/rest_json_rpc/event"
 	"wawa_b.v1/module/rest_json_rpc/failure"
 
 	"gopkg.in/mgo.v2"
@@ -13,6 +14,21 @@ const (
 	FAIL_CD_INSPECTION_INSPECTED = "COMPETITION.INSPECTION.INSPECTED"
 )
 
+// 主题名
+const (
+	// 已验票，事件为*InspectedEvent
+	TOPIC_NAME_INSPECTION_INSPECTED = "competition.inspection.inspected"
+)
+
+// 已验票事件
+type InspectedEvent struct {
+	// 赛事ID
+	CompetitionID string `json:"competition_id"`
+
+	// 用户ID
+	UserID        string `json:"user_id"`
+}
+
 // 验票管理器
 type InspectionManager interface {
 	// 标记已验票
@@ -21,13 +37,17 @@ type InspectionManager interface {
 
 // MongoDB验票管理器
 type MongoDBInspectionManager struct {
+	// 事件发布器
+	eventPublisher       event.Publisher
+
 	// 验票集合
 	inspectionCollection *mgo.Collection
 }
 
 // 创建一个MongoDB验票管理器
-func NewMongoDBInspectionManager(mxsDB *mgo.Database) *MongoDBInspectionManager {
+func NewMongoDBInspectionManager(mxsDB *mgo.Database, evtPub event.Publisher) *MongoDBInspectionManager {
 	return &MongoDBInspectionManager{
+		eventPublisher: evtPub,
 		inspectionCollection: mxsDB.C("Inspections"),
 	}
 }
@@ -38,7 +58,7 @@ func (mgr *MongoDBInspectionManager) MarkInspected(competitionID, userID string)
 		"userId": bson.ObjectIdHex(userID),
 	}).Count()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// 已验票
@@ -50,8 +70,12 @@ func (mgr *MongoDBInspectionManager) MarkInspected(competitionID, userID string)
 		"competitionId": bson.ObjectIdHex(competitionID),
 		"userId": bson.ObjectIdHex(userID),
 	}); err != nil {
-		panic(err)
+		return err
 	}
 
+	mgr.eventPublisher.Publish(TOPIC_NAME_INSPECTION_INSPECTED, &InspectedEvent{
+		CompetitionID: competitionID,
+		UserID: userID,
+	})
 	return nil
 }
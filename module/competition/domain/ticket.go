@@ -8,11 +8,11 @@ type Ticket struct {
 	ID          bson.ObjectId `bson:"_id,omitempty" json:"id"`
 
 	// 门票名
-	Name        string `bson:"name" json:"name"`
+	Name        string `bson:"name" json:"name" validate:"required"`
 
 	// 描述
 	Description string `bson:"description" json:"description"`
 
 	// 价格
-	PriceFee    int `bson:"priceFee" json:"price_fee"`
+	PriceFee    int `bson:"priceFee" json:"price_fee" validate:"min=0"`
 }
@@ -4,17 +4,15 @@ import (
 	competition_business "wawa_b.v1/module/competition/business"
 	competition_domain "wawa_b.v1/module/competition/domain"
 	"wawa_b.v1/module/rest_json_rpc"
-	"wawa_b.v1/module/session"
 	user_service "wawa_b.v1/module/user/service"
 
-	"github.com/labstack/echo"
 	"github.com/pkg/errors"
 	"gopkg.in/mgo.v2/bson"
 )
 
 type AddParam struct {
 	// 赛事名
-	Name    string `json:"name"`
+	Name    string `json:"name" validate:"required,maxlen=64"`
 
 	// 门票集
 	Tickets []*competition_domain.Ticket `json:"tickets"`
@@ -22,74 +20,67 @@ type AddParam struct {
 
 // 添加一个新赛事
 func AddProcessHandler(cmptMgr competition_business.CompetitionManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*AddParam)
-		if err := cmptMgr.Add(param.Name, param.Tickets); err != nil {
-			panic(err)
-		}
-		return nil
+		return nil, cmptMgr.Add(param.Name, param.Tickets)
 	}
 }
 
 type CreateOrderParam struct {
-	CompetitionID string `json:"competition_id"`
-	Tickets       []*competition_business.CreateOrderTicket `json:"tickets"`
+	CompetitionID string `json:"competition_id" validate:"required,objectid"`
+	Tickets       []*competition_business.CreateOrderTicket `json:"tickets" validate:"required"`
 }
 
 // 创建一个订单
 // + 确保登录
 func CreateOrderProcessHandler(ticketMgr competition_business.DrawnTicketManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*CreateOrderParam)
 
-		sess := session.GetSessionByContext(ctx)
+		sess := ctx.Session()
 		userID := new(bson.ObjectId)
 		if !sess.Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
-			panic(errors.New("请确保用户已登录"))
+			return nil, errors.New("请确保用户已登录")
 		}
 
-		ticketMgr.CreateOrder(userID.Hex(), param.CompetitionID, param.Tickets)
-
-		return nil
+		return nil, ticketMgr.CreateOrder(userID.Hex(), param.CompetitionID, param.Tickets)
 	}
 }
 
 type DeleteParam struct {
 	// ID
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required,objectid"`
 }
 
 // 删除一个赛事
 func DeleteProcessHandler(cmptMgr competition_business.CompetitionManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*DeleteParam)
-		cmptMgr.Delete(param.ID)
-		return nil
+		return nil, cmptMgr.Delete(param.ID)
 	}
 }
 
 type FinishParam struct {
 	// ID
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required,objectid"`
 }
 
 // 完成一个赛事
 func FinishProcessHandler(cmptMgr competition_business.CompetitionManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*FinishParam)
-		cmptMgr.Finish(param.ID)
-		return nil
+		return nil, cmptMgr.Finish(param.ID)
 	}
 }
 
 type GetParam struct {
 	// ID
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required,objectid"`
 }
 
 // 获取一个赛事
 func GetProcessHandler(cmptMgr competition_business.CompetitionManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*GetParam)
 		return cmptMgr.Get(param.ID)
 	}
@@ -99,14 +90,14 @@ type ListInProgressParam struct{}
 
 // 列出正在进行的赛事
 func ListInProgressProcessHandler(cmptMgr competition_business.CompetitionManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		return cmptMgr.ListInProgress()
 	}
 }
 
 type RetrieveParam struct {
 	// 最后一个ID
-	LastID *string `json:"last_id"`
+	LastID *string `json:"last_id" validate:"objectid"`
 
 	// 赛事名
 	Name   string `json:"name"`
@@ -114,7 +105,7 @@ type RetrieveParam struct {
 
 // 检索赛事
 func RetrieveProcessHandler(cmptMgr competition_business.CompetitionManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*RetrieveParam)
 		return cmptMgr.Retrieve(param.LastID, 15, param.Name)
 	}
@@ -122,7 +113,7 @@ func RetrieveProcessHandler(cmptMgr competition_business.CompetitionManager) res
 
 type UpdateTicketsParam struct {
 	// ID
-	ID      string `json:"id"`
+	ID      string `json:"id" validate:"required,objectid"`
 
 	// 门票集
 	Tickets []*competition_domain.Ticket `json:"tickets"`
@@ -130,9 +121,8 @@ type UpdateTicketsParam struct {
 
 // 更新一个赛事的门票集
 func UpdateTicketsProcessHandler(cmptMgr competition_business.CompetitionManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*UpdateTicketsParam)
-		cmptMgr.UpdateTickets(param.ID, param.Tickets)
-		return nil
+		return nil, cmptMgr.UpdateTickets(param.ID, param.Tickets)
 	}
 }
@@ -3,22 +3,17 @@ package service
 import (
 	"wawa_b.v1/module/competition/business"
 	"wawa_b.v1/module/rest_json_rpc"
-
-	"github.com/labstack/echo"
 )
 
 type MarkInspectedParam struct {
-	CompetitionID string `json:"competition_id"`
-	UserID        string `json:"user_id"`
+	CompetitionID string `json:"competition_id" validate:"required,objectid"`
+	UserID        string `json:"user_id" validate:"required,objectid"`
 }
 
 // 根据赛事ID与用户ID获取所有门票
 func MarkInspectedProcessHandler(inspectMgr business.InspectionManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*MarkInspectedParam)
-		if err := inspectMgr.MarkInspected(param.CompetitionID, param.UserID); err != nil {
-			panic(err)
-		}
-		return nil
+		return nil, inspectMgr.MarkInspected(param.CompetitionID, param.UserID)
 	}
 }
@@ -0,0 +1,492 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 应用配置
+// 由viper解码得到，时长的单位均为毫秒
+// CORS、日志、短信模板、限流及微信H5支付网页标题可热更新，其余配置的变更须重启生效
+type AppConfig struct {
+	// 后端
+	Backend  HostConfig               `mapstructure:"backend"`
+
+	// 前端
+	Frontend HostConfig               `mapstructure:"frontend"`
+
+	// CORS
+	CORS     CORSConfig               `mapstructure:"cors"`
+
+	// 日志
+	Log      LogConfig                `mapstructure:"log"`
+
+	// 监听
+	Listen   ListenConfig             `mapstructure:"listen"`
+
+	// 数据库
+	Database DatabaseConfig           `mapstructure:"database"`
+
+	// 会话
+	Session  SessionConfig            `mapstructure:"session"`
+
+	// 密码
+	Password PasswordConfig           `mapstructure:"password"`
+
+	// 阿里大于
+	TOP      TOPConfig                `mapstructure:"top"`
+
+	// 微信公众号及微信支付
+	Wechat   WechatConfig             `mapstructure:"wechat"`
+
+	// 租户，键为租户ID，为空时以上述backend、frontend、cors、top、wechat配置作为唯一的默认租户
+	Tenants  map[string]*TenantConfig `mapstructure:"tenants"`
+}
+
+// 主机配置
+type HostConfig struct {
+	// 主机名，可带端口，不带协议及路径
+	Host string `mapstructure:"host"`
+}
+
+// CORS配置
+type CORSConfig struct {
+	// 允许的Origin集，为空时仅允许前端
+	AllowOrigins []string `mapstructure:"allowOrigins"`
+}
+
+// 日志配置
+type LogConfig struct {
+	// 默认级别
+	Level   string         `mapstructure:"level"`
+
+	// 各日志的级别，未列出的日志使用默认级别
+	Loggers []LoggerConfig `mapstructure:"loggers"`
+}
+
+// 单个日志的配置
+// 日志名含.且区分大小写，不能作为配置键，因此以数组配置
+type LoggerConfig struct {
+	// 日志名
+	Name  string `mapstructure:"name"`
+
+	// 级别
+	Level string `mapstructure:"level"`
+}
+
+// 监听配置
+type ListenConfig struct {
+	// 绑定地址，IP或主机名，0.0.0.0表示所有网卡
+	Address          string          `mapstructure:"address"`
+
+	// 端口
+	Port             int             `mapstructure:"port"`
+
+	// TLS，证书及私钥均配置时启用
+	TLS              ListenTLSConfig `mapstructure:"tls"`
+
+	// 优雅停机超时，收到SIGTERM后等待进行中的请求完成的最长时间
+	ShutdownTimeout  int             `mapstructure:"shutdownTimeout"`
+
+	// 受信任的代理，IP或CIDR，仅采用来自这些地址的X-Forwarded-For与X-Real-IP
+	TrustedProxies   []string        `mapstructure:"trustedProxies"`
+
+	// 允许访问/metrics的地址，IP或CIDR，按连接的远端地址判断，不采用X-Forwarded-For
+	MetricsAllowFrom []string        `mapstructure:"metricsAllowFrom"`
+
+	// 批量调用（含WebSocket帧）的最大调用项数，超过时整批失败
+	MaxBatchSize     int             `mapstructure:"maxBatchSize"`
+}
+
+// 监听TLS配置
+type ListenTLSConfig struct {
+	// PEM格式的证书文件（可含证书链）
+	CertFile string `mapstructure:"certFile"`
+
+	// PEM格式的私钥文件
+	KeyFile  string `mapstructure:"keyFile"`
+}
+
+// 是否启用TLS
+func (tlsCfg *ListenTLSConfig) Enabled() bool {
+	return len(tlsCfg.CertFile) != 0 && len(tlsCfg.KeyFile) != 0
+}
+
+// 数据库配置
+type DatabaseConfig struct {
+	// MongoDB
+	MongoDB ServerConfig `mapstructure:"mongodb"`
+}
+
+// 服务器配置
+type ServerConfig struct {
+	// 主机名
+	Host string `mapstructure:"host"`
+
+	// 端口
+	Port int    `mapstructure:"port"`
+}
+
+// 密码配置
+type PasswordConfig struct {
+	// 散列器
+	Hasher PasswordHasherConfig `mapstructure:"hasher"`
+}
+
+// 密码散列器配置
+// 新密码及登录时升级的旧密码使用algorithm指定的算法，已保存的其他算法的散列仍可校验
+type PasswordHasherConfig struct {
+	// 算法，argon2id或bcrypt
+	Algorithm string         `mapstructure:"algorithm"`
+
+	// bcrypt
+	Bcrypt    BcryptConfig   `mapstructure:"bcrypt"`
+
+	// argon2id
+	Argon2id  Argon2idConfig `mapstructure:"argon2id"`
+}
+
+// bcrypt配置
+type BcryptConfig struct {
+	// 成本，4～31
+	Cost int `mapstructure:"cost"`
+}
+
+// argon2id配置
+type Argon2idConfig struct {
+	// 内存，单位KiB
+	Memory      int `mapstructure:"memory"`
+
+	// 迭代次数
+	Iterations  int `mapstructure:"iterations"`
+
+	// 并行度，1～255
+	Parallelism int `mapstructure:"parallelism"`
+}
+
+// 会话配置
+type SessionConfig struct {
+	// Cookie
+	Cookie          SessionCookieConfig  `mapstructure:"cookie"`
+
+	// 空闲超时
+	IdleTimeout     int                  `mapstructure:"idleTimeout"`
+
+	// 绝对超时，为0时不限制
+	AbsoluteTimeout int                  `mapstructure:"absoluteTimeout"`
+
+	// 令牌
+	Token           SessionTokenConfig   `mapstructure:"token"`
+
+	// 存储器
+	Storage         SessionStorageConfig `mapstructure:"storage"`
+}
+
+// 会话Cookie配置
+type SessionCookieConfig struct {
+	// 键
+	Name     string `mapstructure:"name"`
+
+	// Domain属性
+	Domain   string `mapstructure:"domain"`
+
+	// Path属性
+	Path     string `mapstructure:"path"`
+
+	// Secure属性
+	Secure   bool   `mapstructure:"secure"`
+
+	// HttpOnly属性
+	HTTPOnly bool   `mapstructure:"httpOnly"`
+
+	// SameSite属性
+	SameSite string `mapstructure:"sameSite"`
+
+	// Max-Age属性，为0时为浏览器会话Cookie
+	MaxAge   int    `mapstructure:"maxAge"`
+}
+
+// 会话令牌配置
+type SessionTokenConfig struct {
+	// 刷新令牌的有效期
+	RefreshTimeout int `mapstructure:"refreshTimeout"`
+}
+
+// 会话存储器配置
+type SessionStorageConfig struct {
+	// 存储器类型，取值为redis、memory、mongodb
+	// 不支持Cookie会话存储器：注销、删除用户、修改及找回密码须在服务端吊销会话，而已签发的Cookie无法吊销
+	Kind  string       `mapstructure:"kind"`
+
+	// Redis，限流器也使用该Redis
+	Redis ServerConfig `mapstructure:"redis"`
+}
+
+// 阿里大于配置
+type TOPConfig struct {
+	// App Key
+	AppKey               string                     `mapstructure:"appKey"`
+
+	// App Secret
+	AppSecret            string                     `mapstructure:"appSecret"`
+
+	// 手机验证码短信
+	MobileCaptchaManager MobileCaptchaManagerConfig `mapstructure:"mobileCaptchaManager"`
+}
+
+// 手机验证码短信配置
+type MobileCaptchaManagerConfig struct {
+	// 用户模块
+	User UserMobileCaptchaConfig `mapstructure:"user"`
+}
+
+// 用户模块手机验证码短信配置
+type UserMobileCaptchaConfig struct {
+	// 注册
+	Register       SMSTemplateConfig `mapstructure:"register"`
+
+	// 找回密码
+	RetakePassword SMSTemplateConfig `mapstructure:"retakePassword"`
+}
+
+// 短信模板配置
+type SMSTemplateConfig struct {
+	// 模板代码
+	TemplateCode string `mapstructure:"templateCode"`
+
+	// 产品名
+	Product      string `mapstructure:"product"`
+
+	// 签名
+	Sign         string `mapstructure:"sign"`
+}
+
+// 微信配置
+type WechatConfig struct {
+	// 公众号AppID
+	AppID             string `mapstructure:"appId"`
+
+	// 公众号AppSecret
+	AppSecret         string `mapstructure:"appSecret"`
+
+	// 商户号
+	MchID             string `mapstructure:"mchId"`
+
+	// 商户API密钥
+	PartnerKey        string `mapstructure:"partnerKey"`
+
+	// 微信H5支付浏览器打开的移动网页的主页标题
+	H5PayWebpageTitle string `mapstructure:"h5PayWebpageTitle"`
+}
+
+// 配置校验失败
+// 包含全部问题，而非第一个问题
+type ValidationError struct {
+	// 问题，形如“配置键：说明”
+	Problems []string
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("配置校验失败，共%d个问题：\n  %s", len(err.Problems), strings.Join(err.Problems, "\n  "))
+}
+
+// 会话存储器类型
+var sessionStorageKinds = []string{"redis", "memory", "mongodb"}
+
+// 密码散列算法
+var passwordHasherAlgorithms = []string{"argon2id", "bcrypt"}
+
+// SameSite属性取值
+var sameSiteValues = []string{"", "Lax", "Strict", "None"}
+
+// 主机名（可带端口）
+var hostRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.\-]*[A-Za-z0-9])?(:[0-9]{1,5})?$`)
+
+// 主机名，不带端口
+var hostnameRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.\-]*[A-Za-z0-9])?$`)
+
+// 微信商户号
+var mchIDRegexp = regexp.MustCompile(`^[0-9]+$`)
+
+// 日志级别，键为日志名
+func (cfg *AppConfig) LogLevels() (logrus.Level, map[string]logrus.Level) {
+	defLvl, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		defLvl = logrus.InfoLevel
+	}
+	lvls := map[string]logrus.Level{}
+	for _, lgrCfg := range cfg.Log.Loggers {
+		if lvl, err := logrus.ParseLevel(lgrCfg.Level); err == nil {
+			lvls[lgrCfg.Name] = lvl
+		}
+	}
+	return defLvl, lvls
+}
+
+// 从viper解码并校验应用配置
+// 校验失败时返回*ValidationError
+func LoadAppConfig(v *viper.Viper) (*AppConfig, error) {
+	cfg := &AppConfig{}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("解码配置失败：%v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 校验应用配置
+func (cfg *AppConfig) Validate() error {
+	vld := &validator{}
+
+	vld.requireLevel("log.level", cfg.Log.Level)
+	for i, lgrCfg := range cfg.Log.Loggers {
+		vld.requireString(fmt.Sprintf("log.loggers[%d].name", i), lgrCfg.Name)
+		vld.requireLevel(fmt.Sprintf("log.loggers[%d].level", i), lgrCfg.Level)
+	}
+	if vld.requireString("listen.address", cfg.Listen.Address) && net.ParseIP(cfg.Listen.Address) == nil && !hostnameRegexp.MatchString(cfg.Listen.Address) {
+		vld.addProblem("listen.address", "应为IP或主机名，不带端口：%q", cfg.Listen.Address)
+	}
+	vld.requirePort("listen.port", cfg.Listen.Port)
+	if (len(cfg.Listen.TLS.CertFile) == 0) != (len(cfg.Listen.TLS.KeyFile) == 0) {
+		vld.addProblem("listen.tls", "certFile与keyFile须同时配置")
+	}
+	if cfg.Listen.ShutdownTimeout <= 0 {
+		vld.addProblem("listen.shutdownTimeout", "应为正数：%d", cfg.Listen.ShutdownTimeout)
+	}
+	for i, proxy := range cfg.Listen.TrustedProxies {
+		vld.requireIPNet(fmt.Sprintf("listen.trustedProxies[%d]", i), proxy)
+	}
+	for i, addr := range cfg.Listen.MetricsAllowFrom {
+		vld.requireIPNet(fmt.Sprintf("listen.metricsAllowFrom[%d]", i), addr)
+	}
+	if cfg.Listen.MaxBatchSize <= 0 {
+		vld.addProblem("listen.maxBatchSize", "应为正数：%d", cfg.Listen.MaxBatchSize)
+	}
+
+	vld.requireHost("database.mongodb.host", cfg.Database.MongoDB.Host)
+	vld.requirePort("database.mongodb.port", cfg.Database.MongoDB.Port)
+
+	sess := &cfg.Session
+	vld.requireString("session.cookie.name", sess.Cookie.Name)
+	vld.requireOneOf("session.cookie.sameSite", sess.Cookie.SameSite, sameSiteValues)
+	if sess.Cookie.SameSite == "None" && !sess.Cookie.Secure {
+		vld.addProblem("session.cookie.secure", "session.cookie.sameSite为None时须启用")
+	}
+	if sess.Cookie.MaxAge < 0 {
+		vld.addProblem("session.cookie.maxAge", "不能为负数：%d", sess.Cookie.MaxAge)
+	}
+	if sess.IdleTimeout <= 0 {
+		vld.addProblem("session.idleTimeout", "应为正数：%d", sess.IdleTimeout)
+	}
+	if sess.AbsoluteTimeout < 0 {
+		vld.addProblem("session.absoluteTimeout", "不能为负数：%d", sess.AbsoluteTimeout)
+	} else if sess.AbsoluteTimeout > 0 && sess.AbsoluteTimeout < sess.IdleTimeout {
+		vld.addProblem("session.absoluteTimeout", "不应小于session.idleTimeout：%d", sess.AbsoluteTimeout)
+	}
+	if sess.Storage.Kind == "cookie" {
+		vld.addProblem("session.storage.kind", "不支持cookie：Cookie会话无法在服务端吊销，注销、修改密码等操作不能使已签发的Cookie失效")
+	} else {
+		vld.requireOneOf("session.storage.kind", sess.Storage.Kind, sessionStorageKinds)
+	}
+	if sess.Token.RefreshTimeout <= 0 {
+		vld.addProblem("session.token.refreshTimeout", "应为正数：%d", sess.Token.RefreshTimeout)
+	}
+	// 限流器总是使用Redis
+	vld.requireHost("session.storage.redis.host", sess.Storage.Redis.Host)
+	vld.requirePort("session.storage.redis.port", sess.Storage.Redis.Port)
+
+	hasher := &cfg.Password.Hasher
+	vld.requireOneOf("password.hasher.algorithm", hasher.Algorithm, passwordHasherAlgorithms)
+	if hasher.Bcrypt.Cost < 4 || hasher.Bcrypt.Cost > 31 {
+		vld.addProblem("password.hasher.bcrypt.cost", "应为4～31之间的整数：%d", hasher.Bcrypt.Cost)
+	}
+	// argon2要求内存不少于8倍并行度
+	if hasher.Argon2id.Parallelism < 1 || hasher.Argon2id.Parallelism > 255 {
+		vld.addProblem("password.hasher.argon2id.parallelism", "应为1～255之间的整数：%d", hasher.Argon2id.Parallelism)
+	} else if hasher.Argon2id.Memory < 8 * hasher.Argon2id.Parallelism {
+		vld.addProblem("password.hasher.argon2id.memory", "不应小于8倍并行度：%d", hasher.Argon2id.Memory)
+	}
+	if hasher.Argon2id.Iterations <= 0 {
+		vld.addProblem("password.hasher.argon2id.iterations", "应为正数：%d", hasher.Argon2id.Iterations)
+	}
+
+	cfg.validateTenants(vld)
+
+	if len(vld.problems) != 0 {
+		return &ValidationError{
+			Problems: vld.problems,
+		}
+	}
+	return nil
+}
+
+// 配置校验器，收集全部问题
+type validator struct {
+	// 问题
+	problems []string
+}
+
+func (vld *validator) addProblem(key, format string, args ...interface{}) {
+	vld.problems = append(vld.problems, fmt.Sprintf("%s：%s", key, fmt.Sprintf(format, args...)))
+}
+
+func (vld *validator) requireString(key, val string) bool {
+	if len(strings.TrimSpace(val)) == 0 {
+		vld.addProblem(key, "不能为空")
+		return false
+	}
+	return true
+}
+
+func (vld *validator) requireHost(key, val string) {
+	if vld.requireString(key, val) && !hostRegexp.MatchString(val) {
+		vld.addProblem(key, "应为主机名（可带端口），不带协议及路径：%q", val)
+	}
+}
+
+func (vld *validator) requirePort(key string, val int) {
+	if val <= 0 || val > 65535 {
+		vld.addProblem(key, "应为1～65535之间的端口号：%d", val)
+	}
+}
+
+func (vld *validator) requireOneOf(key, val string, candidates []string) {
+	for _, c := range candidates {
+		if val == c {
+			return
+		}
+	}
+	vld.addProblem(key, "应为%s之一：%q", strings.Join(candidates, "、"), val)
+}
+
+func (vld *validator) requireLevel(key, val string) {
+	if _, err := logrus.ParseLevel(val); err != nil {
+		vld.addProblem(key, "应为debug、info、warning、error、fatal、panic之一：%q", val)
+	}
+}
+
+func (vld *validator) requireIPNet(key, val string) {
+	if _, _, err := net.ParseCIDR(val); err != nil && net.ParseIP(val) == nil {
+		vld.addProblem(key, "应为IP或CIDR：%q", val)
+	}
+}
+
+func (vld *validator) requireOrigin(key, val string) {
+	if u, err := url.Parse(val); err != nil || (u.Scheme != "http" && u.Scheme != "https") || !hostRegexp.MatchString(u.Host) || len(u.Path) != 0 {
+		vld.addProblem(key, "应为形如https://example.com的Origin：%q", val)
+	}
+}
+
+func (vld *validator) requireSMSTemplate(key string, tpl *SMSTemplateConfig) {
+	vld.requireString(key + ".templateCode", tpl.TemplateCode)
+	vld.requireString(key + ".product", tpl.Product)
+	vld.requireString(key + ".sign", tpl.Sign)
+}
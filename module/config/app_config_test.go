@@ -0,0 +1,142 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAppConfigJSON = `{
+  "backend": {"host": "b.example.com"},
+  "frontend": {"host": "f.example.com:8080"},
+  "listen": {"address": "0.0.0.0", "port": 443, "tls": {"certFile": "/etc/mxsiamgp/tls/cert.pem", "keyFile": "/etc/mxsiamgp/tls/key.pem"}, "shutdownTimeout": 30000, "maxBatchSize": 50},
+  "log": {"level": "info", "loggers": [{"name": "config.watcher", "level": "debug"}]},
+  "database": {"mongodb": {"host": "localhost", "port": 27017}},
+  "session": {
+    "cookie": {"name": "sid", "path": "/", "httpOnly": true, "sameSite": ""},
+    "idleTimeout": 1800000,
+    "absoluteTimeout": 43200000,
+    "token": {"refreshTimeout": 2592000000},
+    "storage": {"kind": "redis", "redis": {"host": "localhost", "port": 6379}}
+  },
+  "password": {"hasher": {"algorithm": "argon2id", "bcrypt": {"cost": 12}, "argon2id": {"memory": 65536, "iterations": 3, "parallelism": 2}}},
+  "top": {
+    "appKey": "key",
+    "appSecret": "secret",
+    "mobileCaptchaManager": {
+      "user": {
+        "register": {"templateCode": "SMS_1", "product": "p", "sign": "s"},
+        "retakePassword": {"templateCode": "SMS_2", "product": "p", "sign": "s"}
+      }
+    }
+  },
+  "wechat": {"appId": "wx", "appSecret": "secret", "mchId": "1360138702", "partnerKey": "0123456789abcdef0123456789abcdef", "h5PayWebpageTitle": "title"}
+}`
+
+func newTestViper(t *testing.T) *viper.Viper {
+	v := viper.New()
+	v.SetConfigType("json")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(testAppConfigJSON)))
+	return v
+}
+
+func TestLoadAppConfig(t *testing.T) {
+	cfg, err := LoadAppConfig(newTestViper(t))
+	assert.NoError(t, err)
+	assert.Equal(t, "f.example.com:8080", cfg.Frontend.Host)
+	assert.Equal(t, 27017, cfg.Database.MongoDB.Port)
+	assert.True(t, cfg.Listen.TLS.Enabled())
+	assert.Equal(t, 2592000000, cfg.Session.Token.RefreshTimeout)
+	assert.Equal(t, "SMS_2", cfg.TOP.MobileCaptchaManager.User.RetakePassword.TemplateCode)
+	assert.Equal(t, "1360138702", cfg.Wechat.MchID)
+	tenantCfgs := cfg.AllTenants()
+	if assert.Len(t, tenantCfgs, 1) {
+		assert.Equal(t, DEFAULT_TENANT_ID, tenantCfgs[0].ID)
+		assert.Equal(t, DEFAULT_DATABASE_NAME, tenantCfgs[0].Database)
+		assert.Equal(t, []string{"http://f.example.com:8080"}, tenantCfgs[0].AllowOrigins())
+	}
+
+	defLvl, lvls := cfg.LogLevels()
+	assert.Equal(t, logrus.InfoLevel, defLvl)
+	assert.Equal(t, map[string]logrus.Level{"config.watcher": logrus.DebugLevel}, lvls)
+}
+
+func TestLoadAppConfigProblems(t *testing.T) {
+	v := newTestViper(t)
+	v.Set("listen.address", "0.0.0.0:80")
+	v.Set("listen.tls.keyFile", "")
+	v.Set("backend.host", "http://b.example.com/")
+	v.Set("session.storage.redis.host", "")
+	v.Set("session.storage.kind", "file")
+	v.Set("wechat.mchId", "")
+	v.Set("wechat.partnerKey", "short")
+
+	_, err := LoadAppConfig(v)
+	validationErr, ok := err.(*ValidationError)
+	if assert.True(t, ok) {
+		assert.Len(t, validationErr.Problems, 7)
+		for i, key := range []string{"listen.address", "listen.tls", "session.storage.kind", "session.storage.redis.host", "backend.host", "wechat.mchId", "wechat.partnerKey"} {
+			assert.True(t, strings.HasPrefix(validationErr.Problems[i], key + "："), validationErr.Problems[i])
+		}
+	}
+}
+
+func TestLoadAppConfigRejectsCookieStorage(t *testing.T) {
+	v := newTestViper(t)
+	v.Set("session.storage.kind", "cookie")
+
+	_, err := LoadAppConfig(v)
+	validationErr, ok := err.(*ValidationError)
+	if assert.True(t, ok) && assert.Len(t, validationErr.Problems, 1) {
+		assert.True(t, strings.HasPrefix(validationErr.Problems[0], "session.storage.kind："), validationErr.Problems[0])
+	}
+}
+
+func TestLoadAppConfigTenants(t *testing.T) {
+	v := newTestViper(t)
+	tenant := func(db, host, prefix string) map[string]interface{} {
+		return map[string]interface{}{
+			"hosts": []interface{}{host},
+			"pathPrefix": prefix,
+			"database": db,
+			"backend": map[string]interface{}{"host": "b." + host},
+			"frontend": map[string]interface{}{"host": "f." + host},
+			"top": map[string]interface{}{"appKey": "key", "appSecret": "secret"},
+			"wechat": map[string]interface{}{"appId": "wx", "appSecret": "secret", "mchId": "1", "partnerKey": "0123456789abcdef0123456789abcdef"},
+		}
+	}
+	v.Set("tenants", map[string]interface{}{
+		"b": tenant("db_b", "b.example.com", "/b"),
+		"a": tenant("db_a", "a.example.com", "/a"),
+	})
+
+	cfg, err := LoadAppConfig(v)
+	assert.NoError(t, err)
+	tenantCfgs := cfg.AllTenants()
+	if assert.Len(t, tenantCfgs, 2) {
+		assert.Equal(t, "a", tenantCfgs[0].ID)
+		assert.Equal(t, "db_a", tenantCfgs[0].Database)
+		assert.Equal(t, []string{"a.example.com"}, tenantCfgs[0].Hosts)
+		assert.Equal(t, "/a", tenantCfgs[0].PathPrefix)
+		// 未配置的短信模板及微信H5支付网页标题继承顶层配置
+		assert.Equal(t, "SMS_1", tenantCfgs[0].TOP.MobileCaptchaManager.User.Register.TemplateCode)
+		assert.Equal(t, "title", tenantCfgs[0].Wechat.H5PayWebpageTitle)
+		assert.Equal(t, "/a/user/wechat_auth", tenantCfgs[0].BackendPath("/user/wechat_auth"))
+		assert.Equal(t, "b", tenantCfgs[1].ID)
+	}
+	assert.Equal(t, "db_b", cfg.Tenant("b").Database)
+	assert.Nil(t, cfg.Tenant(DEFAULT_TENANT_ID))
+
+	v.Set("tenants", map[string]interface{}{
+		"b": tenant("db_a", "a.example.com", "/a/"),
+		"a": tenant("db_a", "a.example.com", "/a"),
+	})
+	_, err = LoadAppConfig(v)
+	validationErr, ok := err.(*ValidationError)
+	if assert.True(t, ok) {
+		assert.Equal(t, 3, len(validationErr.Problems), validationErr.Error())
+	}
+}
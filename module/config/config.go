@@ -2,50 +2,126 @@ package config
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
 const (
 	DEFAULT_CONFIG_FILENAME = "default.json"
+
+	// 环境变量前缀，配置键中的.替换为_，如wechat.partnerKey对应MXS_WECHAT_PARTNERKEY
+	ENV_PREFIX = "MXS"
 )
 
-func NewConfig(confDir, prof string) *viper.Viper {
+// 配置覆盖项
+type Overrides struct {
+	// 密钥文件，为空时不读取
+	// 应存放在配置目录以外，避免与配置文件一同提交或分发
+	SecretsFile string
+
+	// 键值对，形如key=value，值为合法的JSON时按JSON解析，否则视为字符串
+	Sets        []string
+}
+
+// 读取配置
+// 优先级由低到高：默认配置文件、环境配置文件、密钥文件、环境变量、键值对
+func NewConfig(confDir, prof string, overrides *Overrides) (*viper.Viper, error) {
 	v := viper.New()
 	v.SetConfigType("json")
 
 	defCfgAbsFn := filepath.Join(confDir, DEFAULT_CONFIG_FILENAME)
 	if _, err := os.Stat(defCfgAbsFn); err != nil && os.IsNotExist(err) {
-		panic("指定的配置文件目录中不存在默认配置文件")
+		return nil, errors.New("指定的配置文件目录中不存在默认配置文件")
 	}
 
 	profAbsFn := filepath.Join(confDir, fmt.Sprintf("%s.json", prof))
 	if _, err := os.Stat(profAbsFn); err != nil && os.IsNotExist(err) {
-		panic("指定的配置文件目录中不存在指定的配置文件")
+		return nil, errors.New("指定的配置文件目录中不存在指定的配置文件")
 	}
 
-	defCfgFile, err := os.Open(defCfgAbsFn)
-	if err != nil {
-		panic(err)
+	if err := readConfigFile(v, defCfgAbsFn, false); err != nil {
+		return nil, err
+	}
+	if err := readConfigFile(v, profAbsFn, true); err != nil {
+		return nil, err
+	}
+
+	if overrides == nil {
+		overrides = &Overrides{}
+	}
+
+	if len(overrides.SecretsFile) != 0 {
+		if _, err := os.Stat(overrides.SecretsFile); err != nil && os.IsNotExist(err) {
+			return nil, errors.New("指定的密钥文件不存在")
+		}
+		if err := readConfigFile(v, overrides.SecretsFile, true); err != nil {
+			return nil, err
+		}
+	}
+
+	v.SetEnvPrefix(ENV_PREFIX)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	for _, kv := range overrides.Sets {
+		key, val, err := parseSet(kv)
+		if err != nil {
+			return nil, err
+		}
+		v.Set(key, val)
 	}
-	defer defCfgFile.Close()
 
-	if err := v.ReadConfig(bufio.NewReader(defCfgFile)); err != nil {
-		panic(err)
+	return v, nil
+}
+
+// 读取一个配置文件，merge为true时合并到已读取的配置
+func readConfigFile(v *viper.Viper, fn string, merge bool) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	profCfgFile, err := os.Open(profAbsFn)
+	if merge {
+		err = v.MergeConfig(bufio.NewReader(f))
+	} else {
+		err = v.ReadConfig(bufio.NewReader(f))
+	}
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("读取配置文件%s失败：%v", fn, err)
+	}
+	return nil
+}
+
+// 解析键值对
+func parseSet(kv string) (string, interface{}, error) {
+	i := strings.Index(kv, "=")
+	if i <= 0 {
+		return "", nil, fmt.Errorf("无效的配置键值对：%s", kv)
 	}
-	defer profCfgFile.Close()
 
-	if err := v.MergeConfig(bufio.NewReader(profCfgFile)); err != nil {
-		panic(err)
+	key, rawVal := kv[:i], kv[i + 1:]
+	var val interface{}
+	if err := json.Unmarshal([]byte(rawVal), &val); err != nil {
+		val = rawVal
 	}
+	return key, val, nil
+}
+
+// 可重复指定的命令行键值对参数
+type SetFlags []string
+
+func (sets *SetFlags) String() string {
+	return strings.Join(*sets, ",")
+}
 
-	return v
+func (sets *SetFlags) Set(kv string) error {
+	*sets = append(*sets, kv)
+	return nil
 }
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfig(t *testing.T) {
+	confDir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(confDir)
+
+	write := func(fn, content string) string {
+		absFn := filepath.Join(confDir, fn)
+		assert.NoError(t, ioutil.WriteFile(absFn, []byte(content), 0600))
+		return absFn
+	}
+	write(DEFAULT_CONFIG_FILENAME, `{"a": "default", "b": "default", "c": "default", "d": "default", "e": {"port": 80}}`)
+	write("test.json", `{"b": "profile", "c": "profile", "d": "profile"}`)
+	secretsFn := write("secrets.json", `{"c": "secrets", "d": "secrets"}`)
+
+	os.Setenv("MXS_D", "env")
+	defer os.Unsetenv("MXS_D")
+
+	v, err := NewConfig(confDir, "test", &Overrides{
+		SecretsFile: secretsFn,
+		Sets: []string{"e.port=8080"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "default", v.GetString("a"))
+	assert.Equal(t, "profile", v.GetString("b"))
+	assert.Equal(t, "secrets", v.GetString("c"))
+	assert.Equal(t, "env", v.GetString("d"))
+	assert.Equal(t, 8080, v.GetInt("e.port"))
+
+	_, err = NewConfig(confDir, "none", nil)
+	assert.Error(t, err)
+
+	_, err = NewConfig(confDir, "test", &Overrides{
+		Sets: []string{"invalid"},
+	})
+	assert.Error(t, err)
+}
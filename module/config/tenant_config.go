@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// 默认租户ID，未配置tenants时唯一的租户
+	DEFAULT_TENANT_ID = "default"
+
+	// 默认租户的数据库名
+	DEFAULT_DATABASE_NAME = "mxsiamgp"
+)
+
+// 租户配置
+// 每个租户（赛事主办方）有各自的数据库、前后端主机、阿里大于及微信配置
+// 短信模板及微信H5支付网页标题未配置时继承顶层的top、wechat配置
+type TenantConfig struct {
+	// 租户ID，即tenants下的键
+	ID         string         `mapstructure:"-"`
+
+	// 按Host头识别租户的主机名集，可带端口
+	Hosts      []string       `mapstructure:"hosts"`
+
+	// 按路径前缀识别租户，形如/huahongxinxin，转发前从路径中去除
+	PathPrefix string         `mapstructure:"pathPrefix"`
+
+	// MongoDB数据库名
+	Database   string         `mapstructure:"database"`
+
+	// 后端
+	Backend    HostConfig     `mapstructure:"backend"`
+
+	// 前端
+	Frontend   HostConfig     `mapstructure:"frontend"`
+
+	// CORS
+	CORS       CORSConfig     `mapstructure:"cors"`
+
+	// 阿里大于
+	TOP        TOPConfig      `mapstructure:"top"`
+
+	// 微信公众号及微信支付
+	Wechat     WechatConfig   `mapstructure:"wechat"`
+}
+
+// 租户ID，viper的键不区分大小写，因此限定为小写
+var tenantIDRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// 路径前缀
+var pathPrefixRegexp = regexp.MustCompile(`^(/[A-Za-z0-9_\-]+)+$`)
+
+// 允许的Origin集
+func (tenantCfg *TenantConfig) AllowOrigins() []string {
+	if len(tenantCfg.CORS.AllowOrigins) != 0 {
+		return tenantCfg.CORS.AllowOrigins
+	}
+	frontendURL := &url.URL{
+		Scheme: "http",
+		Host: tenantCfg.Frontend.Host,
+	}
+	return []string{frontendURL.String()}
+}
+
+// 后端的路径
+// 后端主机不在hosts中时，回调请求只能按路径前缀识别租户，须带上前缀
+func (tenantCfg *TenantConfig) BackendPath(path string) string {
+	for _, host := range tenantCfg.Hosts {
+		if strings.EqualFold(host, tenantCfg.Backend.Host) {
+			return path
+		}
+	}
+	return tenantCfg.PathPrefix + path
+}
+
+// 获取全部租户，按ID排序
+// 未配置tenants时返回由顶层配置构成的默认租户
+func (cfg *AppConfig) AllTenants() []*TenantConfig {
+	if len(cfg.Tenants) == 0 {
+		return []*TenantConfig{
+			{
+				ID: DEFAULT_TENANT_ID,
+				Database: DEFAULT_DATABASE_NAME,
+				Backend: cfg.Backend,
+				Frontend: cfg.Frontend,
+				CORS: cfg.CORS,
+				TOP: cfg.TOP,
+				Wechat: cfg.Wechat,
+			},
+		}
+	}
+
+	ids := make([]string, 0, len(cfg.Tenants))
+	for id := range cfg.Tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	tenantCfgs := make([]*TenantConfig, 0, len(ids))
+	for _, id := range ids {
+		tenantCfg := *cfg.Tenants[id]
+		tenantCfg.ID = id
+		tpls := &tenantCfg.TOP.MobileCaptchaManager.User
+		if *tpls == (UserMobileCaptchaConfig{}) {
+			*tpls = cfg.TOP.MobileCaptchaManager.User
+		}
+		if len(tenantCfg.Wechat.H5PayWebpageTitle) == 0 {
+			tenantCfg.Wechat.H5PayWebpageTitle = cfg.Wechat.H5PayWebpageTitle
+		}
+		tenantCfgs = append(tenantCfgs, &tenantCfg)
+	}
+	return tenantCfgs
+}
+
+// 根据ID获取租户，不存在时返回nil
+func (cfg *AppConfig) Tenant(id string) *TenantConfig {
+	for _, tenantCfg := range cfg.AllTenants() {
+		if tenantCfg.ID == id {
+			return tenantCfg
+		}
+	}
+	return nil
+}
+
+// 校验租户配置
+// 默认租户的配置键为顶层键，其余租户的配置键以tenants.<租户ID>.开头
+func (cfg *AppConfig) validateTenants(vld *validator) {
+	tenantCfgs := cfg.AllTenants()
+	hosts := map[string]string{}
+	pathPrefixes := map[string]string{}
+	dbs := map[string]string{}
+	for _, tenantCfg := range tenantCfgs {
+		keyPrefix := ""
+		if len(cfg.Tenants) != 0 {
+			keyPrefix = fmt.Sprintf("tenants.%s.", tenantCfg.ID)
+			if !tenantIDRegexp.MatchString(tenantCfg.ID) {
+				vld.addProblem("tenants", "租户ID只能包含小写字母、数字及下划线：%q", tenantCfg.ID)
+			}
+
+			if len(tenantCfgs) > 1 && len(tenantCfg.Hosts) == 0 && len(tenantCfg.PathPrefix) == 0 {
+				vld.addProblem(keyPrefix + "hosts", "存在多个租户时，hosts与pathPrefix至少配置一项")
+			}
+			for i, host := range tenantCfg.Hosts {
+				key := fmt.Sprintf("%shosts[%d]", keyPrefix, i)
+				vld.requireHost(key, host)
+				host = strings.ToLower(host)
+				if other, ok := hosts[host]; ok {
+					vld.addProblem(key, "与租户%s重复：%q", other, host)
+				}
+				hosts[host] = tenantCfg.ID
+			}
+			if len(tenantCfg.PathPrefix) != 0 {
+				if !pathPrefixRegexp.MatchString(tenantCfg.PathPrefix) {
+					vld.addProblem(keyPrefix + "pathPrefix", "应以/开头且不以/结尾：%q", tenantCfg.PathPrefix)
+				}
+				if other, ok := pathPrefixes[tenantCfg.PathPrefix]; ok {
+					vld.addProblem(keyPrefix + "pathPrefix", "与租户%s重复：%q", other, tenantCfg.PathPrefix)
+				}
+				pathPrefixes[tenantCfg.PathPrefix] = tenantCfg.ID
+			}
+			if vld.requireString(keyPrefix + "database", tenantCfg.Database) {
+				if other, ok := dbs[tenantCfg.Database]; ok {
+					vld.addProblem(keyPrefix + "database", "与租户%s重复：%q", other, tenantCfg.Database)
+				}
+				dbs[tenantCfg.Database] = tenantCfg.ID
+			}
+		}
+
+		vld.requireHost(keyPrefix + "backend.host", tenantCfg.Backend.Host)
+		vld.requireHost(keyPrefix + "frontend.host", tenantCfg.Frontend.Host)
+		for i, origin := range tenantCfg.CORS.AllowOrigins {
+			vld.requireOrigin(fmt.Sprintf("%scors.allowOrigins[%d]", keyPrefix, i), origin)
+		}
+
+		vld.requireString(keyPrefix + "top.appKey", tenantCfg.TOP.AppKey)
+		vld.requireString(keyPrefix + "top.appSecret", tenantCfg.TOP.AppSecret)
+		vld.requireSMSTemplate(keyPrefix + "top.mobileCaptchaManager.user.register", &tenantCfg.TOP.MobileCaptchaManager.User.Register)
+		vld.requireSMSTemplate(keyPrefix + "top.mobileCaptchaManager.user.retakePassword", &tenantCfg.TOP.MobileCaptchaManager.User.RetakePassword)
+
+		vld.requireString(keyPrefix + "wechat.appId", tenantCfg.Wechat.AppID)
+		vld.requireString(keyPrefix + "wechat.appSecret", tenantCfg.Wechat.AppSecret)
+		if vld.requireString(keyPrefix + "wechat.mchId", tenantCfg.Wechat.MchID) && !mchIDRegexp.MatchString(tenantCfg.Wechat.MchID) {
+			vld.addProblem(keyPrefix + "wechat.mchId", "应为数字：%q", tenantCfg.Wechat.MchID)
+		}
+		// 微信支付的商户API密钥固定为32位
+		if vld.requireString(keyPrefix + "wechat.partnerKey", tenantCfg.Wechat.PartnerKey) && len(tenantCfg.Wechat.PartnerKey) != 32 {
+			vld.addProblem(keyPrefix + "wechat.partnerKey", "长度应为32位，实为%d位", len(tenantCfg.Wechat.PartnerKey))
+		}
+		vld.requireString(keyPrefix + "wechat.h5PayWebpageTitle", tenantCfg.Wechat.H5PayWebpageTitle)
+	}
+}
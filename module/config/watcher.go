@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"wawa_b.v1/module/log"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// 配置变更订阅者
+// v为重新读取的配置，cfg为由v解码并校验通过的应用配置
+type Subscriber func(v *viper.Viper, cfg *AppConfig)
+
+// 配置监视器
+// 默认配置文件、环境配置文件或密钥文件变更时，按NewConfig的优先级重新读取配置
+// 校验通过的配置推送给订阅者，校验失败时记录日志并保留原配置
+// 仅CORS、日志、短信模板、限流及微信H5支付网页标题可热更新，其余配置（包括租户的增减）的变更记录日志，须重启生效
+type Watcher struct {
+	// 日志
+	logger      *logrus.Logger
+
+	// 配置文件目录
+	confDir     string
+
+	// 配置环境
+	profile     string
+
+	// 配置覆盖项
+	overrides   *Overrides
+
+	// 互斥量，保证重新读取及推送串行进行
+	mutex       sync.Mutex
+
+	// 启动时使用的应用配置，须重启生效的配置始终与其比较
+	// 不随重新读取更新，否则未生效的变更在下次重新读取时不再提示
+	startup     *AppConfig
+
+	// 订阅者
+	subscribers []Subscriber
+}
+
+// 创建一个配置监视器，参数同NewConfig，startup为启动时使用的应用配置
+func NewWatcher(confDir, prof string, overrides *Overrides, startup *AppConfig) *Watcher {
+	return &Watcher{
+		logger: log.GetLogger("config.watcher"),
+		confDir: confDir,
+		profile: prof,
+		overrides: overrides,
+		startup: startup,
+	}
+}
+
+// 订阅配置变更
+func (w *Watcher) Subscribe(sub Subscriber) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.subscribers = append(w.subscribers, sub)
+}
+
+// 开始监视配置文件
+func (w *Watcher) Watch() {
+	fns := []string{
+		filepath.Join(w.confDir, DEFAULT_CONFIG_FILENAME),
+		filepath.Join(w.confDir, fmt.Sprintf("%s.json", w.profile)),
+	}
+	if w.overrides != nil && len(w.overrides.SecretsFile) != 0 {
+		fns = append(fns, w.overrides.SecretsFile)
+	}
+
+	// 每个文件使用单独的viper监视，其读取的内容不使用，变更时重新读取全部配置
+	for _, fn := range fns {
+		fv := viper.New()
+		fv.SetConfigFile(fn)
+		fv.OnConfigChange(func(evt fsnotify.Event) {
+			w.logger.WithField("file", evt.Name).Info("配置文件已变更")
+			w.Reload()
+		})
+		fv.WatchConfig()
+	}
+}
+
+// 重新读取配置并推送给订阅者
+func (w *Watcher) Reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	v, err := NewConfig(w.confDir, w.profile, w.overrides)
+	if err != nil {
+		w.logger.WithError(err).Error("重新读取配置失败，保留原配置")
+		return err
+	}
+	cfg, err := LoadAppConfig(v)
+	if err != nil {
+		w.logger.WithError(err).Error("重新读取的配置校验失败，保留原配置")
+		return err
+	}
+
+	if keys := restartRequiredChanges(w.startup, cfg); len(keys) != 0 {
+		w.logger.WithField("keys", keys).Warn("以下配置的变更须重启生效")
+	}
+
+	for _, sub := range w.subscribers {
+		sub(v, cfg)
+	}
+	return nil
+}
+
+// 比较须重启生效的配置，返回变更的配置键
+func restartRequiredChanges(old, cfg *AppConfig) []string {
+	keys := []string{}
+	changed := func(key string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			keys = append(keys, key)
+		}
+	}
+
+	changed("listen", old.Listen, cfg.Listen)
+	changed("database", old.Database, cfg.Database)
+	changed("session", old.Session, cfg.Session)
+	changed("password", old.Password, cfg.Password)
+
+	// 租户的增减及除可热更新的配置以外的变更
+	structuralTenants := func(cfg *AppConfig) []TenantConfig {
+		tenantCfgs := []TenantConfig{}
+		for _, tenantCfg := range cfg.AllTenants() {
+			structural := *tenantCfg
+			structural.CORS = CORSConfig{}
+			structural.TOP.MobileCaptchaManager = MobileCaptchaManagerConfig{}
+			structural.Wechat.H5PayWebpageTitle = ""
+			tenantCfgs = append(tenantCfgs, structural)
+		}
+		return tenantCfgs
+	}
+	changed("tenants", structuralTenants(old), structuralTenants(cfg))
+	return keys
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherReload(t *testing.T) {
+	confDir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(confDir)
+
+	write := func(fn, content string) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(confDir, fn), []byte(content), 0600))
+	}
+	write(DEFAULT_CONFIG_FILENAME, testAppConfigJSON)
+	write("test.json", `{}`)
+
+	v, err := NewConfig(confDir, "test", nil)
+	assert.NoError(t, err)
+	cfg, err := LoadAppConfig(v)
+	assert.NoError(t, err)
+
+	w := NewWatcher(confDir, "test", nil, cfg)
+	var pushed *AppConfig
+	w.Subscribe(func(_ *viper.Viper, cfg *AppConfig) {
+		pushed = cfg
+	})
+
+	write("test.json", `{"cors": {"allowOrigins": ["https://a.example.com"]}, "listen": {"port": 8080}}`)
+	assert.NoError(t, w.Reload())
+	if assert.NotNil(t, pushed) {
+		assert.Equal(t, []string{"https://a.example.com"}, pushed.AllTenants()[0].AllowOrigins())
+	}
+	assert.Equal(t, []string{"listen"}, restartRequiredChanges(cfg, pushed))
+
+	// 未生效的变更在之后的重新读取中仍须提示
+	write("test.json", `{"cors": {"allowOrigins": ["https://b.example.com"]}, "listen": {"port": 8080}}`)
+	assert.NoError(t, w.Reload())
+	assert.Equal(t, []string{"listen"}, restartRequiredChanges(w.startup, pushed))
+
+	// 校验失败时不推送
+	pushed = nil
+	write("test.json", `{"wechat": {"h5PayWebpageTitle": ""}}`)
+	assert.Error(t, w.Reload())
+	assert.Nil(t, pushed)
+}
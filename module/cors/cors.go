@@ -0,0 +1,62 @@
+package cors
+
+import (
+	"sync"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// 可在运行时更新允许的Origin集的CORS中间件
+// 除AllowOrigins外的选项在创建后不再变化
+type CORS struct {
+	// 互斥量
+	mutex      sync.RWMutex
+
+	// 配置
+	config     middleware.CORSConfig
+
+	// 按当前配置创建的Echo CORS中间件
+	middleware echo.MiddlewareFunc
+}
+
+// 创建一个CORS中间件
+func NewCORS(config middleware.CORSConfig) *CORS {
+	cors := &CORS{}
+	cors.setConfig(config)
+	return cors
+}
+
+// 获取允许的Origin集
+func (cors *CORS) AllowOrigins() []string {
+	cors.mutex.RLock()
+	defer cors.mutex.RUnlock()
+	return cors.config.AllowOrigins
+}
+
+// 更新允许的Origin集，之后的请求立即生效
+func (cors *CORS) SetAllowOrigins(origins []string) {
+	cors.mutex.Lock()
+	defer cors.mutex.Unlock()
+	config := cors.config
+	config.AllowOrigins = origins
+	cors.setConfig(config)
+}
+
+// 设置配置，须在持有互斥量或创建时调用
+func (cors *CORS) setConfig(config middleware.CORSConfig) {
+	cors.config = config
+	cors.middleware = middleware.CORSWithConfig(config)
+}
+
+// 获取Echo中间件
+func (cors *CORS) MiddlewareFunc() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			cors.mutex.RLock()
+			mw := cors.middleware
+			cors.mutex.RUnlock()
+			return mw(next)(ctx)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/engine"
+)
+
+const (
+	// 存活检查路径，进程能处理请求即返回200
+	PATH_HEALTHZ = "/healthz"
+
+	// 就绪检查路径，全部检查通过且未在停机时返回200，否则返回503
+	PATH_READYZ = "/readyz"
+)
+
+// 就绪检查，返回nil表示通过
+type Check func() error
+
+// 单个就绪检查的超时
+const CHECK_TIMEOUT = 3 * time.Second
+
+// 生命周期处理器
+// 处理存活及就绪检查，其余请求转发给应用的处理器并计数，停机时等待进行中的请求完成
+// 应用的处理器未设置（如启动时仍在连接数据库）时响应503
+type Lifecycle struct {
+	// 互斥量
+	mutex    sync.Mutex
+
+	// 应用的处理器
+	handler  engine.Handler
+
+	// 就绪检查，键为检查名
+	checks   map[string]Check
+
+	// 是否正在停机
+	draining bool
+
+	// 进行中的请求数，不含WebSocket连接
+	inFlight int
+
+	// 停机时进行中的请求全部完成后关闭
+	drained  chan struct{}
+}
+
+// 创建一个生命周期处理器
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{
+		checks: map[string]Check{},
+	}
+}
+
+// 设置应用的处理器，设置后就绪检查方可通过
+func (lc *Lifecycle) SetHandler(h engine.Handler) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.handler = h
+}
+
+// 添加就绪检查，同名的检查被替换
+func (lc *Lifecycle) AddCheck(name string, check Check) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.checks[name] = check
+}
+
+// 开始停机并等待进行中的请求完成
+// 调用后就绪检查返回503，负载均衡不再分配新请求，已建立的连接上的请求仍照常处理
+// 在timeout内全部完成时返回true
+func (lc *Lifecycle) Drain(timeout time.Duration) bool {
+	lc.mutex.Lock()
+	lc.draining = true
+	if lc.inFlight == 0 {
+		lc.mutex.Unlock()
+		return true
+	}
+	if lc.drained == nil {
+		lc.drained = make(chan struct{})
+	}
+	drained := lc.drained
+	lc.mutex.Unlock()
+
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (lc *Lifecycle) ServeHTTP(req engine.Request, res engine.Response) {
+	switch req.URL().Path() {
+	case PATH_HEALTHZ:
+		res.WriteHeader(http.StatusOK)
+		res.Write([]byte("ok"))
+		return
+	case PATH_READYZ:
+		lc.serveReadyz(res)
+		return
+	}
+
+	// WebSocket连接的时长不可预期，不计入进行中的请求，停机时随进程退出断开，客户端应重连
+	isWebSocket := strings.EqualFold(req.Header().Get("Upgrade"), "websocket")
+
+	lc.mutex.Lock()
+	h := lc.handler
+	if h != nil && !isWebSocket {
+		lc.inFlight++
+	}
+	lc.mutex.Unlock()
+
+	if h == nil {
+		res.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if !isWebSocket {
+		defer lc.done()
+	}
+	h.ServeHTTP(req, res)
+}
+
+// 请求完成
+func (lc *Lifecycle) done() {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	lc.inFlight--
+	if lc.inFlight == 0 && lc.drained != nil {
+		close(lc.drained)
+		lc.drained = nil
+	}
+}
+
+// 就绪检查的结果
+type readyzResult struct {
+	// 是否就绪
+	Ready  bool              `json:"ready"`
+
+	// 各检查的结果，通过时为ok，否则为错误信息
+	Checks map[string]string `json:"checks"`
+}
+
+// 处理就绪检查，各检查并发进行
+func (lc *Lifecycle) serveReadyz(res engine.Response) {
+	lc.mutex.Lock()
+	result := &readyzResult{
+		Ready: !lc.draining && lc.handler != nil,
+		Checks: map[string]string{},
+	}
+	if lc.draining {
+		result.Checks["lifecycle"] = "停机中"
+	} else if lc.handler == nil {
+		result.Checks["lifecycle"] = "启动中"
+	}
+	checks := make(map[string]Check, len(lc.checks))
+	for name, check := range lc.checks {
+		checks[name] = check
+	}
+	lc.mutex.Unlock()
+
+	type checkResult struct {
+		name string
+		err  error
+	}
+	resultCh := make(chan checkResult, len(checks))
+	for name, check := range checks {
+		go func(name string, check Check) {
+			resultCh <- checkResult{
+				name: name,
+				err: runCheck(check),
+			}
+		}(name, check)
+	}
+	for range checks {
+		r := <-resultCh
+		if r.err != nil {
+			result.Ready = false
+			result.Checks[r.name] = r.err.Error()
+		} else {
+			result.Checks[r.name] = "ok"
+		}
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		panic(err)
+	}
+	res.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if result.Ready {
+		res.WriteHeader(http.StatusOK)
+	} else {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}
+	res.Write(body)
+}
+
+// 执行单个检查，panic视为失败，超时时不等待其返回
+func runCheck(check Check) error {
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("检查时panic：%v", r)
+			}
+		}()
+		errCh <- check()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(CHECK_TIMEOUT):
+		return fmt.Errorf("检查超时（%s）", CHECK_TIMEOUT)
+	}
+}
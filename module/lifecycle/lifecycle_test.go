@@ -0,0 +1,41 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycleDrain(t *testing.T) {
+	lc := NewLifecycle()
+	assert.True(t, lc.Drain(time.Millisecond))
+
+	lc = NewLifecycle()
+	lc.inFlight = 2
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		lc.done()
+		lc.done()
+	}()
+	assert.True(t, lc.Drain(time.Second))
+	assert.Equal(t, 0, lc.inFlight)
+
+	lc = NewLifecycle()
+	lc.inFlight = 1
+	assert.False(t, lc.Drain(10 * time.Millisecond))
+	assert.True(t, lc.draining)
+}
+
+func TestRunCheck(t *testing.T) {
+	assert.NoError(t, runCheck(func() error {
+		return nil
+	}))
+	assert.EqualError(t, runCheck(func() error {
+		return errors.New("down")
+	}), "down")
+	assert.Error(t, runCheck(func() error {
+		panic("boom")
+	}))
+}
@@ -12,15 +12,27 @@ var loggers map[string]*logrus.Logger = map[string]*logrus.Logger{}
 // 日志集互斥量
 var loggersMutex *sync.RWMutex = &sync.RWMutex{}
 
+// 默认级别，新建的日志使用该级别
+var defaultLevel logrus.Level = logrus.InfoLevel
+
+// 各日志的级别，键为日志名
+var levels map[string]logrus.Level = map[string]logrus.Level{}
+
 // 获取日志
 func GetLogger(name string) *logrus.Logger {
 	loggersMutex.RLock()
-	defer loggersMutex.RUnlock()
 	lgr, ok := loggers[name]
+	loggersMutex.RUnlock()
+	if ok {
+		return lgr
+	}
 
 	// 若没有指定名称的日志则创建一个输出到标准错误的日志
-	if !ok {
+	loggersMutex.Lock()
+	defer loggersMutex.Unlock()
+	if lgr, ok = loggers[name]; !ok {
 		lgr = NewLogger()
+		lgr.SetLevel(levelOf(name))
 		loggers[name] = lgr
 	}
 	return lgr
@@ -31,5 +43,26 @@ func GetLogger(name string) *logrus.Logger {
 func AddLogger(name string, lgr *logrus.Logger) {
 	loggersMutex.Lock()
 	defer loggersMutex.Unlock()
+	lgr.SetLevel(levelOf(name))
 	loggers[name] = lgr
 }
+
+// 设置日志级别，lvls的键为日志名，未列出的日志使用默认级别
+// 已有的日志立即生效，之后获取或添加的日志同样生效
+func SetLevels(defLvl logrus.Level, lvls map[string]logrus.Level) {
+	loggersMutex.Lock()
+	defer loggersMutex.Unlock()
+	defaultLevel = defLvl
+	levels = lvls
+	for name, lgr := range loggers {
+		lgr.SetLevel(levelOf(name))
+	}
+}
+
+// 获取日志的级别，须在持有日志集互斥量时调用
+func levelOf(name string) logrus.Level {
+	if lvl, ok := levels[name]; ok {
+		return lvl
+	}
+	return defaultLevel
+}
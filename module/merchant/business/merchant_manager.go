@@ -1,6 +1,9 @@
 package business
 
 import (
+	"errors"
+	"strings"
+
 	"wawa_b.v1/module/js_regex"
 	merchant_domain "wawa_b.v1/module/merchant/domain"
 	"wawa_b.v1/module/rest_json_rpc/failure"
@@ -8,7 +11,6 @@ import (
 	user_domain "wawa_b.v1/module/user/domain"
 	"wawa_b.v1/module/user/domain/permission"
 
-	"github.com/fatih/set"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -27,35 +29,35 @@ const (
 
 // 商家管理器
 type MerchantManager interface {
-	// 删除一个商家
-	Delete(id string)
+	// 删除一个商家，并撤销用户限定于该商家的角色，operatorID为操作人用户ID
+	Delete(operatorID, id string) error
 
-	// 获取一个商家
-	Get(id string) *merchant_domain.Merchant
+	// 获取一个商家，不存在时返回nil
+	Get(id string) (*merchant_domain.Merchant, error)
 
-	// 根据名称获取一个商家
-	GetByName(name string) *merchant_domain.Merchant
+	// 根据名称获取一个商家，不存在时返回nil
+	GetByName(name string) (*merchant_domain.Merchant, error)
 
-	// 根据用户ID获取一个商家
-	GetByUserID(userID string) *merchant_domain.Merchant
+	// 根据用户ID获取一个商家，不存在时返回nil
+	GetByUserID(userID string) (*merchant_domain.Merchant, error)
 
-	// 踢出员工
-	KickOutStaff(userID string) error
+	// 踢出员工，operatorID为操作人用户ID
+	KickOutStaff(operatorID, userID string) error
 
-	// 拉入员工
-	PullInStaff(merchantID, userID string) error
+	// 拉入员工，operatorID为操作人用户ID
+	PullInStaff(operatorID, merchantID, userID string) error
 
 	// 注册一个新商家
 	Register(userID, name, managerUserID, itemsOfBusiness, contactsName, contactsMobile, contactsIDCard, contactsAddress string) error
 
 	// 检索商家
-	Retrieve(lastID *string, limit int, name string) []*merchant_domain.Merchant
+	Retrieve(lastID *string, limit int, name string) ([]*merchant_domain.Merchant, error)
 
 	// 检索指定商家员工
-	RetrieveStaffs(lastID *string, limit int, merchantID string, name string) []*user_domain.User
+	RetrieveStaffs(lastID *string, limit int, merchantID string, name string) ([]*user_domain.User, error)
 
 	// 更新一个商家的基本信息
-	Update(id, name, itemsOfBusiness, contactsName, contactsMobile, contactsIDCard, contactsAddress string)
+	Update(id, name, itemsOfBusiness, contactsName, contactsMobile, contactsIDCard, contactsAddress string) error
 }
 
 // MongoDB商家管理器
@@ -79,37 +81,59 @@ func NewMongoDBMerchantManager(mxsDB *mgo.Database, userMgr business.UserManager
 	}
 }
 
-func (mgr *MongoDBMerchantManager) Delete(id string) {
+func (mgr *MongoDBMerchantManager) Delete(operatorID, id string) error {
 	if err := mgr.merchantCollection.RemoveId(bson.ObjectIdHex(id)); err != nil {
-		panic(err)
+		return err
+	}
+
+	// 管理员、员工及单独分配的限定于该商家的角色
+	suffix := permission.Scoped("", id)
+	users := make([]*user_domain.User, 0)
+	if err := mgr.userCollection.Find(bson.M{
+		"roles": bson.RegEx{
+			Pattern: js_regex.EscapeTextPattern(suffix) + "$",
+		},
+	}).All(&users); err != nil {
+		return err
 	}
+	for _, user := range users {
+		for _, role := range user.Roles {
+			if !strings.HasSuffix(role, suffix) {
+				continue
+			}
+			if err := mgr.userManager.UnassignRole(operatorID, user.ID.Hex(), role); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func (mgr *MongoDBMerchantManager) Get(id string) *merchant_domain.Merchant {
+func (mgr *MongoDBMerchantManager) Get(id string) (*merchant_domain.Merchant, error) {
 	merchants := make([]*merchant_domain.Merchant, 0)
 	if err := mgr.merchantCollection.FindId(bson.ObjectIdHex(id)).All(&merchants); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(merchants) == 0 {
-		return nil
+		return nil, nil
 	}
-	return merchants[0]
+	return merchants[0], nil
 }
 
-func (mgr *MongoDBMerchantManager) GetByName(name string) *merchant_domain.Merchant {
+func (mgr *MongoDBMerchantManager) GetByName(name string) (*merchant_domain.Merchant, error) {
 	merchants := make([]*merchant_domain.Merchant, 0)
 	if err := mgr.merchantCollection.Find(bson.M{
 		"name": name,
 	}).All(&merchants); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(merchants) == 0 {
-		return nil
+		return nil, nil
 	}
-	return merchants[0]
+	return merchants[0], nil
 }
 
-func (mgr *MongoDBMerchantManager) GetByUserID(userID string) *merchant_domain.Merchant {
+func (mgr *MongoDBMerchantManager) GetByUserID(userID string) (*merchant_domain.Merchant, error) {
 	merchants := make([]*merchant_domain.Merchant, 0)
 	if err := mgr.merchantCollection.Find(bson.M{
 		"$or": []interface{}{
@@ -121,16 +145,19 @@ func (mgr *MongoDBMerchantManager) GetByUserID(userID string) *merchant_domain.M
 			},
 		},
 	}).All(&merchants); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(merchants) == 0 {
-		return nil
+		return nil, nil
 	}
-	return merchants[0]
+	return merchants[0], nil
 }
 
-func (mgr *MongoDBMerchantManager) KickOutStaff(userID string) error {
-	mc := mgr.GetByUserID(userID)
+func (mgr *MongoDBMerchantManager) KickOutStaff(operatorID, userID string) error {
+	mc, err := mgr.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
 	if mc == nil {
 		return nil
 	}
@@ -146,14 +173,15 @@ func (mgr *MongoDBMerchantManager) KickOutStaff(userID string) error {
 			"staffUserIds": bson.ObjectIdHex(userID),
 		},
 	}); err != nil {
-		panic(err)
+		return err
 	}
-
-	return nil
+	return mgr.userManager.UnassignRole(operatorID, userID, permission.Scoped(user_domain.ROLE_MERCHANT_STAFF, mc.ID.Hex()))
 }
 
-func (mgr *MongoDBMerchantManager) PullInStaff(merchantID, userID string) error {
-	if mgr.GetByUserID(userID) != nil {
+func (mgr *MongoDBMerchantManager) PullInStaff(operatorID, merchantID, userID string) error {
+	if mc, err := mgr.GetByUserID(userID); err != nil {
+		return err
+	} else if mc != nil {
 		return failure.New(FAIL_CD_USER_IS_BOUND)
 	}
 
@@ -164,24 +192,44 @@ func (mgr *MongoDBMerchantManager) PullInStaff(merchantID, userID string) error
 			"staffUserIds": bson.ObjectIdHex(userID),
 		},
 	}); err != nil {
-		panic(err)
+		return err
+	}
+	// 操作人不能分配员工角色时撤回
+	if err := mgr.userManager.AssignRole(operatorID, userID, permission.Scoped(user_domain.ROLE_MERCHANT_STAFF, merchantID)); err != nil {
+		mgr.merchantCollection.UpdateId(bson.ObjectIdHex(merchantID), bson.M{
+			"$pull": bson.M{
+				"staffUserIds": bson.ObjectIdHex(userID),
+			},
+		})
+		return err
 	}
-
 	return nil
 }
 
 func (mgr *MongoDBMerchantManager) Register(userID, name, managerUserID, itemsOfBusiness, contactsName, contactsMobile, contactsIDCard, contactsAddress string) error {
-	if mgr.GetByName(name) != nil {
+	if mc, err := mgr.GetByName(name); err != nil {
+		return err
+	} else if mc != nil {
 		return failure.New(FAIL_CD_DUPLICATE_MERCHANT_NAME)
 	}
 
-	if mgr.GetByUserID(managerUserID) != nil {
+	if mc, err := mgr.GetByUserID(managerUserID); err != nil {
+		return err
+	} else if mc != nil {
 		return failure.New(FAIL_CD_USER_IS_BOUND)
 	}
 
-	mgrUser := mgr.userManager.Get(managerUserID)
+	mgrUser, err := mgr.userManager.Get(managerUserID)
+	if err != nil {
+		return err
+	}
+	if mgrUser == nil {
+		return errors.New("无效的管理员用户ID")
+	}
 
+	mcID := bson.NewObjectId()
 	if err := mgr.merchantCollection.Insert(&merchant_domain.Merchant{
+		ID: mcID,
 		Name: name,
 		ManagerUserID: mgrUser.ID,
 		ManagerUserName: mgrUser.Name,
@@ -191,20 +239,18 @@ func (mgr *MongoDBMerchantManager) Register(userID, name, managerUserID, itemsOf
 		ContactsIDCard: contactsIDCard,
 		ContactsAddress: contactsAddress,
 	}); err != nil {
-		panic(err)
+		return err
 	}
 
-	permSet := permission.PermissionSet(mgrUser.FlatPermissions)
-	permSet.Add("MERCHANT.STAFF.RETRIEVE", "MERCHANT.STAFF.MODIFY")
-
-	if err := mgr.userManager.GrantFlatPermissions(userID, managerUserID, set.StringSlice(permSet)); err != nil {
+	// 管理员的角色限定于该商家，操作人不能分配该角色时撤回注册
+	if err := mgr.userManager.AssignRole(userID, managerUserID, permission.Scoped(user_domain.ROLE_MERCHANT_MANAGER, mcID.Hex())); err != nil {
+		mgr.merchantCollection.RemoveId(mcID)
 		return err
 	}
-
 	return nil
 }
 
-func (mgr *MongoDBMerchantManager) Retrieve(lastID *string, limit int, name string) []*merchant_domain.Merchant {
+func (mgr *MongoDBMerchantManager) Retrieve(lastID *string, limit int, name string) ([]*merchant_domain.Merchant, error) {
 	merchants := make([]*merchant_domain.Merchant, 0)
 	query := bson.M{}
 	if lastID != nil {
@@ -218,16 +264,22 @@ func (mgr *MongoDBMerchantManager) Retrieve(lastID *string, limit int, name stri
 		}
 	}
 	if err := mgr.merchantCollection.Find(query).Sort("_id").Limit(limit).All(&merchants); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return merchants
+	return merchants, nil
 }
 
-func (mgr *MongoDBMerchantManager) RetrieveStaffs(lastID *string, limit int, merchantID string, name string) []*user_domain.User {
+func (mgr *MongoDBMerchantManager) RetrieveStaffs(lastID *string, limit int, merchantID string, name string) ([]*user_domain.User, error) {
 	users := make([]*user_domain.User, 0)
 	query := bson.M{}
 
-	mc := mgr.Get(merchantID)
+	mc, err := mgr.Get(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	if mc == nil {
+		return users, nil
+	}
 
 	idFilter := bson.M{}
 	query["_id"] = idFilter
@@ -246,13 +298,13 @@ func (mgr *MongoDBMerchantManager) RetrieveStaffs(lastID *string, limit int, mer
 		}
 	}
 	if err := mgr.userCollection.Find(query).Sort("_id").Limit(limit).All(&users); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return users
+	return users, nil
 }
 
-func (mgr *MongoDBMerchantManager) Update(id, name, itemsOfBusiness, contactsName, contactsMobile, contactsIDCard, contactsAddress string) {
-	if err := mgr.merchantCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
+func (mgr *MongoDBMerchantManager) Update(id, name, itemsOfBusiness, contactsName, contactsMobile, contactsIDCard, contactsAddress string) error {
+	return mgr.merchantCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
 		"$set": bson.M{
 			"name": name,
 			"itemsOfBusiness": itemsOfBusiness,
@@ -261,7 +313,5 @@ func (mgr *MongoDBMerchantManager) Update(id, name, itemsOfBusiness, contactsNam
 			"contactsIDCard": contactsIDCard,
 			"contactsAddress": contactsAddress,
 		},
-	}); err != nil {
-		panic(err)
-	}
+	})
 }
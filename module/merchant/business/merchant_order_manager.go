@@ -1,6 +1,8 @@
 package business
 
 import (
+	"errors"
+
 	order_business "wawa_b.v1/module/order/business"
 	order_domain "wawa_b.v1/module/order/domain"
 
@@ -11,7 +13,7 @@ import (
 // 商家订单管理器
 type MerchantOrderManager interface {
 	// 创建商家订单
-	CreateOrder(userID, competitionID, merchantID string, priceFee int)
+	CreateOrder(userID, competitionID, merchantID string, priceFee int) error
 
 	// 订单项目支付通知回调
 	OrderItemPayNotifyCallback() order_business.OrderItemPayNotifyCallback
@@ -38,10 +40,16 @@ func NewMongoDBMerchantOrderManager(tradeDB *mgo.Database, mcMgr MerchantManager
 	}
 }
 
-func (mgr *MongoDBMerchantOrderManager) CreateOrder(userID, competitionID, merchantID string, priceFee int) {
-	mc := mgr.merchantManager.Get(merchantID)
+func (mgr *MongoDBMerchantOrderManager) CreateOrder(userID, competitionID, merchantID string, priceFee int) error {
+	mc, err := mgr.merchantManager.Get(merchantID)
+	if err != nil {
+		return err
+	}
+	if mc == nil {
+		return errors.New("无效的商家ID")
+	}
 
-	orderID := mgr.orderManager.Create(userID, []*order_domain.OrderItem{
+	orderID, err := mgr.orderManager.Create(userID, []*order_domain.OrderItem{
 		{
 			SellableType: "PAYMENT_TO_MERCHANT",
 			SellableValue: mc.Name,
@@ -49,18 +57,20 @@ func (mgr *MongoDBMerchantOrderManager) CreateOrder(userID, competitionID, merch
 			TotalPriceFee: priceFee,
 		},
 	})
+	if err != nil {
+		return err
+	}
 
-	if err := mgr.orderCollection.UpdateId(bson.ObjectIdHex(orderID), bson.M{
+	return mgr.orderCollection.UpdateId(bson.ObjectIdHex(orderID), bson.M{
 		"$set": bson.M{
 			"competitionId": bson.ObjectIdHex(competitionID),
 			"merchantId": mc.ID,
 		},
-	}); err != nil {
-		panic(err)
-	}
+	})
 }
 
 func (mgr *MongoDBMerchantOrderManager) OrderItemPayNotifyCallback() order_business.OrderItemPayNotifyCallback {
-	return func(orderID, orderItemID string) {
+	return func(orderID, orderItemID string) error {
+		return nil
 	}
 }
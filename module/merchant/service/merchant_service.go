@@ -6,11 +6,9 @@ import (
 	"wawa_b.v1/module/rest_json_rpc"
 	merchant_business "wawa_b.v1/module/merchant/business"
 	"wawa_b.v1/module/rest_json_rpc/failure"
-	"wawa_b.v1/module/session"
 	user_business "wawa_b.v1/module/user/business"
 	user_service "wawa_b.v1/module/user/service"
 
-	"github.com/labstack/echo"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -28,38 +26,48 @@ const (
 
 type DeleteParam struct {
 	// ID
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required,objectid"`
 }
 
 // 删除一个商家
+// + 确保登录
 func DeleteProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*DeleteParam)
-		mcMgr.Delete(param.ID)
-		return nil
+
+		sess := ctx.Session()
+		userID := new(bson.ObjectId)
+		if !sess.Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
+			return nil, errors.New("请确保用户已登录")
+		}
+
+		return nil, mcMgr.Delete(userID.Hex(), param.ID)
 	}
 }
 
 // 资源是否属于该商家
-type ResourceBelongToMerchant func(ctx echo.Context, param interface{}, merchantID string) bool
+type ResourceBelongToMerchant func(ctx rest_json_rpc.Context, param interface{}, merchantID string) bool
 
 // 确保操作属于当前商家的资源
 // + 确保登录
 func EnsureResourceBelongToCurrentMerchantProcessHandler(mcMgr merchant_business.MerchantManager, rbtm ResourceBelongToMerchant) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, ch *rest_json_rpc.ProcessChain) interface{} {
-		sess := session.GetSessionByContext(ctx)
+	return func(ctx rest_json_rpc.Context, p interface{}, ch *rest_json_rpc.ProcessChain) (interface{}, error) {
+		sess := ctx.Session()
 		userID := new(bson.ObjectId)
 		if !sess.Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
-			panic(errors.New("请确保用户已登录"))
+			return nil, errors.New("请确保用户已登录")
 		}
 
-		merchant := mcMgr.GetByUserID(userID.Hex())
+		merchant, err := mcMgr.GetByUserID(userID.Hex())
+		if err != nil {
+			return nil, err
+		}
 		if merchant == nil {
-			panic(failure.New(FAIL_CD_CURRENT_USER_UNBOUNDED_MERCHANTS))
+			return nil, failure.New(FAIL_CD_CURRENT_USER_UNBOUNDED_MERCHANTS)
 		}
 
 		if !rbtm(ctx, p, merchant.ID.Hex()) {
-			panic(failure.New(FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_MERCHANT))
+			return nil, failure.New(FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_MERCHANT)
 		}
 
 		return ch.Next()
@@ -71,30 +79,33 @@ type GetCurrentMerchantParam struct{}
 // 获取当前商家
 // + 确保登录
 func GetCurrentMerchantProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
-		sess := session.GetSessionByContext(ctx)
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		sess := ctx.Session()
 		userID := new(bson.ObjectId)
 		if !sess.Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
-			panic(errors.New("请确保用户已登录"))
+			return nil, errors.New("请确保用户已登录")
 		}
 
-		merchant := mcMgr.GetByUserID(userID.Hex())
+		merchant, err := mcMgr.GetByUserID(userID.Hex())
+		if err != nil {
+			return nil, err
+		}
 		if merchant == nil {
-			panic(failure.New(FAIL_CD_CURRENT_USER_UNBOUNDED_MERCHANTS))
+			return nil, failure.New(FAIL_CD_CURRENT_USER_UNBOUNDED_MERCHANTS)
 		}
 
-		return merchant
+		return merchant, nil
 	}
 }
 
 type GetParam struct {
 	// ID
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required,objectid"`
 }
 
 // 获取一个商家
 func GetProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*GetParam)
 		return mcMgr.Get(param.ID)
 	}
@@ -102,51 +113,63 @@ func GetProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_rpc.Pr
 
 type KickOutStaffParam struct {
 	// 用户ID
-	UserID string `json:"user_id"`
+	UserID string `json:"user_id" validate:"required,objectid"`
 }
 
 // 踢出员工
+// + 确保登录
 func KickOutStaffProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*KickOutStaffParam)
-		if err := mcMgr.KickOutStaff(param.UserID); err != nil {
-			panic(err)
+
+		sess := ctx.Session()
+		userID := new(bson.ObjectId)
+		if !sess.Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
+			return nil, errors.New("请确保用户已登录")
 		}
-		return nil
+
+		return nil, mcMgr.KickOutStaff(userID.Hex(), param.UserID)
 	}
 }
 
 type PullInStaffParam struct {
 	// 商家ID
-	MerchantID string `json:"merchant_id"`
+	MerchantID string `json:"merchant_id" validate:"required,objectid"`
 
 	// 用户名
-	Name       string `json:"name"`
+	Name       string `json:"name" validate:"required"`
 }
 
 // 拉入员工
+// + 确保登录
 func PullInStaffProcessHandler(mcMgr merchant_business.MerchantManager, userMgr user_business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*PullInStaffParam)
 
-		user := userMgr.GetByName(param.Name)
+		user, err := userMgr.GetByName(param.Name)
+		if err != nil {
+			return nil, err
+		}
 		if user == nil {
-			panic(failure.New(FAIL_CD_NO_SUCH_USER))
+			return nil, failure.New(FAIL_CD_NO_SUCH_USER)
 		}
 
-		if err := mcMgr.PullInStaff(param.MerchantID, user.ID.Hex()); err != nil {
-			panic(err)
+		sess := ctx.Session()
+		userID := new(bson.ObjectId)
+		if !sess.Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
+			return nil, errors.New("请确保用户已登录")
 		}
-		return nil
+
+		return nil, mcMgr.PullInStaff(userID.Hex(), param.MerchantID, user.ID.Hex())
 	}
 }
 
 type RegisterParam struct {
 	// 商家店名
-	Name            string `json:"name"`
+	Name            string `json:"name" validate:"required,maxlen=64"`
 
 	// 管理员用户名
-	ManagerUserName string `json:"manager_user_name"`
+	ManagerUserName string `json:"manager_user_name" validate:"required"`
 
 	// 经营项目
 	ItemsOfBusiness string `json:"items_of_business"`
@@ -155,7 +178,7 @@ type RegisterParam struct {
 	ContactsName    string `json:"contacts_name"`
 
 	// 联系人手机号码
-	ContactsMobile  string `json:"contacts_mobile"`
+	ContactsMobile  string `json:"contacts_mobile" validate:"mobile"`
 
 	// 联系人身份证号码
 	ContactsIDCard  string `json:"contacts_id_card"`
@@ -167,30 +190,30 @@ type RegisterParam struct {
 // 注册一个新商家
 // + 确保登录
 func RegisterProcessHandler(mcMgr merchant_business.MerchantManager, userMgr user_business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*RegisterParam)
 
-		mgrUser := userMgr.GetByName(param.ManagerUserName)
+		mgrUser, err := userMgr.GetByName(param.ManagerUserName)
+		if err != nil {
+			return nil, err
+		}
 		if mgrUser == nil {
-			panic(failure.New(FAIL_CD_NO_SUCH_USER))
+			return nil, failure.New(FAIL_CD_NO_SUCH_USER)
 		}
 
-		sess := session.GetSessionByContext(ctx)
+		sess := ctx.Session()
 		userID := new(bson.ObjectId)
 		if !sess.Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
-			panic(errors.New("请确保用户已登录"))
+			return nil, errors.New("请确保用户已登录")
 		}
 
-		if err := mcMgr.Register(userID.Hex(), param.Name, mgrUser.ID.Hex(), param.ItemsOfBusiness, param.ContactsName, param.ContactsMobile, param.ContactsIDCard, param.ContactsAddress); err != nil {
-			panic(err)
-		}
-		return nil
+		return nil, mcMgr.Register(userID.Hex(), param.Name, mgrUser.ID.Hex(), param.ItemsOfBusiness, param.ContactsName, param.ContactsMobile, param.ContactsIDCard, param.ContactsAddress)
 	}
 }
 
 type RetrieveParam struct {
 	// 最后一个ID
-	LastID *string `json:"last_id"`
+	LastID *string `json:"last_id" validate:"objectid"`
 
 	// 商家店名
 	Name   string `json:"name"`
@@ -198,7 +221,7 @@ type RetrieveParam struct {
 
 // 检索商家
 func RetrieveProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*RetrieveParam)
 		return mcMgr.Retrieve(param.LastID, 15, param.Name)
 	}
@@ -206,10 +229,10 @@ func RetrieveProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_r
 
 type RetrieveStaffsParam struct {
 	// 最后一个ID
-	LastID     *string `json:"last_id"`
+	LastID     *string `json:"last_id" validate:"objectid"`
 
 	// 商家ID
-	MerchantID string `json:"merchant_id"`
+	MerchantID string `json:"merchant_id" validate:"required,objectid"`
 
 	// 员工用户名
 	Name       string `json:"name"`
@@ -217,7 +240,7 @@ type RetrieveStaffsParam struct {
 
 // 检索指定商家员工
 func RetrieveStaffsProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*RetrieveStaffsParam)
 		return mcMgr.RetrieveStaffs(param.LastID, 15, param.MerchantID, param.Name)
 	}
@@ -225,10 +248,10 @@ func RetrieveStaffsProcessHandler(mcMgr merchant_business.MerchantManager) rest_
 
 type UpdateParam struct {
 	// ID
-	ID              string `json:"id"`
+	ID              string `json:"id" validate:"required,objectid"`
 
 	// 商家店名
-	Name            string `json:"name"`
+	Name            string `json:"name" validate:"required,maxlen=64"`
 
 	// 经营项目
 	ItemsOfBusiness string `json:"items_of_business"`
@@ -237,7 +260,7 @@ type UpdateParam struct {
 	ContactsName    string `json:"contacts_name"`
 
 	// 联系人手机号码
-	ContactsMobile  string `json:"contacts_mobile"`
+	ContactsMobile  string `json:"contacts_mobile" validate:"mobile"`
 
 	// 联系人身份证号码
 	ContactsIDCard  string `json:"contacts_id_card"`
@@ -248,9 +271,8 @@ type UpdateParam struct {
 
 // 更新一个商家的基本信息
 func UpdateProcessHandler(mcMgr merchant_business.MerchantManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*UpdateParam)
-		mcMgr.Update(param.ID, param.Name, param.ItemsOfBusiness, param.ContactsName, param.ContactsMobile, param.ContactsIDCard, param.ContactsAddress)
-		return nil
+		return nil, mcMgr.Update(param.ID, param.Name, param.ItemsOfBusiness, param.ContactsName, param.ContactsMobile, param.ContactsIDCard, param.ContactsAddress)
 	}
 }
@@ -1,6 +1,8 @@
 package mobile_captcha
 
 import (
+	"sync"
+
 	"wawa_b.v1/module/log"
 	"wawa_b.v1/module/rest_json_rpc/failure"
 	"wawa_b.v1/module/top"
@@ -44,6 +46,9 @@ type TOPMobileCaptchaManager struct {
 	// 验证码生成器
 	captchaCodeGenerator CaptchaCodeGenerator
 
+	// 短信模板互斥量，短信模板可在运行时更新
+	templateMutex        sync.RWMutex
+
 	// 短信模板ID
 	// For sms_template_code
 	// 短信模板中的变量：
@@ -72,11 +77,24 @@ func NewTOPMobileCaptchaManager(topCli *top.TOPClient, captCdGen CaptchaCodeGene
 	}
 }
 
+// 更新短信模板，之后发送的验证码使用新模板
+func (mgr *TOPMobileCaptchaManager) SetTemplate(tplCode, prod, signName string) {
+	mgr.templateMutex.Lock()
+	defer mgr.templateMutex.Unlock()
+	mgr.templateCode = tplCode
+	mgr.product = prod
+	mgr.signName = signName
+}
+
 func (mgr *TOPMobileCaptchaManager) Send(mobi string) (*MobileCaptcha, error) {
 	code := mgr.captchaCodeGenerator.Generate()
 
+	mgr.templateMutex.RLock()
+	tplCode, prod, signName := mgr.templateCode, mgr.product, mgr.signName
+	mgr.templateMutex.RUnlock()
+
 	paramJSON, err := ffjson.Marshal(map[string]string{
-		"product": mgr.product,
+		"product": prod,
 		"code": code,
 	})
 	if err != nil {
@@ -85,10 +103,10 @@ func (mgr *TOPMobileCaptchaManager) Send(mobi string) (*MobileCaptcha, error) {
 
 	res, reqBody, resBody := mgr.topClient.POST("alibaba.aliqin.fc.sms.num.send", map[string]string{
 		"sms_type": "normal",
-		"sms_free_sign_name": mgr.signName,
+		"sms_free_sign_name": signName,
 		"sms_param": string(paramJSON),
 		"rec_num": mobi,
-		"sms_template_code": mgr.templateCode,
+		"sms_template_code": tplCode,
 	})
 
 	if res.ExistsP("error_response") {
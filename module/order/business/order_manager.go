@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"wawa_b.v1/module/log"
 	"wawa_b.v1/module/order/domain"
+	"wawa_b.v1/module/rest_json_rpc/event"
 	"wawa_b.v1/module/rest_json_rpc/failure"
 	"wawa_b.v1/module/wechat_pay_client"
 
@@ -30,32 +32,50 @@ const (
 	FAIL_CD_ORDER_STATUS_NOT_BE_UNPAID = "ORDER.ORDER_STATUS_NOT_BE_UNPAID"
 )
 
+// 主题名
+const (
+	// 订单已支付，事件为*OrderPaidEvent
+	TOPIC_NAME_ORDER_PAID = "order.paid"
+)
+
+// 订单已支付事件
+type OrderPaidEvent struct {
+	// 订单ID
+	OrderID string `json:"order_id"`
+
+	// 用户ID
+	UserID  string `json:"user_id"`
+}
+
 // 订单管理器
 type OrderManager interface {
-	// 创建一个新订单
-	Create(userID string, items []*domain.OrderItem) string
+	// 创建一个新订单，返回订单ID
+	Create(userID string, items []*domain.OrderItem) (string, error)
 
-	// 根据ID获取订单
-	Get(id string) *domain.Order
+	// 根据ID获取订单，不存在时返回nil
+	Get(id string) (*domain.Order, error)
 
 	// 根据用户ID获取所有订单
-	GetAllOrdersByUserID(lastID *string, limit int, userID string) []*domain.Order
+	GetAllOrdersByUserID(lastID *string, limit int, userID string) ([]*domain.Order, error)
 
 	// 微信H5支付
 	PayByWechatH5(orderID, spbillCreateIP, notifyURL, openID string) (string, error)
 
 	// 微信支付
-	WechatPaid(orderID string)
+	WechatPaid(orderID string) error
 }
 
 // 订单项支付通知回调
-type OrderItemPayNotifyCallback func(orderID, orderItemID string)
+type OrderItemPayNotifyCallback func(orderID, orderItemID string) error
 
 // MongoDB订单管理器
 type MongoDBOrderManager struct {
 	// 日志
 	logger                              *logrus.Logger
 
+	// 事件发布器
+	eventPublisher                      event.Publisher
+
 	// 订单集合
 	orderCollection                     *mgo.Collection
 
@@ -67,16 +87,20 @@ type MongoDBOrderManager struct {
 	wechatPayClient                     *wechat_pay_client.WechatPayClient
 
 	// 微信H5支付浏览器打开的移动网页的主页标题
-	wechatH5PayWebpageTitle             *string
+	wechatH5PayWebpageTitle             string
+
+	// 主页标题互斥量，主页标题可在运行时更新
+	wechatH5PayWebpageTitleMutex        sync.RWMutex
 	// - 微信支付相关 --END--
 }
 
 // 创建一个MongoDB订单管理器
 func NewMongoDBOrderManager(
 tradeDB *mgo.Database, phcbist map[string]OrderItemPayNotifyCallback,
-wcPayCli *wechat_pay_client.WechatPayClient, wcH5PayWpTitle *string) *MongoDBOrderManager {
+wcPayCli *wechat_pay_client.WechatPayClient, wcH5PayWpTitle string, evtPub event.Publisher) *MongoDBOrderManager {
 	return &MongoDBOrderManager{
 		logger: log.GetLogger("order.orderManager"),
+		eventPublisher: evtPub,
 		orderCollection: tradeDB.C("Orders"),
 		payNotifyCallbackByItemSellableType: phcbist,
 		wechatPayClient: wcPayCli,
@@ -84,7 +108,21 @@ wcPayCli *wechat_pay_client.WechatPayClient, wcH5PayWpTitle *string) *MongoDBOrd
 	}
 }
 
-func (mgr *MongoDBOrderManager) Create(userID string, items []*domain.OrderItem) string {
+// 更新微信H5支付浏览器打开的移动网页的主页标题
+func (mgr *MongoDBOrderManager) SetWechatH5PayWebpageTitle(title string) {
+	mgr.wechatH5PayWebpageTitleMutex.Lock()
+	defer mgr.wechatH5PayWebpageTitleMutex.Unlock()
+	mgr.wechatH5PayWebpageTitle = title
+}
+
+// 获取微信H5支付浏览器打开的移动网页的主页标题
+func (mgr *MongoDBOrderManager) getWechatH5PayWebpageTitle() string {
+	mgr.wechatH5PayWebpageTitleMutex.RLock()
+	defer mgr.wechatH5PayWebpageTitleMutex.RUnlock()
+	return mgr.wechatH5PayWebpageTitle
+}
+
+func (mgr *MongoDBOrderManager) Create(userID string, items []*domain.OrderItem) (string, error) {
 	for _, item := range items {
 		item.ID = bson.NewObjectId()
 	}
@@ -104,24 +142,24 @@ func (mgr *MongoDBOrderManager) Create(userID string, items []*domain.OrderItem)
 		TotalPriceFee: price,
 		Status: domain.ORD_STAT_UNPAID,
 	}); err != nil {
-		panic(err)
+		return "", err
 	}
 
-	return id.Hex()
+	return id.Hex(), nil
 }
 
-func (mgr *MongoDBOrderManager) Get(id string) *domain.Order {
+func (mgr *MongoDBOrderManager) Get(id string) (*domain.Order, error) {
 	orders := make([]*domain.Order, 0)
 	if err := mgr.orderCollection.FindId(bson.ObjectIdHex(id)).All(&orders); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(orders) == 0 {
-		return nil
+		return nil, nil
 	}
-	return orders[0]
+	return orders[0], nil
 }
 
-func (mgr *MongoDBOrderManager) GetAllOrdersByUserID(lastID *string, limit int, userID string) []*domain.Order {
+func (mgr *MongoDBOrderManager) GetAllOrdersByUserID(lastID *string, limit int, userID string) ([]*domain.Order, error) {
 	orders := make([]*domain.Order, 0)
 	query := bson.M{
 		"userId": bson.ObjectIdHex(userID),
@@ -132,13 +170,16 @@ func (mgr *MongoDBOrderManager) GetAllOrdersByUserID(lastID *string, limit int,
 		}
 	}
 	if err := mgr.orderCollection.Find(query).Sort("userId", "-createdTime").Limit(limit).All(&orders); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return orders
+	return orders, nil
 }
 
 func (mgr *MongoDBOrderManager) PayByWechatH5(orderID, spbillCreateIP, notifyURL, openID string) (string, error) {
-	order := mgr.Get(orderID)
+	order, err := mgr.Get(orderID)
+	if err != nil {
+		return "", err
+	}
 	if order == nil {
 		return "", failure.New(FAIL_CD_NO_SUCH_ORDER)
 	}
@@ -157,14 +198,16 @@ func (mgr *MongoDBOrderManager) PayByWechatH5(orderID, spbillCreateIP, notifyURL
 		}
 
 		if res.Path("return_code").Data().(string) != "SUCCESS" {
-			panic(errors.New("关闭微信支付订单失败"))
+			return "", errors.New("关闭微信支付订单失败")
 		}
 
 		// 关闭订单错误
 		if res.ExistsP("err_code") {
 			// 订单已经支付状态修复
 			if res.Path("err_code").Data().(string) == "ORDERPAID" {
-				mgr.WechatPaid(orderID)
+				if err := mgr.WechatPaid(orderID); err != nil {
+					return "", err
+				}
 			}
 
 			return "", failure.New(FAIL_CD_CREATE_WECHAT_PAY_ORDER_FAIL)
@@ -180,11 +223,11 @@ func (mgr *MongoDBOrderManager) PayByWechatH5(orderID, spbillCreateIP, notifyURL
 			"wechatPayOutTradeNo": outTradeNo,
 		},
 	}); err != nil {
-		panic(err)
+		return "", err
 	}
 
 	res, reqBody, resBody := mgr.wechatPayClient.UnifiedOrder(map[string]string{
-		"body": fmt.Sprintf("%s-%s", *mgr.wechatH5PayWebpageTitle, orderID),
+		"body": fmt.Sprintf("%s-%s", mgr.getWechatH5PayWebpageTitle(), orderID),
 		"attach": orderID,
 		"out_trade_no": outTradeNo,
 		"total_fee": strconv.Itoa(order.TotalPriceFee),
@@ -205,21 +248,42 @@ func (mgr *MongoDBOrderManager) PayByWechatH5(orderID, spbillCreateIP, notifyURL
 	return res.Path("prepay_id").Data().(string), nil
 }
 
-func (mgr *MongoDBOrderManager) WechatPaid(orderID string) {
+func (mgr *MongoDBOrderManager) WechatPaid(orderID string) error {
 	// 调用订单项支付通知回调
-	order := mgr.Get(orderID)
+	order, err := mgr.Get(orderID)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		return failure.New(FAIL_CD_NO_SUCH_ORDER)
+	}
 	for _, item := range order.Items {
 		if cb, ok := mgr.payNotifyCallbackByItemSellableType[item.SellableType]; ok {
-			cb(orderID, item.ID.Hex())
+			if err := cb(orderID, item.ID.Hex()); err != nil {
+				return err
+			}
 		}
 	}
 
-	if err := mgr.orderCollection.UpdateId(bson.ObjectIdHex(orderID), bson.M{
+	// 微信支付会重复通知且可能并发，以条件更新判断状态是否由本次变为已支付，仅此时发布事件
+	if err := mgr.orderCollection.Update(bson.M{
+		"_id": bson.ObjectIdHex(orderID),
+		"status": bson.M{"$ne": domain.ORD_STAT_PAID},
+	}, bson.M{
 		"$set": bson.M{
 			"status": domain.ORD_STAT_PAID,
 			"payApproach": domain.ORD_PAY_APPROACH_WECHAT,
 		},
-	}); err != nil {
-		panic(err)
+	}); err == mgo.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
 	}
+
+	mgr.eventPublisher.Publish(TOPIC_NAME_ORDER_PAID, &OrderPaidEvent{
+		OrderID: orderID,
+		UserID: order.UserID.Hex(),
+	})
+
+	return nil
 }
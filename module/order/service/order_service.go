@@ -5,26 +5,26 @@ import (
 
 	"wawa_b.v1/module/order/business"
 	"wawa_b.v1/module/rest_json_rpc"
-	"wawa_b.v1/module/session"
 	user_service "wawa_b.v1/module/user/service"
 	"wawa_b.v1/module/wechat_client"
 	"wawa_b.v1/module/wechat_pay_client"
 
 	"github.com/Jeffail/gabs"
 	"github.com/labstack/echo"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type GetAllOrdersByUserIDParam struct {
 	// 最后一个ID
-	LastID *string `json:"last_id"`
+	LastID *string `json:"last_id" validate:"objectid"`
 
 	// 用户ID
-	UserID string `json:"user_id"`
+	UserID string `json:"user_id" validate:"required,objectid"`
 }
 
 // 根据用户ID获取所有订单
 func GetAllOrdersByUserIDProcessHandler(orderMgr business.OrderManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*GetAllOrdersByUserIDParam)
 		return orderMgr.GetAllOrdersByUserID(param.LastID, 15, param.UserID)
 	}
@@ -32,32 +32,49 @@ func GetAllOrdersByUserIDProcessHandler(orderMgr business.OrderManager) rest_jso
 
 type PayByWechatH5Param struct {
 	// 订单ID
-	OrderID string `json:"order_id"`
+	OrderID string `json:"order_id" validate:"required,objectid"`
 }
 
 // 微信H5支付
 // + 确保微信授权
 func PayByWechatH5ProcessHandler(orderMgr business.OrderManager, notifyURL string) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*PayByWechatH5Param)
 
-		sess := session.GetSessionByContext(ctx)
+		sess := ctx.Session()
 		accToken := &wechat_client.WechatAccessToken{}
 		if !sess.Get(user_service.SESS_KEY_CURRENT_USER_WECHAT_ACCESS_TOKEN, accToken) {
-			panic(errors.New("请确保微信授权"))
+			return nil, errors.New("请确保微信授权")
 		}
 
-		prepayID, err := orderMgr.PayByWechatH5(param.OrderID, ctx.Request().RealIP(), notifyURL, accToken.OpenID)
+		prepayID, err := orderMgr.PayByWechatH5(param.OrderID, ctx.RemoteIP(), notifyURL, accToken.OpenID)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		return prepayID
+		return prepayID, nil
 	}
 }
 
 // 微信支付通知回调
 func WechatPayNotifyCallbackHandlerFunc(orderMgr business.OrderManager, wcPayCli *wechat_pay_client.WechatPayClient) echo.HandlerFunc {
-	return wcPayCli.PayNotifyCallbackHandlerFunc(func(param *gabs.Container) {
-		orderMgr.WechatPaid(param.Path("attach").Data().(string))
+	return wcPayCli.PayNotifyCallbackHandlerFunc(func(param *gabs.Container) error {
+		return orderMgr.WechatPaid(param.Path("attach").Data().(string))
 	})
 }
+
+// 订阅订单已支付主题，订阅标签为当前用户ID
+// + 确保登录
+func SubscribeOrderPaidProcessHandler() rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, _ interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		userID := new(bson.ObjectId)
+		if !ctx.Session().Get(user_service.SESS_KEY_CURRENT_USER_ID, userID) {
+			return nil, errors.New("请确保用户已登录")
+		}
+		return userID.Hex(), nil
+	}
+}
+
+// 订单已支付主题过滤器，仅向订单所属用户推送
+func OrderPaidTopicFilter(tag interface{}, evt interface{}) bool {
+	return evt.(*business.OrderPaidEvent).UserID == tag.(string)
+}
@@ -0,0 +1,128 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// 盐长度
+	ARGON2ID_SALT_LENGTH = 16
+
+	// 散列长度
+	ARGON2ID_KEY_LENGTH = 32
+)
+
+// PHC格式使用不带填充的标准Base64
+var phcBase64 = base64.RawStdEncoding
+
+// argon2id密码散列器
+// 散列编码为PHC格式：$argon2id$v=19$m=<内存KiB>,t=<迭代次数>,p=<并行度>$<盐>$<散列>
+type Argon2idPasswordHasher struct {
+	// 内存，单位KiB
+	memory      uint32
+
+	// 迭代次数
+	iterations  uint32
+
+	// 并行度
+	parallelism uint8
+}
+
+// 创建一个argon2id密码散列器
+func NewArgon2idPasswordHasher(memory, iterations uint32, parallelism uint8) *Argon2idPasswordHasher {
+	return &Argon2idPasswordHasher{
+		memory: memory,
+		iterations: iterations,
+		parallelism: parallelism,
+	}
+}
+
+func (hasher *Argon2idPasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, ARGON2ID_SALT_LENGTH)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, hasher.iterations, hasher.memory, hasher.parallelism, ARGON2ID_KEY_LENGTH)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, hasher.memory, hasher.iterations, hasher.parallelism,
+		phcBase64.EncodeToString(salt), phcBase64.EncodeToString(key)), nil
+}
+
+func (hasher *Argon2idPasswordHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (hasher *Argon2idPasswordHasher) Verify(password, encoded string) (bool, error) {
+	h, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), h.salt, h.iterations, h.memory, h.parallelism, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+func (hasher *Argon2idPasswordHasher) NeedsRehash(encoded string) bool {
+	h, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return h.memory != hasher.memory || h.iterations != hasher.iterations || h.parallelism != hasher.parallelism ||
+		len(h.key) != ARGON2ID_KEY_LENGTH
+}
+
+// 解码后的argon2id散列
+type argon2idHash struct {
+	// 内存，单位KiB
+	memory      uint32
+
+	// 迭代次数
+	iterations  uint32
+
+	// 并行度
+	parallelism uint8
+
+	// 盐
+	salt        []byte
+
+	// 散列
+	key         []byte
+}
+
+// 解码PHC格式的argon2id散列
+func decodeArgon2id(encoded string) (*argon2idHash, error) {
+	// 以$开头，分割后第一段为空
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("不支持的argon2版本：%d", version)
+	}
+
+	h := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.parallelism); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if h.iterations == 0 || h.parallelism == 0 {
+		return nil, ErrMalformedHash
+	}
+
+	var err error
+	if h.salt, err = phcBase64.DecodeString(parts[4]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if h.key, err = phcBase64.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, ErrMalformedHash
+	}
+	return h, nil
+}
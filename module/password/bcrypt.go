@@ -0,0 +1,51 @@
+package password
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt密码散列器
+// bcrypt只使用密码的前72字节，更长的密码计算散列时返回错误
+// 各过程的密码参数以maxbytes=72校验，该错误不会出现
+type BcryptPasswordHasher struct {
+	// 成本，即迭代次数的以2为底的对数
+	cost int
+}
+
+// 创建一个bcrypt密码散列器
+func NewBcryptPasswordHasher(cost int) *BcryptPasswordHasher {
+	return &BcryptPasswordHasher{
+		cost: cost,
+	}
+}
+
+func (hasher *BcryptPasswordHasher) Hash(password string) (string, error) {
+	encoded, err := bcrypt.GenerateFromPassword([]byte(password), hasher.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func (hasher *BcryptPasswordHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// bcrypt库内部以常数时间比较
+func (hasher *BcryptPasswordHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	}
+	return false, err
+}
+
+func (hasher *BcryptPasswordHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != hasher.cost
+}
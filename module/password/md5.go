@@ -0,0 +1,40 @@
+package password
+
+import (
+	"crypto/subtle"
+	"regexp"
+	"strings"
+
+	"wawa_b.v1/module/md5"
+)
+
+// 旧版不加盐的MD5摘要，32位十六进制
+var md5DigestRegexp = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// 旧版MD5密码散列器
+// 仅用于校验升级前保存的摘要，不应作为主散列器
+type LegacyMD5PasswordHasher struct {
+}
+
+// 创建一个旧版MD5密码散列器
+func NewLegacyMD5PasswordHasher() *LegacyMD5PasswordHasher {
+	return &LegacyMD5PasswordHasher{}
+}
+
+func (hasher *LegacyMD5PasswordHasher) Hash(password string) (string, error) {
+	return md5.StringDigest([]byte(password)), nil
+}
+
+func (hasher *LegacyMD5PasswordHasher) Recognizes(encoded string) bool {
+	return md5DigestRegexp.MatchString(encoded)
+}
+
+func (hasher *LegacyMD5PasswordHasher) Verify(password, encoded string) (bool, error) {
+	digest := md5.StringDigest([]byte(password))
+	return subtle.ConstantTimeCompare([]byte(digest), []byte(strings.ToLower(encoded))) == 1, nil
+}
+
+// MD5摘要总是需要升级
+func (hasher *LegacyMD5PasswordHasher) NeedsRehash(encoded string) bool {
+	return true
+}
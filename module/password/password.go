@@ -0,0 +1,105 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// 无法识别的散列
+var ErrUnrecognizedHash = errors.New("无法识别的密码散列")
+
+// 散列格式错误
+var ErrMalformedHash = errors.New("密码散列格式错误")
+
+// 密码散列器
+// 散列以自描述的编码存储（bcrypt为$2a$…，argon2id为PHC格式$argon2id$…），可据此识别算法及参数
+type PasswordHasher interface {
+	// 计算密码的散列，每次使用新的随机盐
+	Hash(password string) (string, error)
+
+	// 是否能识别该散列
+	Recognizes(encoded string) bool
+
+	// 校验密码，以常数时间比较，散列格式错误时返回错误
+	Verify(password, encoded string) (bool, error)
+
+	// 散列是否需要以当前的算法及参数重新计算
+	NeedsRehash(encoded string) bool
+}
+
+// 可升级的密码散列器
+// 以主散列器计算新散列，以主散列器及各旧散列器校验已有散列
+// 旧散列器的散列及参数与当前配置不同的主散列器散列均需重新计算，应在校验成功后以明文密码重新计算并保存
+type UpgradingPasswordHasher struct {
+	// 主散列器
+	primary PasswordHasher
+
+	// 旧散列器，仅用于校验
+	legacy  []PasswordHasher
+}
+
+// 创建一个可升级的密码散列器
+func NewUpgradingPasswordHasher(primary PasswordHasher, legacy ...PasswordHasher) *UpgradingPasswordHasher {
+	return &UpgradingPasswordHasher{
+		primary: primary,
+		legacy: legacy,
+	}
+}
+
+func (hasher *UpgradingPasswordHasher) Hash(password string) (string, error) {
+	return hasher.primary.Hash(password)
+}
+
+func (hasher *UpgradingPasswordHasher) Recognizes(encoded string) bool {
+	return hasher.recognizer(encoded) != nil
+}
+
+func (hasher *UpgradingPasswordHasher) Verify(password, encoded string) (bool, error) {
+	h := hasher.recognizer(encoded)
+	if h == nil {
+		return false, ErrUnrecognizedHash
+	}
+	return h.Verify(password, encoded)
+}
+
+func (hasher *UpgradingPasswordHasher) NeedsRehash(encoded string) bool {
+	if !hasher.primary.Recognizes(encoded) {
+		return true
+	}
+	return hasher.primary.NeedsRehash(encoded)
+}
+
+// 获取能识别该散列的散列器，均不能识别时返回nil
+func (hasher *UpgradingPasswordHasher) recognizer(encoded string) PasswordHasher {
+	if hasher.primary.Recognizes(encoded) {
+		return hasher.primary
+	}
+	for _, h := range hasher.legacy {
+		if h.Recognizes(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+// 从配置创建可升级的密码散列器
+// 配置项位于password.hasher下，主散列器为algorithm指定的算法，其余算法及旧版MD5仅用于校验
+func HasherFromConfig(v *viper.Viper) *UpgradingPasswordHasher {
+	bcryptHasher := NewBcryptPasswordHasher(v.GetInt("password.hasher.bcrypt.cost"))
+	argon2idHasher := NewArgon2idPasswordHasher(
+		uint32(v.GetInt("password.hasher.argon2id.memory")),
+		uint32(v.GetInt("password.hasher.argon2id.iterations")),
+		uint8(v.GetInt("password.hasher.argon2id.parallelism")))
+	md5Hasher := NewLegacyMD5PasswordHasher()
+
+	switch algorithm := v.GetString("password.hasher.algorithm"); algorithm {
+	case "argon2id":
+		return NewUpgradingPasswordHasher(argon2idHasher, bcryptHasher, md5Hasher)
+	case "bcrypt":
+		return NewUpgradingPasswordHasher(bcryptHasher, argon2idHasher, md5Hasher)
+	default:
+		panic(fmt.Sprintf("不支持的密码散列算法：%s", algorithm))
+	}
+}
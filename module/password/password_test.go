@@ -0,0 +1,77 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArgon2idPasswordHasher(t *testing.T) {
+	hasher := NewArgon2idPasswordHasher(1024, 1, 1)
+	encoded, err := hasher.Hash("secret")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=1024,t=1,p=1\$[A-Za-z0-9+/]+\$[A-Za-z0-9+/]+$`, encoded)
+	assert.True(t, hasher.Recognizes(encoded))
+	assert.False(t, hasher.NeedsRehash(encoded))
+
+	ok, err := hasher.Verify("secret", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = hasher.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 参数变化后旧散列仍可校验，但需重新计算
+	stronger := NewArgon2idPasswordHasher(2048, 1, 1)
+	ok, err = stronger.Verify("secret", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, stronger.NeedsRehash(encoded))
+
+	_, err = hasher.Verify("secret", "$argon2id$v=19$m=1024$salt$key")
+	assert.Equal(t, ErrMalformedHash, err)
+}
+
+func TestBcryptPasswordHasher(t *testing.T) {
+	hasher := NewBcryptPasswordHasher(4)
+	encoded, err := hasher.Hash("secret")
+	assert.NoError(t, err)
+	assert.True(t, hasher.Recognizes(encoded))
+	assert.False(t, hasher.NeedsRehash(encoded))
+	assert.True(t, NewBcryptPasswordHasher(5).NeedsRehash(encoded))
+
+	ok, err := hasher.Verify("secret", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = hasher.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestUpgradingPasswordHasher(t *testing.T) {
+	hasher := NewUpgradingPasswordHasher(NewArgon2idPasswordHasher(1024, 1, 1), NewBcryptPasswordHasher(4), NewLegacyMD5PasswordHasher())
+
+	// md5("admin")
+	legacy := "21232f297a57a5a743894a0e4a801fc3"
+	ok, err := hasher.Verify("admin", legacy)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = hasher.Verify("Admin", legacy)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.True(t, hasher.NeedsRehash(legacy))
+
+	bcryptEncoded, err := NewBcryptPasswordHasher(4).Hash("admin")
+	assert.NoError(t, err)
+	ok, err = hasher.Verify("admin", bcryptEncoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, hasher.NeedsRehash(bcryptEncoded))
+
+	encoded, err := hasher.Hash("admin")
+	assert.NoError(t, err)
+	assert.False(t, hasher.NeedsRehash(encoded))
+
+	_, err = hasher.Verify("admin", "plain")
+	assert.Equal(t, ErrUnrecognizedHash, err)
+}
@@ -0,0 +1,152 @@
+package rate_limit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"wawa_b.v1/module/rest_json_rpc"
+	"wawa_b.v1/module/rest_json_rpc/failure"
+
+	"github.com/spf13/viper"
+)
+
+// 失败代码
+const (
+	// 调用过于频繁
+	// 失败详情为*RateLimitedFailDetail
+	FAIL_CD_RATE_LIMITED = "RATE_LIMIT.RATE_LIMITED"
+)
+
+// 限流维度
+const (
+	// 按会话
+	SCOPE_SESSION = "session"
+
+	// 按远端IP
+	SCOPE_IP = "ip"
+
+	// 按用户
+	SCOPE_USER = "user"
+)
+
+// 调用过于频繁失败详情
+type RateLimitedFailDetail struct {
+	// 触发限流的维度
+	Scope      string `json:"scope"`
+
+	// 建议的重试等待时间（毫秒）
+	RetryAfter int64 `json:"retry_after"`
+}
+
+// 限流键函数，返回调用在该维度上的键，返回false时该调用在该维度上不限流
+type KeyFunc func(ctx rest_json_rpc.Context, param interface{}) (string, bool)
+
+// 限流规则
+type Rule struct {
+	// 维度
+	Scope          string
+
+	// 键函数
+	Key            KeyFunc
+
+	// 令牌桶容量，即允许的突发调用次数
+	Capacity       int
+
+	// 补充一个令牌的间隔
+	RefillInterval time.Duration
+}
+
+// 按会话取键
+func SessionKey(ctx rest_json_rpc.Context, _ interface{}) (string, bool) {
+	sess := ctx.Session()
+	if sess == nil {
+		return "", false
+	}
+	return sess.ID(), true
+}
+
+// 按远端IP取键
+func RemoteIPKey(ctx rest_json_rpc.Context, _ interface{}) (string, bool) {
+	ip := ctx.RemoteIP()
+	return ip, len(ip) != 0
+}
+
+// 限流规则集
+// 规则可在运行时整体替换，正在进行的调用继续使用替换前的规则
+type RuleSet struct {
+	// 互斥量
+	mutex sync.RWMutex
+
+	// 规则
+	rules []*Rule
+}
+
+// 创建一个限流规则集
+func NewRuleSet(rules []*Rule) *RuleSet {
+	return &RuleSet{
+		rules: rules,
+	}
+}
+
+// 获取规则
+func (ruleSet *RuleSet) Rules() []*Rule {
+	ruleSet.mutex.RLock()
+	defer ruleSet.mutex.RUnlock()
+	return ruleSet.rules
+}
+
+// 替换规则
+func (ruleSet *RuleSet) SetRules(rules []*Rule) {
+	ruleSet.mutex.Lock()
+	defer ruleSet.mutex.Unlock()
+	ruleSet.rules = rules
+}
+
+// 从配置rateLimit.<过程名>.<维度>中读取过程的限流规则
+// 维度配置项为capacity（令牌桶容量）与refillInterval（补充一个令牌的间隔，毫秒），未配置的维度不限流
+func RulesFromConfig(v *viper.Viper, procName string, keys map[string]KeyFunc) []*Rule {
+	rules := make([]*Rule, 0, len(keys))
+	for scope, key := range keys {
+		cfgKey := fmt.Sprintf("rateLimit.%s.%s", procName, scope)
+		capacity := v.GetInt(cfgKey + ".capacity")
+		interval := v.GetInt(cfgKey + ".refillInterval")
+		if capacity <= 0 || interval <= 0 {
+			continue
+		}
+
+		rules = append(rules, &Rule{
+			Scope: scope,
+			Key: key,
+			Capacity: capacity,
+			RefillInterval: time.Duration(interval) * time.Millisecond,
+		})
+	}
+	return rules
+}
+
+// 限流
+// 每条规则对应一个令牌桶，任一令牌桶耗尽时返回调用过于频繁的失败
+func RateLimitProcessHandler(limiter Limiter, procName string, ruleSet *RuleSet) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, ch *rest_json_rpc.ProcessChain) (interface{}, error) {
+		for _, rule := range ruleSet.Rules() {
+			key, ok := rule.Key(ctx, p)
+			if !ok {
+				continue
+			}
+
+			allowed, wait, err := limiter.Take(fmt.Sprintf("%s:%s:%s", procName, rule.Scope, key), rule.Capacity, rule.RefillInterval)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				return nil, failure.NewWithDetail(FAIL_CD_RATE_LIMITED, &RateLimitedFailDetail{
+					Scope: rule.Scope,
+					RetryAfter: int64(wait / time.Millisecond),
+				})
+			}
+		}
+
+		return ch.Next()
+	}
+}
@@ -0,0 +1,102 @@
+package rate_limit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"wawa_b.v1/module/rest_json_rpc"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLimiter struct {
+	tokens map[string]int
+}
+
+func (limiter *fakeLimiter) Take(key string, capacity int, _ time.Duration) (bool, time.Duration, error) {
+	if _, ok := limiter.tokens[key]; !ok {
+		limiter.tokens[key] = capacity
+	}
+	if limiter.tokens[key] == 0 {
+		return false, time.Second, nil
+	}
+	limiter.tokens[key]--
+	return true, 0, nil
+}
+
+func TestRulesFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("json")
+	assert.NoError(t, v.ReadConfig(bytes.NewBufferString(`{"rateLimit":{"user":{"login":{"ip":{"capacity":2,"refillInterval":1000}}}}}`)))
+
+	rules := RulesFromConfig(v, "user.login", map[string]KeyFunc{
+		SCOPE_IP: RemoteIPKey,
+		SCOPE_SESSION: SessionKey,
+	})
+	assert.Len(t, rules, 1)
+	assert.Equal(t, SCOPE_IP, rules[0].Scope)
+	assert.Equal(t, 2, rules[0].Capacity)
+	assert.Equal(t, time.Second, rules[0].RefillInterval)
+}
+
+func TestRateLimitProcessHandler(t *testing.T) {
+	ruleSet := NewRuleSet([]*Rule{
+		{
+			Scope: SCOPE_SESSION,
+			Key: SessionKey,
+			Capacity: 1,
+			RefillInterval: time.Second,
+		},
+		{
+			Scope: SCOPE_IP,
+			Key: RemoteIPKey,
+			Capacity: 2,
+			RefillInterval: time.Second,
+		},
+	})
+
+	rpc := rest_json_rpc.NewRPC()
+	rpc.RegisterProcess("test.limited", &rest_json_rpc.Process{
+		Handlers: []rest_json_rpc.ProcessHandler{
+			RateLimitProcessHandler(&fakeLimiter{tokens: map[string]int{}}, "test.limited", ruleSet),
+			func(_ rest_json_rpc.Context, _ interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+				return "ok", nil
+			},
+		},
+		ParamFactory: func() interface{} {
+			return &struct{}{}
+		},
+	})
+
+	call := func() map[string]interface{} {
+		req := httptest.NewRequest(http.MethodPost, "/rest_json_rpc?process=test.limited", strings.NewReader("{}"))
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		rec := httptest.NewRecorder()
+		rpc.HTTPHandler().ServeHTTP(rec, req)
+
+		res := make(map[string]interface{})
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		return res
+	}
+
+	// 没有会话时仅按IP限流
+	assert.Equal(t, "ok", call()["result"])
+	assert.Equal(t, "ok", call()["result"])
+
+	res := call()
+	assert.Equal(t, FAIL_CD_RATE_LIMITED, res["fail_code"])
+	assert.Equal(t, map[string]interface{}{
+		"scope": SCOPE_IP,
+		"retry_after": float64(1000),
+	}, res["fail_detail"])
+
+	// 替换规则后立即生效
+	ruleSet.SetRules(nil)
+	assert.Equal(t, "ok", call()["result"])
+}
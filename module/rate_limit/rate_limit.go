@@ -0,0 +1,89 @@
+package rate_limit
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/redis.v4"
+)
+
+// 限流器
+type Limiter interface {
+	// 从键对应的令牌桶中取一个令牌
+	// 返回是否取得令牌，未取得时同时返回距下一个令牌补充的等待时间
+	Take(key string, capacity int, refillInterval time.Duration) (bool, time.Duration, error)
+}
+
+// Redis令牌桶限流器
+// 令牌桶以哈希存储，取令牌由Lua脚本原子完成，多个实例共享同一个桶
+type RedisTokenBucketLimiter struct {
+	// Redis客户端
+	client *redis.Client
+
+	// 键前缀
+	prefix string
+}
+
+// 取令牌脚本
+// KEYS[1]为桶键；ARGV依次为容量、补充间隔（毫秒）、当前时间（毫秒）
+// 返回{是否取得令牌, 需等待的毫秒数}
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+else
+	local refill = math.floor((now - ts) / interval)
+	if refill > 0 then
+		tokens = math.min(capacity, tokens + refill)
+		ts = ts + refill * interval
+	end
+end
+if tokens >= capacity then
+	ts = now
+end
+
+local allowed = 0
+local wait = 0
+if tokens > 0 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = ts + interval - now
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tokens, "ts", ts)
+redis.call("PEXPIRE", KEYS[1], capacity * interval)
+return {allowed, wait}
+`)
+
+// 创建一个Redis令牌桶限流器
+func NewRedisTokenBucketLimiter(client *redis.Client, prefix string) *RedisTokenBucketLimiter {
+	return &RedisTokenBucketLimiter{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (limiter *RedisTokenBucketLimiter) Take(key string, capacity int, refillInterval time.Duration) (bool, time.Duration, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	res, err := takeScript.Run(limiter.client, []string{limiter.prefix + key}, capacity, int64(refillInterval / time.Millisecond), now).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return false, 0, errors.New("取令牌脚本返回值无效")
+	}
+	allowed, _ := vals[0].(int64)
+	wait, _ := vals[1].(int64)
+
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
@@ -0,0 +1,66 @@
+package rest_json_rpc
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// 批量调用项
+type BatchCall struct {
+	// 过程名
+	Process string `json:"process"`
+
+	// 参数
+	Param   json.RawMessage `json:"param"`
+
+	// 调用ID，原样返回
+	ID      interface{} `json:"id"`
+}
+
+// 请求体是否为批量调用
+func isBatchBody(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	return len(trimmed) != 0 && trimmed[0] == '['
+}
+
+// 批量调用的默认最大调用项数
+const DEFAULT_MAX_BATCH_SIZE = 50
+
+// 批量调用过大失败详情
+type BatchTooLargeFailDetail struct {
+	// 最大调用项数
+	MaxSize int `json:"max_size"`
+}
+
+// 处理批量调用
+// 每个调用项独立执行各自的处理器链，一个调用项失败不影响其他调用项
+// 所有调用项共享同一个会话的载入与保存
+// 调用项数超过上限时不执行任何调用项，返回单个失败的响应信封；为null的调用项响应参数无效
+func (rpc *RPC) handleBatch(ctx Context, body []byte) (interface{}, error) {
+	calls := make([]*BatchCall, 0)
+	if err := ffjson.Unmarshal(body, &calls); err != nil {
+		return nil, err
+	}
+	if len(calls) > rpc.maxBatchSize {
+		return rpc.reject(ctx, FAIL_CD_BATCH_TOO_LARGE, &BatchTooLargeFailDetail{
+			MaxSize: rpc.maxBatchSize,
+		}, "批量调用的调用项过多"), nil
+	}
+
+	results := make([]map[string]interface{}, 0, len(calls))
+	for _, call := range calls {
+		if call == nil {
+			res := rpc.reject(ctx, FAIL_CD_INVALID_PARAM, nil, "批量调用项为null")
+			res["id"] = nil
+			results = append(results, res)
+			continue
+		}
+		res := rpc.call(ctx, call.Process, call.Param)
+		res["id"] = call.ID
+		results = append(results, res)
+	}
+
+	return results, nil
+}
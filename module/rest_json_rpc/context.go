@@ -0,0 +1,25 @@
+package rest_json_rpc
+
+import "wawa_b.v1/module/session"
+
+// 调用上下文
+// 与传输方式无关，由各传输适配器实现
+type Context interface {
+	// 获取调用关联的会话
+	Session() *session.Session
+
+	// 获取远端IP
+	RemoteIP() string
+
+	// 获取请求头，WebSocket传输时为握手请求的请求头
+	Header(name string) string
+
+	// 获取Cookie值，WebSocket传输时为握手请求的Cookie
+	Cookie(name string) (string, bool)
+
+	// 获取调用范围内的值
+	Get(key string) interface{}
+
+	// 设置调用范围内的值
+	Set(key string, val interface{})
+}
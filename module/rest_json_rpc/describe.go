@@ -0,0 +1,52 @@
+package rest_json_rpc
+
+// 内置过程名
+const (
+	// 列出已注册的过程
+	PROC_NAME_DESCRIBE = "rest_json_rpc.describe"
+)
+
+// 过程描述
+type ProcessDescription struct {
+	// 过程名
+	Name        string `json:"name"`
+
+	// 参数的JSON Schema
+	ParamSchema JSONSchema `json:"param_schema"`
+
+	// 声明可能返回的失败代码
+	FailCodes   []string `json:"fail_codes"`
+
+	// 弃用信息，未弃用时省略
+	Deprecation *Deprecation `json:"deprecation,omitempty"`
+}
+
+type DescribeParam struct{}
+
+// 列出所有已注册的过程及其参数结构
+// 过程的每个版本各自列出，版本1的过程名不带版本号
+func DescribeProcessHandler(rpc *RPC) ProcessHandler {
+	return func(_ Context, _ interface{}, _ *ProcessChain) (interface{}, error) {
+		names := rpc.ProcessNames()
+		descs := make([]*ProcessDescription, 0, len(names))
+		for _, name := range names {
+			_, proc, ok := rpc.resolveProcess(name, false)
+			if !ok {
+				continue
+			}
+
+			failCds := proc.FailCodes
+			if failCds == nil {
+				failCds = []string{}
+			}
+
+			descs = append(descs, &ProcessDescription{
+				Name: name,
+				ParamSchema: NewJSONSchema(proc.ParamFactory()),
+				FailCodes: failCds,
+				Deprecation: proc.Deprecation,
+			})
+		}
+		return descs, nil
+	}
+}
@@ -0,0 +1,67 @@
+package rest_json_rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"wawa_b.v1/module/session"
+
+	"github.com/labstack/echo"
+)
+
+// Echo调用上下文
+// 须配合session.SessionManager.HandlerFunc使用
+type echoContext struct {
+	// Echo上下文
+	echo.Context
+
+	// 受信任的代理
+	trustedProxies TrustedProxies
+}
+
+func (ctx *echoContext) Session() *session.Session {
+	return session.GetSessionByContext(ctx.Context)
+}
+
+func (ctx *echoContext) RemoteIP() string {
+	req := ctx.Request()
+	return ctx.trustedProxies.RealIP(req.RemoteAddress(), req.Header().Get)
+}
+
+func (ctx *echoContext) Header(name string) string {
+	return ctx.Request().Header().Get(name)
+}
+
+func (ctx *echoContext) Cookie(name string) (string, bool) {
+	for _, ck := range ctx.Cookies() {
+		if ck.Name() == name {
+			return ck.Value(), true
+		}
+	}
+	return "", false
+}
+
+// 获取Echo处理器
+// 请求体为JSON数组时按批量调用处理
+func (rpc *RPC) HandlerFunc() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		body, err := ioutil.ReadAll(ctx.Request().Body())
+		if err != nil {
+			return err
+		}
+
+		rpcCtx := &echoContext{
+			Context: ctx,
+			trustedProxies: rpc.trustedProxies,
+		}
+		res, err := rpc.handleBody(rpcCtx, ctx.QueryParam("process"), body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		// 写响应前保存会话，使会话Cookie随响应下发
+		rpcCtx.Session().Save()
+
+		return ctx.JSON(http.StatusOK, res)
+	}
+}
@@ -0,0 +1,9 @@
+package event
+
+// 事件发布器
+type Publisher interface {
+	// 发布一个事件到主题
+	// 主题命名规范：[模块名].[事件名]
+	// 在业务写操作中调用，实现须立即返回，不得因推送阻塞或panic
+	Publish(topic string, event interface{})
+}
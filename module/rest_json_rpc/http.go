@@ -0,0 +1,98 @@
+package rest_json_rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"wawa_b.v1/module/session"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// net/http调用上下文
+// 须配合session.SessionManager.HTTPHandler使用
+type httpContext struct {
+	// 请求
+	request *http.Request
+
+	// 调用范围内的值
+	values         map[string]interface{}
+
+	// 受信任的代理
+	trustedProxies TrustedProxies
+}
+
+// 创建一个net/http调用上下文
+func (rpc *RPC) newHTTPContext(r *http.Request) *httpContext {
+	return &httpContext{
+		request: r,
+		values: make(map[string]interface{}),
+		trustedProxies: rpc.trustedProxies,
+	}
+}
+
+func (ctx *httpContext) Session() *session.Session {
+	return session.GetSessionByRequest(ctx.request)
+}
+
+func (ctx *httpContext) RemoteIP() string {
+	return ctx.trustedProxies.RealIP(ctx.request.RemoteAddr, ctx.request.Header.Get)
+}
+
+func (ctx *httpContext) Header(name string) string {
+	return ctx.request.Header.Get(name)
+}
+
+func (ctx *httpContext) Cookie(name string) (string, bool) {
+	ck, err := ctx.request.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	return ck.Value, true
+}
+
+func (ctx *httpContext) Get(key string) interface{} {
+	return ctx.values[key]
+}
+
+func (ctx *httpContext) Set(key string, val interface{}) {
+	ctx.values[key] = val
+}
+
+// 获取net/http处理器
+// 请求体为JSON数组时按批量调用处理
+func (rpc *RPC) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res, err := rpc.handleBody(rpc.newHTTPContext(r), r.URL.Query().Get("process"), body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resJSON, err := ffjson.Marshal(res)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// 写响应前保存会话，使会话Cookie随响应下发
+		if sess := session.GetSessionByRequest(r); sess != nil {
+			sess.Save()
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resJSON)
+	})
+}
@@ -0,0 +1,107 @@
+package rest_json_rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type echoTestParam struct {
+	Text string `json:"text" validate:"required"`
+}
+
+func newTestRPC() *RPC {
+	rpc := NewRPC()
+	// httptest请求的远端地址为192.0.2.1
+	tp, _ := ParseTrustedProxies([]string{"192.0.2.0/24"})
+	rpc.SetTrustedProxies(tp)
+	rpc.RegisterProcess("test.echo", &Process{
+		Handlers: []ProcessHandler{
+			func(ctx Context, p interface{}, _ *ProcessChain) (interface{}, error) {
+				return p.(*echoTestParam).Text + "@" + ctx.RemoteIP(), nil
+			},
+		},
+		ParamFactory: func() interface{} {
+			return &echoTestParam{}
+		},
+	})
+	return rpc
+}
+
+func serveTestHTTP(rpc *RPC, query, body string) map[string]interface{} {
+	req := httptest.NewRequest(http.MethodPost, "/rest_json_rpc?" + query, strings.NewReader(body))
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	rec := httptest.NewRecorder()
+	rpc.HTTPHandler().ServeHTTP(rec, req)
+
+	res := make(map[string]interface{})
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	return res
+}
+
+func TestHTTPHandler(t *testing.T) {
+	rpc := newTestRPC()
+
+	res := serveTestHTTP(rpc, "process=test.echo", `{"text":"hi"}`)
+	assert.Equal(t, STAT_CD_OK, res["status_code"])
+	assert.Equal(t, "hi@10.0.0.1", res["result"])
+	assert.NotContains(t, res, "request_id")
+
+	res = serveTestHTTP(rpc, "process=test.echo", `{}`)
+	assert.Equal(t, FAIL_CD_INVALID_PARAM, res["fail_code"])
+	assert.Len(t, res["request_id"], 32)
+
+	res = serveTestHTTP(rpc, "process=test.none", `{}`)
+	assert.Equal(t, FAIL_CD_NO_SUCH_PROCESS, res["fail_code"])
+
+	req := httptest.NewRequest(http.MethodPost, "/rest_json_rpc", strings.NewReader(`[{"process":"test.echo","param":{"text":"a"},"id":1},{"process":"test.none","id":2}]`))
+	rec := httptest.NewRecorder()
+	rpc.HTTPHandler().ServeHTTP(rec, req)
+
+	results := make([]map[string]interface{}, 0)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+	assert.Len(t, results, 2)
+	assert.Equal(t, float64(1), results[0]["id"])
+	assert.Equal(t, STAT_CD_OK, results[0]["status_code"])
+	assert.Equal(t, float64(2), results[1]["id"])
+	assert.Equal(t, FAIL_CD_NO_SUCH_PROCESS, results[1]["fail_code"])
+	assert.NotEmpty(t, results[1]["request_id"])
+
+	req = httptest.NewRequest(http.MethodPost, "/rest_json_rpc", strings.NewReader(`[null,{"process":"test.echo","param":{"text":"a"},"id":1}]`))
+	rec = httptest.NewRecorder()
+	rpc.HTTPHandler().ServeHTTP(rec, req)
+	results = make([]map[string]interface{}, 0)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+	assert.Len(t, results, 2)
+	assert.Equal(t, FAIL_CD_INVALID_PARAM, results[0]["fail_code"])
+	assert.Equal(t, STAT_CD_OK, results[1]["status_code"])
+
+	rpc.SetMaxBatchSize(1)
+	res = serveTestHTTP(rpc, "", `[{"process":"test.echo","param":{"text":"a"}},{"process":"test.echo","param":{"text":"b"}}]`)
+	assert.Equal(t, FAIL_CD_BATCH_TOO_LARGE, res["fail_code"])
+	assert.Equal(t, map[string]interface{}{"max_size": float64(1)}, res["fail_detail"])
+}
+
+func TestInternalErrorRequestID(t *testing.T) {
+	rpc := NewRPC()
+	rpc.RegisterProcess("test.panic", &Process{
+		Handlers: []ProcessHandler{
+			func(_ Context, _ interface{}, _ *ProcessChain) (interface{}, error) {
+				panic("boom")
+			},
+		},
+		ParamFactory: func() interface{} {
+			return &struct{}{}
+		},
+	})
+
+	res := serveTestHTTP(rpc, "process=test.panic", ``)
+	assert.Equal(t, FAIL_CD_INTERNAL_ERROR, res["fail_code"])
+	assert.Equal(t, map[string]interface{}{
+		"correlation_id": res["request_id"],
+	}, res["fail_detail"])
+}
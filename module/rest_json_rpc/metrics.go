@@ -0,0 +1,79 @@
+package rest_json_rpc
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 指标
+type metrics struct {
+	// 指标注册表，每个RPC独立持有
+	registry *prometheus.Registry
+
+	// 调用次数，按过程名、状态代码与失败代码区分
+	calls    *prometheus.CounterVec
+
+	// 调用耗时（秒），按过程名与状态代码区分
+	duration *prometheus.HistogramVec
+
+	// 处理器panic次数，按过程名区分
+	panics   *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "rest_json_rpc",
+			Name: "calls_total",
+			Help: "过程调用次数",
+		}, []string{"process", "status_code", "fail_code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "rest_json_rpc",
+			Name: "call_duration_seconds",
+			Help: "过程调用耗时（秒）",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"process", "status_code"}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "rest_json_rpc",
+			Name: "panics_total",
+			Help: "过程处理器panic次数",
+		}, []string{"process"}),
+	}
+	m.registry.MustRegister(m.calls, m.duration, m.panics)
+	return m
+}
+
+// 记录一次调用
+// 未注册的过程名统一记为空，避免标签基数失控
+func (m *metrics) observeCall(proc, statusCode, failCode string, elapsed time.Duration) {
+	m.calls.WithLabelValues(proc, statusCode, failCode).Inc()
+	m.duration.WithLabelValues(proc, statusCode).Observe(elapsed.Seconds())
+}
+
+// 记录一次处理器panic
+func (m *metrics) observePanic(proc string) {
+	m.panics.WithLabelValues(proc).Inc()
+}
+
+// 获取以Prometheus文本格式导出指标的net/http处理器
+// 仅允许连接的远端地址在allowFrom内的请求访问，其余返回403；不采用X-Forwarded-For等可伪造的请求头
+func (rpc *RPC) MetricsHandler(allowFrom []*net.IPNet) http.Handler {
+	h := promhttp.HandlerFor(rpc.metrics.registry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !ipNetsContain(allowFrom, ip) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
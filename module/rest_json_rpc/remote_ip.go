@@ -0,0 +1,92 @@
+package rest_json_rpc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// 受信任的代理
+// 仅连接的远端地址为受信任的代理时才采用X-Forwarded-For与X-Real-IP，否则客户端可伪造IP
+type TrustedProxies []*net.IPNet
+
+// 解析受信任的代理，元素为IP或CIDR
+func ParseTrustedProxies(addrs []string) (TrustedProxies, error) {
+	ipNets, err := ParseIPNets(addrs)
+	if err != nil {
+		return nil, err
+	}
+	return TrustedProxies(ipNets), nil
+}
+
+// 解析IP网段，元素为IP或CIDR，IP视为仅含其自身的网段
+func ParseIPNets(addrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的IP：%q", addr)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			addr = fmt.Sprintf("%s/%d", addr, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR：%q", addr)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+// 是否为受信任的代理
+func (tp TrustedProxies) contains(ip net.IP) bool {
+	return ipNetsContain(tp, ip)
+}
+
+// IP是否在任一网段内
+func ipNetsContain(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取请求的真实IP
+// 连接的远端地址不是受信任的代理时即为真实IP
+// 否则自右向左取X-Forwarded-For中第一个不受信任的地址，遇到无效的地址时取其右侧的地址；无X-Forwarded-For时取X-Real-IP
+func (tp TrustedProxies) RealIP(remoteAddr string, header func(name string) string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !tp.contains(ip) {
+		return host
+	}
+
+	if xff := header("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hopIP := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hopIP == nil {
+				return ip.String()
+			}
+			ip = hopIP
+			if !tp.contains(ip) {
+				break
+			}
+		}
+		return ip.String()
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(header("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+	return host
+}
@@ -0,0 +1,60 @@
+package rest_json_rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxiesRealIP(t *testing.T) {
+	tp, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "::1"})
+	assert.Nil(t, err)
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.NotNil(t, err)
+
+	headers := func(xff, xri string) func(string) string {
+		return func(name string) string {
+			switch name {
+			case "X-Forwarded-For":
+				return xff
+			case "X-Real-IP":
+				return xri
+			}
+			return ""
+		}
+	}
+
+	// 不受信任的远端地址忽略请求头
+	assert.Equal(t, "203.0.113.9", tp.RealIP("203.0.113.9:5000", headers("1.1.1.1", "2.2.2.2")))
+	// 取最右侧不受信任的地址，客户端伪造的左侧地址被忽略
+	assert.Equal(t, "198.51.100.7", tp.RealIP("192.0.2.1:5000", headers("1.1.1.1, 198.51.100.7, 10.1.2.3", "")))
+	// 全部受信任时取最左侧
+	assert.Equal(t, "10.1.2.3", tp.RealIP("192.0.2.1:5000", headers("10.1.2.3, 10.4.5.6", "")))
+	// 无效的地址取其右侧的地址
+	assert.Equal(t, "10.4.5.6", tp.RealIP("192.0.2.1:5000", headers("garbage, 10.4.5.6", "")))
+	assert.Equal(t, "198.51.100.7", tp.RealIP("[::1]:5000", headers("", "198.51.100.7")))
+	assert.Equal(t, "192.0.2.1", tp.RealIP("192.0.2.1:5000", headers("", "")))
+	assert.Equal(t, "203.0.113.9", TrustedProxies(nil).RealIP("203.0.113.9:5000", headers("1.1.1.1", "")))
+}
+
+func TestMetricsHandlerAllowFrom(t *testing.T) {
+	allowFrom, err := ParseIPNets([]string{"127.0.0.1"})
+	assert.Nil(t, err)
+	h := NewRPC().MetricsHandler(allowFrom)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// 按连接的远端地址判断，忽略X-Forwarded-For
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	req.RemoteAddr = "203.0.113.9:5000"
+	req.Header.Set("X-Forwarded-For", "127.0.0.1")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -1,12 +1,21 @@
 package rest_json_rpc
 
 import (
-	"net/http"
+	"bytes"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"wawa_b.v1/module/log"
 	"wawa_b.v1/module/rest_json_rpc/failure"
+	"wawa_b.v1/module/rest_json_rpc/validation"
 
-	"github.com/labstack/echo"
+	"github.com/Sirupsen/logrus"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/satori/go.uuid"
 )
 
 // 状态代码
@@ -25,14 +34,35 @@ const (
 
 	// 没有指定过程名
 	FAIL_CD_NOT_SPECIFIED_PROCESS = "REST_JSON_RPC.NOT_SPECIFIED_PROCESS"
+
+	// 参数无效
+	// 失败详情为违反校验规则的字段集[]*validation.Violation
+	FAIL_CD_INVALID_PARAM = "REST_JSON_RPC.INVALID_PARAM"
+
+	// 内部错误
+	// 失败详情为*InternalErrorFailDetail，关联ID即请求ID，同时记录在日志中
+	FAIL_CD_INTERNAL_ERROR = "REST_JSON_RPC.INTERNAL_ERROR"
+
+	// WebSocket帧无效
+	FAIL_CD_INVALID_FRAME = "REST_JSON_RPC.INVALID_FRAME"
+
+	// 批量调用的调用项过多
+	// 失败详情为*BatchTooLargeFailDetail
+	FAIL_CD_BATCH_TOO_LARGE = "REST_JSON_RPC.BATCH_TOO_LARGE"
 )
 
+// 内部错误失败详情
+type InternalErrorFailDetail struct {
+	// 关联ID
+	CorrelationID string `json:"correlation_id"`
+}
+
 type ProcessChain struct {
 	// 过程
 	*Process
 
-	// Echo上下文
-	context             echo.Context
+	// 调用上下文
+	context             Context
 
 	// 当前处理器索引
 	currentHandlerIndex int
@@ -42,7 +72,8 @@ type ProcessChain struct {
 }
 
 // 过程处理器
-type ProcessHandler func(ctx echo.Context, param interface{}, ch *ProcessChain) interface{}
+// 返回*failure.Failure以外的错误时按内部错误处理
+type ProcessHandler func(ctx Context, param interface{}, ch *ProcessChain) (interface{}, error)
 
 // 过程参数工厂
 type ProcessParamFactory func() interface{}
@@ -54,108 +85,366 @@ type Process struct {
 
 	//参数工厂
 	ParamFactory ProcessParamFactory
+
+	//声明可能返回的失败代码
+	FailCodes    []string
+
+	//弃用信息，为nil表示未弃用
+	Deprecation  *Deprecation
 }
 
 // 远程过程调用
 type RPC struct {
+	//日志
+	logger           *logrus.Logger
+
+	//调度日志，每次调用记录一条
+	dispatcherLogger *logrus.Logger
+
+	//指标
+	metrics          *metrics
+
 	//用于保证processes的并发安全
-	mutex     *sync.RWMutex
+	mutex            *sync.RWMutex
+
+	//已注册的过程，按过程名与版本号索引
+	processes        map[string]map[int]*Process
 
-	//已注册的过程
-	processes map[string]*Process
+	//用于保证topics的并发安全
+	topicMutex       *sync.RWMutex
+
+	//已注册的主题及其订阅
+	topics           map[string]*topicSubscriptions
+
+	//待分发的事件队列
+	events           chan *publishedEvent
+
+	//受信任的代理，为空时不采用X-Forwarded-For与X-Real-IP
+	trustedProxies   TrustedProxies
+
+	//批量调用的最大调用项数
+	maxBatchSize     int
 }
 
-func (ch *ProcessChain) Next() interface{} {
+func (ch *ProcessChain) Next() (interface{}, error) {
 	if ch.currentHandlerIndex == len(ch.Process.Handlers) - 1 {
-		return nil
+		return nil, nil
 	}
 	ch.currentHandlerIndex++
 	return ch.Process.Handlers[ch.currentHandlerIndex](ch.context, ch.param, ch)
 }
 
-// 创建一个远程过程调用
+// 创建一个远程过程调用，并启动事件分发协程
+// 内置注册了过程rest_json_rpc.describe、rest_json_rpc.subscribe与rest_json_rpc.unsubscribe
 func NewRPC() *RPC {
-	return &RPC{
+	rpc := &RPC{
+		logger: log.GetLogger("rest_json_rpc.rpc"),
+		dispatcherLogger: log.GetLogger("rest_json_rpc.dispatcher"),
+		metrics: newMetrics(),
 		mutex: &sync.RWMutex{},
-		processes: make(map[string]*Process),
+		processes: make(map[string]map[int]*Process),
+		topicMutex: &sync.RWMutex{},
+		topics: make(map[string]*topicSubscriptions),
+		events: make(chan *publishedEvent, topicEventQueueSize),
+		maxBatchSize: DEFAULT_MAX_BATCH_SIZE,
 	}
+	go rpc.dispatchEvents()
+	rpc.RegisterProcess(PROC_NAME_DESCRIBE, &Process{
+		Handlers: []ProcessHandler{
+			DescribeProcessHandler(rpc),
+		},
+		ParamFactory: func() interface{} {
+			return &DescribeParam{}
+		},
+	})
+	rpc.RegisterProcess(PROC_NAME_SUBSCRIBE, &Process{
+		Handlers: []ProcessHandler{
+			SubscribeProcessHandler(rpc),
+		},
+		ParamFactory: func() interface{} {
+			return &SubscribeParam{}
+		},
+		FailCodes: []string{
+			FAIL_CD_SUBSCRIBE_UNSUPPORTED,
+			FAIL_CD_NO_SUCH_TOPIC,
+		},
+	})
+	rpc.RegisterProcess(PROC_NAME_UNSUBSCRIBE, &Process{
+		Handlers: []ProcessHandler{
+			UnsubscribeProcessHandler(rpc),
+		},
+		ParamFactory: func() interface{} {
+			return &UnsubscribeParam{}
+		},
+		FailCodes: []string{
+			FAIL_CD_SUBSCRIBE_UNSUPPORTED,
+		},
+	})
+	return rpc
+}
+
+// 设置受信任的代理，应在开始处理请求前设置
+func (rpc *RPC) SetTrustedProxies(tp TrustedProxies) {
+	rpc.trustedProxies = tp
+}
+
+// 设置批量调用的最大调用项数，应在开始处理请求前设置，默认为DEFAULT_MAX_BATCH_SIZE
+func (rpc *RPC) SetMaxBatchSize(size int) {
+	rpc.maxBatchSize = size
 }
 
 // 注册一个过程
-// 过程命名规范：[模块名].[过程名]
+// 过程命名规范：[模块名].[过程名]，可附加版本号[模块名].[过程名]@[版本号]，不附加时为版本1
+// 过程名不合法时panic
 func (rpc *RPC) RegisterProcess(name string, proc *Process) {
+	base, ver, ok := parseProcessName(name)
+	if !ok {
+		panic(fmt.Sprintf("rest_json_rpc: invalid process name %q", name))
+	}
+	if ver == 0 {
+		ver = 1
+	}
+
 	rpc.mutex.Lock()
 	defer rpc.mutex.Unlock()
-	rpc.processes[name] = proc
+	versions, ok := rpc.processes[base]
+	if !ok {
+		versions = make(map[int]*Process)
+		rpc.processes[base] = versions
+	}
+	versions[ver] = proc
 }
 
 // 反注册一个过程
+// 不带版本号时反注册该过程的所有版本
 func (rpc *RPC) UnregisterProcess(name string) {
+	base, ver, ok := parseProcessName(name)
+	if !ok {
+		return
+	}
+
 	rpc.mutex.Lock()
 	defer rpc.mutex.Unlock()
-	delete(rpc.processes, name)
+	if ver == 0 {
+		delete(rpc.processes, base)
+		return
+	}
+
+	versions, ok := rpc.processes[base]
+	if !ok {
+		return
+	}
+	delete(versions, ver)
+	if len(versions) == 0 {
+		delete(rpc.processes, base)
+	}
 }
 
 // 根据名称获取过程
+// 不带版本号时获取最新版本
 func (rpc *RPC) GetProcess(name string) (*Process, bool) {
-	rpc.mutex.RLock()
-	defer rpc.mutex.RUnlock()
-	proc, ok := rpc.processes[name]
+	_, proc, ok := rpc.resolveProcess(name, true)
 	return proc, ok
 }
 
-// 获取Echo处理器
-func (rpc *RPC) HandlerFunc() echo.HandlerFunc {
-	return func(ctx echo.Context) error {
-		name := ctx.QueryParam("process")
-		if len(name) == 0 {
-			fail(ctx, FAIL_CD_NOT_SPECIFIED_PROCESS, nil)
-			return nil
+// 根据名称解析过程，返回规范的过程名与过程
+// 不带版本号时，latest为true解析为最新版本，否则解析为版本1
+func (rpc *RPC) resolveProcess(name string, latest bool) (string, *Process, bool) {
+	base, ver, ok := parseProcessName(name)
+	if !ok {
+		return "", nil, false
+	}
+
+	rpc.mutex.RLock()
+	defer rpc.mutex.RUnlock()
+	versions, ok := rpc.processes[base]
+	if !ok {
+		return "", nil, false
+	}
+	if ver == 0 {
+		ver = 1
+		if latest {
+			ver = latestVersion(versions)
 		}
+	}
+	proc, ok := versions[ver]
+	if !ok {
+		return "", nil, false
+	}
+	return canonicalProcessName(base, ver), proc, true
+}
 
-		proc, ok := rpc.GetProcess(name)
-		if !ok {
-			fail(ctx, FAIL_CD_NO_SUCH_PROCESS, nil)
-			return nil
+// 获取所有已注册过程各版本的规范名称，按名称排序
+func (rpc *RPC) ProcessNames() []string {
+	rpc.mutex.RLock()
+	defer rpc.mutex.RUnlock()
+	names := make([]string, 0, len(rpc.processes))
+	for base, versions := range rpc.processes {
+		for ver := range versions {
+			names = append(names, canonicalProcessName(base, ver))
 		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 处理一次请求
+// 请求体为JSON数组时按批量调用处理，否则请求体为name指定过程的参数
+// 返回响应信封或批量调用的响应信封集，请求体不是合法的批量调用时返回错误
+func (rpc *RPC) handleBody(ctx Context, name string, body []byte) (interface{}, error) {
+	if isBatchBody(body) {
+		return rpc.handleBatch(ctx, body)
+	}
+
+	return rpc.call(ctx, name, body), nil
+}
 
-		param := proc.ParamFactory()
-		if err := ctx.Bind(param); err != nil {
-			return err
+// 调用一个过程并返回响应信封
+// 每次调用分配一个请求ID，记录在调度日志中，失败时一并写入响应信封
+func (rpc *RPC) call(ctx Context, name string, paramJSON []byte) map[string]interface{} {
+	reqID := newRequestID()
+	startTime := time.Now()
+
+	procName, proc, res := rpc.dispatch(ctx, reqID, name, paramJSON)
+
+	elapsed := time.Since(startTime)
+	statusCode, _ := res["status_code"].(string)
+	failCode, _ := res["fail_code"].(string)
+	if statusCode == STAT_CD_FAIL {
+		res["request_id"] = reqID
+	}
+
+	rpc.metrics.observeCall(procName, statusCode, failCode, elapsed)
+
+	fields := logrus.Fields{
+		"process": name,
+		"requestId": reqID,
+		"statusCode": statusCode,
+		"failCode": failCode,
+		"duration": int64(elapsed / time.Millisecond),
+		"remoteIp": ctx.RemoteIP(),
+	}
+	if proc != nil && proc.Deprecation != nil {
+		res["deprecation"] = proc.Deprecation
+		fields["sunset"] = proc.Deprecation.Sunset
+		rpc.dispatcherLogger.WithFields(fields).Warn("调用已弃用的过程")
+	} else {
+		rpc.dispatcherLogger.WithFields(fields).Info("过程调用")
+	}
+
+	return res
+}
+
+// 调度一次调用
+// 参数JSON为空时使用参数工厂创建的参数
+// 返回规范的过程名、过程及响应信封，过程不存在时过程名为空、过程为nil
+func (rpc *RPC) dispatch(ctx Context, reqID, name string, paramJSON []byte) (string, *Process, map[string]interface{}) {
+	if len(name) == 0 {
+		return "", nil, failEnvelope(FAIL_CD_NOT_SPECIFIED_PROCESS, nil)
+	}
+
+	procName, proc, ok := rpc.resolveProcess(name, true)
+	if !ok {
+		return "", nil, failEnvelope(FAIL_CD_NO_SUCH_PROCESS, nil)
+	}
+
+	param := proc.ParamFactory()
+	if len(bytes.TrimSpace(paramJSON)) != 0 {
+		if err := ffjson.Unmarshal(paramJSON, param); err != nil {
+			return procName, proc, failEnvelope(FAIL_CD_INVALID_PARAM, nil)
 		}
+	}
 
-		//失败处理
-		defer func() {
-			if r := recover(); r != nil {
-				err, ok := r.(*failure.Failure)
-				if !ok {
-					panic(r)
-				}
+	if violations := validation.Validate(param); len(violations) != 0 {
+		return procName, proc, failEnvelope(FAIL_CD_INVALID_PARAM, violations)
+	}
 
-				fail(ctx, err.Code, err.Detail)
+	result, f := rpc.invoke(ctx, reqID, procName, proc, param)
+	if f != nil {
+		return procName, proc, failEnvelope(f.Code, f.Detail)
+	}
+
+	return procName, proc, okEnvelope(result)
+}
+
+// 执行过程的处理器链
+// 处理器返回的*failure.Failure原样作为失败，其他错误及panic转换为以请求ID为关联ID的内部错误
+func (rpc *RPC) invoke(ctx Context, reqID, name string, proc *Process, param interface{}) (result interface{}, f *failure.Failure) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(*failure.Failure); ok {
+				result, f = nil, err
+				return
 			}
-		}()
 
-		result := proc.Handlers[0](ctx, param, &ProcessChain{
-			Process: proc,
-			context: ctx,
-			currentHandlerIndex: 0,
-			param: param,
-		})
+			rpc.metrics.observePanic(name)
+			result, f = nil, rpc.internalError(reqID, name, fmt.Errorf("%v", r), debug.Stack())
+		}
+	}()
+
+	result, err := proc.Handlers[0](ctx, param, &ProcessChain{
+		Process: proc,
+		context: ctx,
+		currentHandlerIndex: 0,
+		param: param,
+	})
+	if err != nil {
+		if err, ok := err.(*failure.Failure); ok {
+			return nil, err
+		}
+		return nil, rpc.internalError(reqID, name, err, nil)
+	}
+
+	return result, nil
+}
+
+// 记录错误并创建内部错误失败
+func (rpc *RPC) internalError(corrID, name string, err error, stack []byte) *failure.Failure {
+	fields := logrus.Fields{
+		"process": name,
+		"correlationId": corrID,
+		logrus.ErrorKey: err,
+	}
+	if stack != nil {
+		fields["stack"] = string(stack)
+	}
+	rpc.logger.WithFields(fields).Error("过程执行出错")
+
+	return failure.NewWithDetail(FAIL_CD_INTERNAL_ERROR, &InternalErrorFailDetail{
+		CorrelationID: corrID,
+	})
+}
 
-		ctx.JSON(http.StatusOK, map[string]interface{}{
-			"status_code": STAT_CD_OK,
-			"result": result,
-		})
+// 生成请求ID
+func newRequestID() string {
+	return strings.Replace(uuid.NewV4().String(), "-", "", -1)
+}
 
-		return nil
+func okEnvelope(result interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"status_code": STAT_CD_OK,
+		"result": result,
 	}
 }
 
-func fail(ctx echo.Context, code string, detail interface{}) {
-	ctx.JSON(http.StatusOK, map[string]interface{}{
+// 创建未经调度即失败的响应信封并记录调度日志
+func (rpc *RPC) reject(ctx Context, failCode string, detail interface{}, msg string) map[string]interface{} {
+	reqID := newRequestID()
+	rpc.dispatcherLogger.WithFields(logrus.Fields{
+		"requestId": reqID,
+		"failCode": failCode,
+		"remoteIp": ctx.RemoteIP(),
+	}).Warn(msg)
+
+	res := failEnvelope(failCode, detail)
+	res["request_id"] = reqID
+	return res
+}
+
+func failEnvelope(code string, detail interface{}) map[string]interface{} {
+	return map[string]interface{}{
 		"status_code": STAT_CD_FAIL,
 		"fail_code": code,
 		"fail_detail": detail,
-	})
+	}
 }
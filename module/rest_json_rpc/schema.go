@@ -0,0 +1,188 @@
+package rest_json_rpc
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"wawa_b.v1/module/rest_json_rpc/validation"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// JSON Schema
+type JSONSchema map[string]interface{}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(bson.ObjectId(""))
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+// 根据值的类型与json标签生成JSON Schema
+func NewJSONSchema(v interface{}) JSONSchema {
+	if v == nil {
+		return JSONSchema{}
+	}
+
+	// 参数工厂返回的指针本身不可为null
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return schemaOf(t, map[reflect.Type]bool{})
+}
+
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) JSONSchema {
+	switch t {
+	case timeType:
+		return JSONSchema{
+			"type": "string",
+			"format": "date-time",
+		}
+	case objectIDType:
+		return JSONSchema{
+			"type": "string",
+			"pattern": "^[0-9a-fA-F]{24}$",
+		}
+	case bytesType:
+		return JSONSchema{
+			"type": "string",
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := schemaOf(t.Elem(), visiting)
+		if typ, ok := schema["type"].(string); ok {
+			schema["type"] = []string{typ, "null"}
+		}
+		return schema
+	case reflect.Bool:
+		return JSONSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return JSONSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return JSONSchema{"type": "number"}
+	case reflect.String:
+		return JSONSchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return JSONSchema{
+			"type": "array",
+			"items": schemaOf(t.Elem(), visiting),
+		}
+	case reflect.Map:
+		return JSONSchema{
+			"type": "object",
+			"additionalProperties": schemaOf(t.Elem(), visiting),
+		}
+	case reflect.Struct:
+		// 递归类型不再展开
+		if visiting[t] {
+			return JSONSchema{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		props := JSONSchema{}
+		required := make([]string, 0)
+		collectProperties(t, props, &required, visiting)
+		schema := JSONSchema{
+			"type": "object",
+			"properties": props,
+		}
+		if len(required) != 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+
+	// interface{}等任意类型
+	return JSONSchema{}
+}
+
+// 收集结构体字段，匿名嵌入的结构体字段提升到外层
+func collectProperties(t reflect.Type, props JSONSchema, required *[]string, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+
+		if field.Anonymous && len(name) == 0 {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				collectProperties(ft, props, required, visiting)
+				continue
+			}
+		}
+
+		// 未导出字段
+		if len(field.PkgPath) != 0 {
+			continue
+		}
+
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		schema := schemaOf(field.Type, visiting)
+		for _, rule := range validation.ParseRules(field.Tag.Get(validation.TAG_NAME)) {
+			if rule.Name == validation.RULE_REQUIRED {
+				*required = append(*required, name)
+				continue
+			}
+			applyRule(schema, rule)
+		}
+		props[name] = schema
+	}
+}
+
+// 将校验规则转换为JSON Schema约束
+func applyRule(schema JSONSchema, rule *validation.Rule) {
+	isArray := schema["type"] == "array"
+	switch rule.Name {
+	case validation.RULE_MIN:
+		if n, err := strconv.ParseFloat(rule.Param, 64); err == nil {
+			schema["minimum"] = n
+		}
+	case validation.RULE_MAX:
+		if n, err := strconv.ParseFloat(rule.Param, 64); err == nil {
+			schema["maximum"] = n
+		}
+	case validation.RULE_LEN, validation.RULE_MIN_LEN, validation.RULE_MAX_LEN:
+		n, err := strconv.Atoi(rule.Param)
+		if err != nil {
+			return
+		}
+		minKey, maxKey := "minLength", "maxLength"
+		if isArray {
+			minKey, maxKey = "minItems", "maxItems"
+		}
+		if rule.Name != validation.RULE_MAX_LEN {
+			schema[minKey] = n
+		}
+		if rule.Name != validation.RULE_MIN_LEN {
+			schema[maxKey] = n
+		}
+	case validation.RULE_MAX_BYTES:
+		// 字符数不超过字节数，以字节数上限作为字符数上限
+		if n, err := strconv.Atoi(rule.Param); err == nil {
+			schema["maxLength"] = n
+		}
+	case validation.RULE_REGEX:
+		schema["pattern"] = rule.Param
+	case validation.RULE_OBJECT_ID:
+		schema["pattern"] = "^[0-9a-fA-F]{24}$"
+	case validation.RULE_MOBILE:
+		schema["pattern"] = "^1[3-9][0-9]{9}$"
+	}
+}
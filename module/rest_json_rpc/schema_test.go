@@ -0,0 +1,38 @@
+package rest_json_rpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type schemaTestItem struct {
+	ID       bson.ObjectId `json:"id"`
+	Quantity int `json:"quantity"`
+}
+
+type schemaTestParam struct {
+	Name    string `json:"name"`
+	LastID  *string `json:"last_id"`
+	Items   []*schemaTestItem `json:"items"`
+	Ignored string `json:"-"`
+	hidden  string
+}
+
+func TestNewJSONSchema(t *testing.T) {
+	schema := NewJSONSchema(&schemaTestParam{})
+	assert.Equal(t, "object", schema["type"])
+
+	props := schema["properties"].(JSONSchema)
+	assert.Len(t, props, 3)
+	assert.Equal(t, JSONSchema{"type": "string"}, props["name"])
+	assert.Equal(t, JSONSchema{"type": []string{"string", "null"}}, props["last_id"])
+
+	items := props["items"].(JSONSchema)
+	assert.Equal(t, "array", items["type"])
+
+	itemProps := items["items"].(JSONSchema)["properties"].(JSONSchema)
+	assert.Equal(t, "^[0-9a-fA-F]{24}$", itemProps["id"].(JSONSchema)["pattern"])
+	assert.Equal(t, JSONSchema{"type": "integer"}, itemProps["quantity"])
+}
@@ -0,0 +1,266 @@
+package rest_json_rpc
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"wawa_b.v1/module/rest_json_rpc/failure"
+)
+
+// 内置过程名
+const (
+	// 订阅主题
+	PROC_NAME_SUBSCRIBE = "rest_json_rpc.subscribe"
+
+	// 取消订阅主题
+	PROC_NAME_UNSUBSCRIBE = "rest_json_rpc.unsubscribe"
+)
+
+// 失败代码
+const (
+	// 主题不存在
+	FAIL_CD_NO_SUCH_TOPIC = "REST_JSON_RPC.NO_SUCH_TOPIC"
+
+	// 当前传输不支持订阅，仅WebSocket传输支持
+	FAIL_CD_SUBSCRIBE_UNSUPPORTED = "REST_JSON_RPC.SUBSCRIBE_UNSUPPORTED"
+)
+
+// 待分发事件队列长度，队列已满时丢弃新发布的事件
+const topicEventQueueSize = 1024
+
+// 主题事件过滤器
+// tag为发布时以订阅者当前的会话重新执行订阅处理器链的结果，返回false时该订阅不接收事件
+type TopicFilter func(tag interface{}, event interface{}) bool
+
+// 主题
+type Topic struct {
+	// 订阅处理器链，以*SubscribeParam为参数执行，可用于鉴权，链的结果作为订阅标签
+	// 订阅时执行一次，每次发布时以订阅者当前的会话重新执行，失败时该订阅不接收事件，登出或权限变更即时生效
+	Handlers []ProcessHandler
+
+	// 事件过滤器，为nil时所有订阅都接收事件
+	Filter   TopicFilter
+}
+
+// 订阅者
+type subscriber interface {
+	// 推送一个事件，不得阻塞发布者
+	push(topic string, event interface{})
+
+	// 创建发布事件时重新执行订阅处理器链的调用上下文，其会话反映订阅者当前的会话
+	eventContext() Context
+}
+
+// 主题及其订阅
+type topicSubscriptions struct {
+	*Topic
+
+	// 订阅者集
+	subscribers map[subscriber]struct{}
+}
+
+// 待分发的事件
+type publishedEvent struct {
+	// 主题名
+	topic string
+
+	// 事件
+	event interface{}
+}
+
+// 推送给订阅者的事件帧
+type EventFrame struct {
+	// 主题名
+	Topic string `json:"topic"`
+
+	// 事件
+	Event interface{} `json:"event"`
+}
+
+// 注册一个主题
+// 主题命名规范：[模块名].[事件名]，topic为nil时任何连接都可以订阅且接收所有事件
+func (rpc *RPC) RegisterTopic(name string, topic *Topic) {
+	if topic == nil {
+		topic = &Topic{}
+	}
+
+	rpc.topicMutex.Lock()
+	defer rpc.topicMutex.Unlock()
+	rpc.topics[name] = &topicSubscriptions{
+		Topic: topic,
+		subscribers: make(map[subscriber]struct{}),
+	}
+}
+
+// 发布一个事件到主题
+// 事件放入队列后立即返回，由分发协程按发布顺序推送，不阻塞发布者；队列已满时丢弃该事件
+func (rpc *RPC) Publish(name string, event interface{}) {
+	select {
+	case rpc.events <- &publishedEvent{
+		topic: name,
+		event: event,
+	}:
+	default:
+		rpc.logger.WithField("topic", name).Warn("事件队列已满，丢弃事件")
+	}
+}
+
+// 分发队列中的事件，每个RPC一个协程
+func (rpc *RPC) dispatchEvents() {
+	for pe := range rpc.events {
+		rpc.dispatchEvent(pe.topic, pe.event)
+	}
+}
+
+// 分发一个事件，向通过过滤器的订阅者推送
+// 对每个订阅者以其当前的会话重新执行订阅处理器链，失败的订阅者不接收该事件
+// 主题未注册时忽略该事件
+func (rpc *RPC) dispatchEvent(name string, event interface{}) {
+	// 处理器链可能访问数据库，在锁外执行
+	rpc.topicMutex.RLock()
+	ts, ok := rpc.topics[name]
+	var subs []subscriber
+	if ok {
+		subs = make([]subscriber, 0, len(ts.subscribers))
+		for sub := range ts.subscribers {
+			subs = append(subs, sub)
+		}
+	}
+	rpc.topicMutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	for _, sub := range subs {
+		rpc.deliverEvent(ts.Topic, sub, name, event)
+	}
+}
+
+// 向一个订阅者推送事件，处理器链或过滤器panic时记录日志，不影响其他订阅者
+func (rpc *RPC) deliverEvent(topic *Topic, sub subscriber, name string, event interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			rpc.logger.WithField("topic", name).WithField("stack", string(debug.Stack())).WithError(fmt.Errorf("%v", r)).Error("分发事件时发生panic")
+		}
+	}()
+
+	tag, err := runTopicHandlers(sub.eventContext(), topic, &SubscribeParam{
+		Topic: name,
+	})
+	if err != nil {
+		return
+	}
+	if topic.Filter == nil || topic.Filter(tag, event) {
+		sub.push(name, event)
+	}
+}
+
+// 执行主题的订阅处理器链，返回订阅标签
+func runTopicHandlers(ctx Context, topic *Topic, param *SubscribeParam) (interface{}, error) {
+	if len(topic.Handlers) == 0 {
+		return nil, nil
+	}
+	return topic.Handlers[0](ctx, param, &ProcessChain{
+		Process: &Process{
+			Handlers: topic.Handlers,
+		},
+		context: ctx,
+		currentHandlerIndex: 0,
+		param: param,
+	})
+}
+
+// 获取主题
+func (rpc *RPC) getTopic(name string) (*Topic, bool) {
+	rpc.topicMutex.RLock()
+	defer rpc.topicMutex.RUnlock()
+	ts, ok := rpc.topics[name]
+	if !ok {
+		return nil, false
+	}
+	return ts.Topic, true
+}
+
+// 添加订阅，重复订阅时忽略
+func (rpc *RPC) subscribe(name string, sub subscriber) bool {
+	rpc.topicMutex.Lock()
+	defer rpc.topicMutex.Unlock()
+	ts, ok := rpc.topics[name]
+	if !ok {
+		return false
+	}
+	ts.subscribers[sub] = struct{}{}
+	return true
+}
+
+// 移除订阅
+func (rpc *RPC) unsubscribe(name string, sub subscriber) {
+	rpc.topicMutex.Lock()
+	defer rpc.topicMutex.Unlock()
+	if ts, ok := rpc.topics[name]; ok {
+		delete(ts.subscribers, sub)
+	}
+}
+
+// 移除订阅者的所有订阅
+func (rpc *RPC) unsubscribeAll(sub subscriber) {
+	rpc.topicMutex.Lock()
+	defer rpc.topicMutex.Unlock()
+	for _, ts := range rpc.topics {
+		delete(ts.subscribers, sub)
+	}
+}
+
+type SubscribeParam struct {
+	// 主题名
+	Topic string `json:"topic" validate:"required"`
+}
+
+// 订阅主题
+// 仅WebSocket传输支持
+func SubscribeProcessHandler(rpc *RPC) ProcessHandler {
+	return func(ctx Context, p interface{}, _ *ProcessChain) (interface{}, error) {
+		param := p.(*SubscribeParam)
+
+		wsCtx, ok := ctx.(*webSocketContext)
+		if !ok {
+			return nil, failure.New(FAIL_CD_SUBSCRIBE_UNSUPPORTED)
+		}
+
+		topic, ok := rpc.getTopic(param.Topic)
+		if !ok {
+			return nil, failure.New(FAIL_CD_NO_SUCH_TOPIC)
+		}
+
+		// 订阅时执行处理器链以鉴权，订阅标签在每次发布时重新计算
+		if _, err := runTopicHandlers(ctx, topic, param); err != nil {
+			return nil, err
+		}
+
+		if !rpc.subscribe(param.Topic, wsCtx.conn) {
+			return nil, failure.New(FAIL_CD_NO_SUCH_TOPIC)
+		}
+		return nil, nil
+	}
+}
+
+type UnsubscribeParam struct {
+	// 主题名
+	Topic string `json:"topic" validate:"required"`
+}
+
+// 取消订阅主题
+// 仅WebSocket传输支持
+func UnsubscribeProcessHandler(rpc *RPC) ProcessHandler {
+	return func(ctx Context, p interface{}, _ *ProcessChain) (interface{}, error) {
+		param := p.(*UnsubscribeParam)
+
+		wsCtx, ok := ctx.(*webSocketContext)
+		if !ok {
+			return nil, failure.New(FAIL_CD_SUBSCRIBE_UNSUPPORTED)
+		}
+
+		rpc.unsubscribe(param.Topic, wsCtx.conn)
+		return nil, nil
+	}
+}
@@ -0,0 +1,306 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// 结构体标签名
+// 例：`json:"name" validate:"required,maxlen=32"`
+const TAG_NAME = "validate"
+
+// 规则名
+const (
+	// 必填：指针非nil，字符串、切片、映射非空，数值非零
+	RULE_REQUIRED = "required"
+
+	// 数值最小值
+	RULE_MIN = "min"
+
+	// 数值最大值
+	RULE_MAX = "max"
+
+	// 字符串（按字符计）、切片、映射的长度
+	RULE_LEN = "len"
+
+	// 最小长度
+	RULE_MIN_LEN = "minlen"
+
+	// 最大长度
+	RULE_MAX_LEN = "maxlen"
+
+	// 字符串（按UTF-8编码的字节计）的最大长度
+	RULE_MAX_BYTES = "maxbytes"
+
+	// 字符串匹配正则表达式，必须是标签中的最后一条规则
+	RULE_REGEX = "regex"
+
+	// 字符串是24位十六进制的ObjectId
+	RULE_OBJECT_ID = "objectid"
+
+	// 字符串是中国大陆手机号码
+	RULE_MOBILE = "mobile"
+)
+
+// 中国大陆手机号码
+var mobileRegexp *regexp.Regexp = regexp.MustCompile("^1[3-9][0-9]{9}$")
+
+// 已编译的正则表达式缓存
+var regexps map[string]*regexp.Regexp = map[string]*regexp.Regexp{}
+
+// 正则表达式缓存互斥量
+var regexpsMutex *sync.RWMutex = &sync.RWMutex{}
+
+// 规则
+type Rule struct {
+	// 规则名
+	Name  string
+
+	// 规则参数
+	Param string
+}
+
+// 违反规则的字段
+type Violation struct {
+	// 字段路径，使用json名称，例：tickets[0].quantity
+	Field string `json:"field"`
+
+	// 规则名
+	Rule  string `json:"rule"`
+
+	// 规则参数
+	Param string `json:"param,omitempty"`
+}
+
+// 解析标签中的规则
+func ParseRules(tag string) []*Rule {
+	rules := make([]*Rule, 0)
+	for len(tag) != 0 {
+		var part string
+		if strings.HasPrefix(tag, RULE_REGEX + "=") {
+			// 正则表达式可能包含逗号，取标签余下的全部内容
+			part, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			part, tag = tag[:i], tag[i + 1:]
+		} else {
+			part, tag = tag, ""
+		}
+
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		rule := &Rule{Name: part}
+		if i := strings.Index(part, "="); i >= 0 {
+			rule.Name, rule.Param = part[:i], part[i + 1:]
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// 校验值，返回所有违反规则的字段
+// 递归校验结构体、结构体指针以及它们组成的切片
+func Validate(v interface{}) []*Violation {
+	violations := make([]*Violation, 0)
+	if v != nil {
+		validateValue("", reflect.ValueOf(v), &violations)
+	}
+	return violations
+}
+
+func validateValue(path string, v reflect.Value, violations *[]*Violation) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			// 未导出字段
+			if len(field.PkgPath) != 0 && !field.Anonymous {
+				continue
+			}
+
+			fieldPath := path
+			if !field.Anonymous {
+				fieldPath = joinPath(path, fieldName(field))
+			}
+
+			fv := v.Field(i)
+			for _, rule := range ParseRules(field.Tag.Get(TAG_NAME)) {
+				if !check(rule, fv) {
+					*violations = append(*violations, &Violation{
+						Field: fieldPath,
+						Rule: rule.Name,
+						Param: rule.Param,
+					})
+				}
+			}
+
+			validateValue(fieldPath, fv, violations)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(fmt.Sprintf("%s[%d]", path, i), v.Index(i), violations)
+		}
+	}
+}
+
+// 字段的json名称
+func fieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if len(name) == 0 || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func joinPath(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+// 检查值是否满足规则
+func check(rule *Rule, v reflect.Value) bool {
+	if rule.Name == RULE_REQUIRED {
+		return !isZero(v)
+	}
+
+	// 除必填外的规则不检查nil指针
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return true
+		}
+		v = v.Elem()
+	}
+
+	switch rule.Name {
+	case RULE_MIN, RULE_MAX:
+		bound, err := strconv.ParseFloat(rule.Param, 64)
+		if err != nil {
+			panic(fmt.Errorf("无效的规则参数：%s=%s", rule.Name, rule.Param))
+		}
+		num, ok := numberOf(v)
+		if !ok {
+			return true
+		}
+		if rule.Name == RULE_MIN {
+			return num >= bound
+		}
+		return num <= bound
+	case RULE_LEN, RULE_MIN_LEN, RULE_MAX_LEN:
+		bound, err := strconv.Atoi(rule.Param)
+		if err != nil {
+			panic(fmt.Errorf("无效的规则参数：%s=%s", rule.Name, rule.Param))
+		}
+		n, ok := lengthOf(v)
+		if !ok {
+			return true
+		}
+		switch rule.Name {
+		case RULE_LEN:
+			return n == bound
+		case RULE_MIN_LEN:
+			return n >= bound
+		default:
+			return n <= bound
+		}
+	case RULE_MAX_BYTES:
+		bound, err := strconv.Atoi(rule.Param)
+		if err != nil {
+			panic(fmt.Errorf("无效的规则参数：%s=%s", rule.Name, rule.Param))
+		}
+		if v.Kind() != reflect.String {
+			return true
+		}
+		return v.Len() <= bound
+	case RULE_REGEX, RULE_OBJECT_ID, RULE_MOBILE:
+		// 格式规则不检查空字符串，需要时配合必填使用
+		if v.Kind() != reflect.String || v.Len() == 0 {
+			return true
+		}
+		switch rule.Name {
+		case RULE_REGEX:
+			return compileRegexp(rule.Param).MatchString(v.String())
+		case RULE_OBJECT_ID:
+			return bson.IsObjectIdHex(v.String())
+		default:
+			return mobileRegexp.MatchString(v.String())
+		}
+	}
+
+	panic(fmt.Errorf("未知的校验规则：%s", rule.Name))
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	}
+	return false
+}
+
+func numberOf(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func lengthOf(v reflect.Value) (int, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String()), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len(), true
+	}
+	return 0, false
+}
+
+func compileRegexp(pattern string) *regexp.Regexp {
+	regexpsMutex.RLock()
+	re, ok := regexps[pattern]
+	regexpsMutex.RUnlock()
+	if ok {
+		return re
+	}
+
+	re = regexp.MustCompile(pattern)
+
+	regexpsMutex.Lock()
+	defer regexpsMutex.Unlock()
+	regexps[pattern] = re
+	return re
+}
@@ -0,0 +1,73 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testTicket struct {
+	ID       string `json:"ticket_id" validate:"required,objectid"`
+	Quantity int `json:"quantity" validate:"min=1,max=10"`
+}
+
+type testParam struct {
+	Name    string `json:"name" validate:"required,maxlen=4,maxbytes=8"`
+	Mobile  string `json:"mobile" validate:"mobile"`
+	LastID  *string `json:"last_id" validate:"objectid"`
+	Code    string `json:"code" validate:"regex=^[0-9]{2,6}$"`
+	Tickets []*testTicket `json:"tickets" validate:"required"`
+}
+
+func TestParseRules(t *testing.T) {
+	rules := ParseRules("required, min=1,regex=^a{1,2}$")
+	assert.Len(t, rules, 3)
+	assert.Equal(t, &Rule{Name: "required"}, rules[0])
+	assert.Equal(t, &Rule{Name: "min", Param: "1"}, rules[1])
+	assert.Equal(t, &Rule{Name: "regex", Param: "^a{1,2}$"}, rules[2])
+}
+
+func TestValidate(t *testing.T) {
+	lastID := "57d8ba9c1c4ad1a7f8dd3f52"
+	assert.Empty(t, Validate(&testParam{
+		Name: "名称",
+		LastID: &lastID,
+		Code: "1234",
+		Tickets: []*testTicket{
+			{ID: "57d8ba9c1c4ad1a7f8dd3f53", Quantity: 1},
+		},
+	}))
+
+	badID := "xyz"
+	assert.Equal(t, []*Violation{
+		{Field: "name", Rule: "maxlen", Param: "4"},
+		{Field: "mobile", Rule: "mobile"},
+		{Field: "last_id", Rule: "objectid"},
+		{Field: "code", Rule: "regex", Param: "^[0-9]{2,6}$"},
+		{Field: "tickets[0].ticket_id", Rule: "objectid"},
+		{Field: "tickets[0].quantity", Rule: "min", Param: "1"},
+	}, Validate(&testParam{
+		Name: "too long",
+		Mobile: "12345",
+		LastID: &badID,
+		Code: "a",
+		Tickets: []*testTicket{
+			{ID: "zz", Quantity: 0},
+		},
+	}))
+
+	// 按字符计未超长，按字节计超长
+	assert.Equal(t, []*Violation{
+		{Field: "name", Rule: "maxbytes", Param: "8"},
+	}, Validate(&testParam{
+		Name: "名称名",
+		Tickets: []*testTicket{
+			{ID: "57d8ba9c1c4ad1a7f8dd3f53", Quantity: 1},
+		},
+	}))
+
+	assert.Equal(t, []*Violation{
+		{Field: "name", Rule: "required"},
+		{Field: "tickets", Rule: "required"},
+	}, Validate(&testParam{}))
+}
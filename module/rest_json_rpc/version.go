@@ -0,0 +1,55 @@
+package rest_json_rpc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 过程名与版本号的分隔符
+// 过程名形如user.get@2，不带版本号时注册为版本1，调用时使用最新版本
+const VERSION_SEPARATOR = "@"
+
+// 弃用信息
+type Deprecation struct {
+	// 停用日期，到期后过程可能被反注册
+	Sunset    time.Time `json:"sunset"`
+
+	// 替代的过程名
+	Successor string `json:"successor,omitempty"`
+}
+
+// 解析带版本号的过程名
+// 不带版本号时版本为0，版本号不是正整数时返回false
+func parseProcessName(name string) (string, int, bool) {
+	i := strings.LastIndex(name, VERSION_SEPARATOR)
+	if i < 0 {
+		return name, 0, len(name) != 0
+	}
+
+	ver, err := strconv.Atoi(name[i + 1:])
+	if err != nil || ver <= 0 || i == 0 {
+		return "", 0, false
+	}
+	return name[:i], ver, true
+}
+
+// 规范的过程名
+// 版本1省略版本号，与引入版本前注册的过程名保持一致
+func canonicalProcessName(base string, ver int) string {
+	if ver <= 1 {
+		return base
+	}
+	return base + VERSION_SEPARATOR + strconv.Itoa(ver)
+}
+
+// 获取过程的最新版本号
+func latestVersion(versions map[int]*Process) int {
+	latest := 0
+	for ver := range versions {
+		if ver > latest {
+			latest = ver
+		}
+	}
+	return latest
+}
@@ -0,0 +1,75 @@
+package rest_json_rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcessName(t *testing.T) {
+	cases := []struct {
+		name string
+		base string
+		ver  int
+		ok   bool
+	}{
+		{"user.get", "user.get", 0, true},
+		{"user.get@2", "user.get", 2, true},
+		{"user.get@0", "", 0, false},
+		{"user.get@x", "", 0, false},
+		{"@2", "", 0, false},
+		{"", "", 0, false},
+	}
+	for _, c := range cases {
+		base, ver, ok := parseProcessName(c.name)
+		assert.Equal(t, c.base, base, c.name)
+		assert.Equal(t, c.ver, ver, c.name)
+		assert.Equal(t, c.ok, ok, c.name)
+	}
+}
+
+func TestVersionedProcess(t *testing.T) {
+	newProc := func(result string, deprecation *Deprecation) *Process {
+		return &Process{
+			Handlers: []ProcessHandler{
+				func(_ Context, _ interface{}, _ *ProcessChain) (interface{}, error) {
+					return result, nil
+				},
+			},
+			ParamFactory: func() interface{} {
+				return &struct{}{}
+			},
+			Deprecation: deprecation,
+		}
+	}
+
+	sunset := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	rpc := NewRPC()
+	rpc.RegisterProcess("test.get", newProc("v1", &Deprecation{
+		Sunset: sunset,
+		Successor: "test.get@2",
+	}))
+	rpc.RegisterProcess("test.get@2", newProc("v2", nil))
+
+	res := serveTestHTTP(rpc, "process=test.get", ``)
+	assert.Equal(t, "v2", res["result"])
+	assert.NotContains(t, res, "deprecation")
+
+	res = serveTestHTTP(rpc, "process=test.get@1", ``)
+	assert.Equal(t, "v1", res["result"])
+	assert.Equal(t, map[string]interface{}{
+		"sunset": "2017-01-01T00:00:00Z",
+		"successor": "test.get@2",
+	}, res["deprecation"])
+
+	res = serveTestHTTP(rpc, "process=test.get@3", ``)
+	assert.Equal(t, FAIL_CD_NO_SUCH_PROCESS, res["fail_code"])
+
+	assert.Contains(t, rpc.ProcessNames(), "test.get")
+	assert.Contains(t, rpc.ProcessNames(), "test.get@2")
+
+	rpc.UnregisterProcess("test.get@2")
+	res = serveTestHTTP(rpc, "process=test.get", ``)
+	assert.Equal(t, "v1", res["result"])
+}
@@ -0,0 +1,277 @@
+package rest_json_rpc
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"wawa_b.v1/module/session"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// WebSocket单帧的最大字节数
+const webSocketReadLimit = 1 << 20
+
+// WebSocket发送队列长度
+const webSocketSendQueueSize = 64
+
+// WebSocket单帧的写超时
+const webSocketWriteTimeout = 10 * time.Second
+
+// WebSocket连接
+// 响应与推送的事件经由发送队列由同一个协程写出
+type webSocketConn struct {
+	*websocket.Conn
+
+	// 日志
+	logger         *logrus.Logger
+
+	// 发送队列
+	send           chan []byte
+
+	// 连接关闭信号
+	closed         chan struct{}
+
+	// 用于保证只关闭一次
+	closeOnce      *sync.Once
+
+	// 握手请求
+	request        *http.Request
+
+	// 受信任的代理
+	trustedProxies TrustedProxies
+
+	// 会话副本，每帧处理后更新，发布事件时据此重新执行订阅处理器链
+	sess           *session.Session
+
+	// 会话副本互斥量
+	sessMutex      sync.Mutex
+}
+
+// WebSocket调用上下文
+// 请求头、Cookie与会话取自握手请求
+type webSocketContext struct {
+	*httpContext
+
+	// 连接
+	conn *webSocketConn
+}
+
+// WebSocket事件上下文
+// 发布事件时以此重新执行订阅处理器链，会话为连接会话副本重新载入后的拷贝
+type webSocketEventContext struct {
+	*httpContext
+
+	// 会话，连接未经过会话中间件时为nil
+	sess *session.Session
+}
+
+func (ctx *webSocketEventContext) Session() *session.Session {
+	return ctx.sess
+}
+
+// 创建一个WebSocket连接
+func newWebSocketConn(conn *websocket.Conn, r *http.Request, rpc *RPC) *webSocketConn {
+	return &webSocketConn{
+		Conn: conn,
+		logger: rpc.logger,
+		send: make(chan []byte, webSocketSendQueueSize),
+		closed: make(chan struct{}),
+		closeOnce: &sync.Once{},
+		request: r,
+		trustedProxies: rpc.trustedProxies,
+	}
+}
+
+// 更新会话副本
+func (conn *webSocketConn) setSession(sess *session.Session) {
+	if sess == nil {
+		return
+	}
+	cp := sess.Copy()
+	conn.sessMutex.Lock()
+	defer conn.sessMutex.Unlock()
+	conn.sess = cp
+}
+
+// 创建发布事件时的调用上下文
+// 会话自存储器重新载入，期间其他请求的登出、会话销毁等修改均可见
+func (conn *webSocketConn) eventContext() Context {
+	conn.sessMutex.Lock()
+	var sess *session.Session
+	if conn.sess != nil {
+		sess = conn.sess.Copy()
+	}
+	conn.sessMutex.Unlock()
+
+	if sess != nil {
+		sess.Load()
+	}
+	return &webSocketEventContext{
+		httpContext: &httpContext{
+			request: conn.request,
+			values: make(map[string]interface{}),
+			trustedProxies: conn.trustedProxies,
+		},
+		sess: sess,
+	}
+}
+
+// 写出发送队列中的帧，直到连接关闭或写出失败
+func (conn *webSocketConn) writeLoop() {
+	for {
+		select {
+		case frame := <-conn.send:
+			conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				conn.close()
+				return
+			}
+		case <-conn.closed:
+			return
+		}
+	}
+}
+
+// 发送一个响应帧，发送队列已满时等待
+func (conn *webSocketConn) reply(frame []byte) {
+	select {
+	case conn.send <- frame:
+	case <-conn.closed:
+	}
+}
+
+// 推送一个事件，发送队列已满时丢弃该事件，避免慢客户端阻塞发布者
+func (conn *webSocketConn) push(topic string, event interface{}) {
+	frame, err := ffjson.Marshal(&EventFrame{
+		Topic: topic,
+		Event: event,
+	})
+	if err != nil {
+		conn.logger.WithField("topic", topic).WithError(err).Error("事件序列化失败")
+		return
+	}
+
+	select {
+	case <-conn.closed:
+	case conn.send <- frame:
+	default:
+		conn.logger.WithField("topic", topic).Warn("发送队列已满，丢弃事件")
+	}
+}
+
+// 关闭连接
+func (conn *webSocketConn) close() {
+	conn.closeOnce.Do(func() {
+		close(conn.closed)
+		conn.Conn.Close()
+	})
+}
+
+// 获取WebSocket处理器
+// 客户端在一个连接上发送多个帧，每帧为一个调用项或调用项数组，格式同批量调用项
+// 服务端逐帧响应带有调用ID的响应信封或响应信封集，订阅的事件以*EventFrame的形式推送
+// 须配合session.SessionManager.HTTPHandler使用，allowOrigins在每次握手时返回允许握手的Origin集，以便在运行时更新
+func (rpc *RPC) WebSocketHandler(allowOrigins func() []string) http.Handler {
+	upgrader := &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			if allowOrigins == nil {
+				return false
+			}
+			for _, o := range allowOrigins() {
+				if o == origin {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 会话中间件写入的Cookie需随握手响应发送
+		resHeader := http.Header{}
+		if cks, ok := w.Header()["Set-Cookie"]; ok {
+			resHeader["Set-Cookie"] = cks
+		}
+
+		wsConn, err := upgrader.Upgrade(w, r, resHeader)
+		if err != nil {
+			return
+		}
+		wsConn.SetReadLimit(webSocketReadLimit)
+
+		conn := newWebSocketConn(wsConn, r, rpc)
+		go conn.writeLoop()
+		defer func() {
+			rpc.unsubscribeAll(conn)
+			conn.close()
+		}()
+
+		// 连接建立后无法再下发Cookie，会话ID不可轮换
+		sess := session.GetSessionByRequest(r)
+		if sess != nil {
+			sess.OnCookieChange(nil)
+		}
+		conn.setSession(sess)
+		for {
+			msgType, frame, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType != websocket.TextMessage {
+				continue
+			}
+
+			// 每帧重新载入并保存会话，使其与HTTP请求对会话的修改互相可见
+			if sess != nil {
+				sess.Load()
+			}
+			res := rpc.handleFrame(&webSocketContext{
+				httpContext: rpc.newHTTPContext(r),
+				conn: conn,
+			}, frame)
+			if sess != nil {
+				sess.Save()
+			}
+			conn.setSession(sess)
+
+			resJSON, err := ffjson.Marshal(res)
+			if err != nil {
+				return
+			}
+			conn.reply(resJSON)
+		}
+	})
+}
+
+// 处理一个WebSocket帧
+func (rpc *RPC) handleFrame(ctx Context, frame []byte) interface{} {
+	if isBatchBody(frame) {
+		results, err := rpc.handleBatch(ctx, frame)
+		if err != nil {
+			return rpc.invalidFrame(ctx)
+		}
+		return results
+	}
+
+	call := &BatchCall{}
+	if err := ffjson.Unmarshal(frame, call); err != nil {
+		return rpc.invalidFrame(ctx)
+	}
+
+	res := rpc.call(ctx, call.Process, call.Param)
+	res["id"] = call.ID
+	return res
+}
+
+// 创建帧无效的响应信封并记录调度日志
+func (rpc *RPC) invalidFrame(ctx Context) map[string]interface{} {
+	return rpc.reject(ctx, FAIL_CD_INVALID_FRAME, nil, "WebSocket帧无效")
+}
@@ -0,0 +1,143 @@
+package rest_json_rpc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"wawa_b.v1/module/session"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocketHandler(t *testing.T) {
+	rpc := newTestRPC()
+	rpc.RegisterTopic("test.happened", &Topic{
+		Filter: func(_ interface{}, evt interface{}) bool {
+			return evt.(string) != "filtered"
+		},
+	})
+
+	srv := httptest.NewServer(rpc.WebSocketHandler(nil))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws" + strings.TrimPrefix(srv.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	res := make(map[string]interface{})
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{
+		"process": "test.echo",
+		"param": map[string]interface{}{"text": "hi"},
+		"id": "a",
+	}))
+	assert.NoError(t, conn.ReadJSON(&res))
+	assert.Equal(t, "a", res["id"])
+	assert.Equal(t, STAT_CD_OK, res["status_code"])
+
+	res = make(map[string]interface{})
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{
+		"process": PROC_NAME_SUBSCRIBE,
+		"param": map[string]interface{}{"topic": "test.none"},
+		"id": "b",
+	}))
+	assert.NoError(t, conn.ReadJSON(&res))
+	assert.Equal(t, FAIL_CD_NO_SUCH_TOPIC, res["fail_code"])
+
+	res = make(map[string]interface{})
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{
+		"process": PROC_NAME_SUBSCRIBE,
+		"param": map[string]interface{}{"topic": "test.happened"},
+		"id": "c",
+	}))
+	assert.NoError(t, conn.ReadJSON(&res))
+	assert.Equal(t, STAT_CD_OK, res["status_code"])
+
+	rpc.Publish("test.happened", "filtered")
+	rpc.Publish("test.happened", "delivered")
+
+	evt := &EventFrame{}
+	assert.NoError(t, conn.ReadJSON(evt))
+	assert.Equal(t, "test.happened", evt.Topic)
+	assert.Equal(t, "delivered", evt.Event)
+}
+
+func TestTopicReevaluatedOnPublish(t *testing.T) {
+	rpc := newTestRPC()
+	rpc.RegisterTopic("test.private", &Topic{
+		Handlers: []ProcessHandler{
+			func(ctx Context, _ interface{}, _ *ProcessChain) (interface{}, error) {
+				var user string
+				if ctx.Session() == nil || !ctx.Session().Get("user", &user) {
+					return nil, errors.New("未登录")
+				}
+				return user, nil
+			},
+		},
+		Filter: func(tag interface{}, evt interface{}) bool {
+			return tag.(string) == evt.(string)
+		},
+	})
+	rpc.RegisterTopic("test.public", nil)
+	rpc.RegisterTopic("test.panic", &Topic{
+		Filter: func(_ interface{}, _ interface{}) bool {
+			panic("过滤器panic")
+		},
+	})
+
+	store := session.NewMemorySessionStore(time.Minute)
+	store.Update("s1", map[string]string{"user": `"u1"`}, nil)
+	mgr := session.NewManager(&session.SessionOptions{
+		CookieName: "sid",
+		Path: "/",
+		IdleTimeout: time.Minute,
+	}, store, nil, nil)
+	srv := httptest.NewServer(mgr.HTTPHandler(rpc.WebSocketHandler(nil)))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws" + strings.TrimPrefix(srv.URL, "http"), http.Header{
+		"Cookie": []string{"sid=s1"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	for _, topic := range []string{"test.private", "test.public", "test.panic"} {
+		res := make(map[string]interface{})
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{
+			"process": PROC_NAME_SUBSCRIBE,
+			"param": map[string]interface{}{"topic": topic},
+			"id": topic,
+		}))
+		assert.NoError(t, conn.ReadJSON(&res))
+		assert.Equal(t, STAT_CD_OK, res["status_code"])
+	}
+
+	rpc.Publish("test.private", "u2")
+	rpc.Publish("test.private", "u1")
+	evt := &EventFrame{}
+	assert.NoError(t, conn.ReadJSON(evt))
+	assert.Equal(t, "u1", evt.Event)
+
+	// 会话在其他请求中被销毁后不再推送，事件按发布顺序分发，过滤器panic不影响后续事件
+	store.Delete("s1")
+	rpc.Publish("test.private", "u1")
+	rpc.Publish("test.panic", "x")
+	rpc.Publish("test.public", "after")
+	evt = &EventFrame{}
+	assert.NoError(t, conn.ReadJSON(evt))
+	assert.Equal(t, "test.public", evt.Topic)
+	assert.Equal(t, "after", evt.Event)
+}
+
+func TestSubscribeOverHTTP(t *testing.T) {
+	res := serveTestHTTP(newTestRPC(), "process=" + PROC_NAME_SUBSCRIBE, `{"topic":"test.happened"}`)
+	assert.Equal(t, FAIL_CD_SUBSCRIBE_UNSUPPORTED, res["fail_code"])
+}
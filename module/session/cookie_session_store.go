@@ -0,0 +1,115 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// Cookie会话存储器
+// 会话ID、键值对与过期时刻经AES-256-GCM加密并签名后作为Cookie值，适用于无状态部署
+// Cookie大小受浏览器限制（约4KB），不宜在会话中保存大量数据
+// 已签发的Cookie在过期前始终有效，无法在服务端吊销，重放旧Cookie可复用其中的一次性状态；须吊销会话的应用不应使用
+type CookieSessionStore struct {
+	// 加密算法
+	aead       cipher.AEAD
+
+	// 过期时间
+	expiration time.Duration
+}
+
+// Cookie会话载荷
+type cookieSessionPayload struct {
+	// 会话ID
+	ID       string `json:"i"`
+
+	// 键值对
+	KVs      map[string]string `json:"k"`
+
+	// 过期时刻（Unix时间戳，秒）
+	ExpireAt int64 `json:"e"`
+}
+
+// 创建一个Cookie会话存储器
+// 密钥由secret经SHA-256派生，更换secret将使所有会话失效
+func NewCookieSessionStore(secret string, expiration time.Duration) *CookieSessionStore {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &CookieSessionStore{
+		aead: aead,
+		expiration: expiration,
+	}
+}
+
+// 键值对保存在Cookie中，不从服务端获取
+func (store *CookieSessionStore) Get(sessID string) (map[string]string, bool) {
+	return nil, false
+}
+
+// 键值对保存在Cookie中，不写入服务端
+func (store *CookieSessionStore) Update(sessID string, changed map[string]string, removed []string) {}
+
+// 过期时刻随Cookie重新下发时刷新
+func (store *CookieSessionStore) Touch(sessID string) {}
+
+// 无法在服务端删除会话
+func (store *CookieSessionStore) Delete(sessID string) {}
+
+// 将会话ID与键值对编码为Cookie值
+func (store *CookieSessionStore) Encode(sessID string, kvs map[string]string) string {
+	plain, err := ffjson.Marshal(&cookieSessionPayload{
+		ID: sessID,
+		KVs: kvs,
+		ExpireAt: time.Now().Add(store.expiration).Unix(),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	nonce := make([]byte, store.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(store.aead.Seal(nonce, nonce, plain, nil))
+}
+
+// 从Cookie值解码出会话ID与键值对
+func (store *CookieSessionStore) Decode(value string) (string, map[string]string, bool) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < store.aead.NonceSize() {
+		return "", nil, false
+	}
+
+	nonceSize := store.aead.NonceSize()
+	plain, err := store.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", nil, false
+	}
+
+	payload := &cookieSessionPayload{}
+	if err := ffjson.Unmarshal(plain, payload); err != nil {
+		return "", nil, false
+	}
+	if len(payload.ID) == 0 || time.Now().Unix() > payload.ExpireAt {
+		return "", nil, false
+	}
+	if payload.KVs == nil {
+		payload.KVs = make(map[string]string)
+	}
+
+	return payload.ID, payload.KVs, true
+}
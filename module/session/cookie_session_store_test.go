@@ -0,0 +1,44 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieSessionStore(t *testing.T) {
+	store := NewCookieSessionStore("secret", time.Minute)
+	mgr := NewManager(&SessionOptions{
+		CookieName: "sid",
+	}, store, nil, nil)
+
+	var ckVal string
+	sess := mgr.Open("")
+	mgr.attach(sess, "", func(value string) {
+		ckVal = value
+	})
+	sess.Save()
+	assert.Empty(t, ckVal)
+
+	sess.Set("a", 1)
+	sess.Save()
+	assert.NotEmpty(t, ckVal)
+
+	reopened := mgr.Open(ckVal)
+	assert.Equal(t, sess.ID(), reopened.ID())
+	var a int
+	assert.True(t, reopened.Get("a", &a))
+	assert.Equal(t, 1, a)
+
+	tampered := []byte(ckVal)
+	tampered[len(tampered) / 2] ^= 1
+	assert.NotEqual(t, sess.ID(), mgr.Open(string(tampered)).ID())
+
+	_, _, ok := NewCookieSessionStore("other", time.Minute).Decode(ckVal)
+	assert.False(t, ok)
+
+	expired := NewCookieSessionStore("secret", -time.Minute)
+	_, _, ok = store.Decode(expired.Encode("sid", map[string]string{}))
+	assert.False(t, ok)
+}
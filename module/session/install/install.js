@@ -0,0 +1,23 @@
+db.createCollection('Sessions');
+db.Sessions.ensureIndex({
+    expireAt: 1
+}, {
+    expireAfterSeconds: 0
+});
+db.Sessions.ensureIndex({
+    'kvs.SESSION%2EUSER_ID': 1
+}, {
+    sparse: true
+});
+
+db.createCollection('RefreshTokens');
+db.RefreshTokens.ensureIndex({
+    expireAt: 1
+}, {
+    expireAfterSeconds: 0
+});
+db.RefreshTokens.ensureIndex({
+    'kvs.SESSION%2EUSER_ID': 1
+}, {
+    sparse: true
+});
@@ -0,0 +1,193 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// 内存会话存储器
+// 用于开发与测试，进程退出后会话丢失，过期的会话在读取时及定期清理时淘汰
+type MemorySessionStore struct {
+	// 用于保证sessions的并发安全
+	mutex       *sync.Mutex
+
+	// 会话
+	sessions    map[string]*memorySession
+
+	// 过期时间
+	expiration  time.Duration
+
+	// 上次清理过期会话的时间
+	lastEvicted time.Time
+}
+
+// 内存会话
+type memorySession struct {
+	// 键值对
+	kvs      map[string]string
+
+	// 过期时刻
+	expireAt time.Time
+}
+
+// 创建一个内存会话存储器
+func NewMemorySessionStore(expiration time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{
+		mutex: &sync.Mutex{},
+		sessions: make(map[string]*memorySession),
+		expiration: expiration,
+		lastEvicted: time.Now(),
+	}
+}
+
+// 获取会话的所有键值对
+func (store *MemorySessionStore) Get(sessID string) (map[string]string, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	ms, ok := store.sessions[sessID]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(ms.expireAt) {
+		delete(store.sessions, sessID)
+		return nil, false
+	}
+
+	kvs := make(map[string]string, len(ms.kvs))
+	for key, val := range ms.kvs {
+		kvs[key] = val
+	}
+	return kvs, len(kvs) != 0
+}
+
+// 写入变更的键值对、删除已移除的键，并刷新过期时间
+func (store *MemorySessionStore) Update(sessID string, changed map[string]string, removed []string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	store.evictExpired(now)
+
+	ms, ok := store.sessions[sessID]
+	if !ok || now.After(ms.expireAt) {
+		ms = &memorySession{
+			kvs: make(map[string]string),
+		}
+		store.sessions[sessID] = ms
+	}
+	for key, val := range changed {
+		ms.kvs[key] = val
+	}
+	for _, key := range removed {
+		delete(ms.kvs, key)
+	}
+	ms.expireAt = now.Add(store.expiration)
+}
+
+// 仅刷新过期时间
+func (store *MemorySessionStore) Touch(sessID string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	if ms, ok := store.sessions[sessID]; ok && !now.After(ms.expireAt) {
+		ms.expireAt = now.Add(store.expiration)
+	}
+}
+
+// 原子地取出并删除会话的所有键值对
+func (store *MemorySessionStore) Take(sessID string) (map[string]string, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	ms, ok := store.sessions[sessID]
+	if !ok {
+		return nil, false
+	}
+	delete(store.sessions, sessID)
+	if time.Now().After(ms.expireAt) {
+		return nil, false
+	}
+	return ms.kvs, len(ms.kvs) != 0
+}
+
+// 删除会话
+func (store *MemorySessionStore) Delete(sessID string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.sessions, sessID)
+}
+
+// 清理过期会话，每个过期时间周期最多清理一次
+// 调用方须持有互斥量
+func (store *MemorySessionStore) evictExpired(now time.Time) {
+	if now.Sub(store.lastEvicted) < store.expiration {
+		return
+	}
+
+	for sid, ms := range store.sessions {
+		if now.After(ms.expireAt) {
+			delete(store.sessions, sid)
+		}
+	}
+	store.lastEvicted = now
+}
+
+// 内存用户会话索引
+// 配合内存会话存储器用于开发与测试
+type MemoryUserSessionIndex struct {
+	// 用于保证sessions的并发安全
+	mutex    *sync.Mutex
+
+	// 每个用户的会话ID集
+	sessions map[string]map[string]bool
+}
+
+// 创建一个内存用户会话索引
+func NewMemoryUserSessionIndex() *MemoryUserSessionIndex {
+	return &MemoryUserSessionIndex{
+		mutex: &sync.Mutex{},
+		sessions: make(map[string]map[string]bool),
+	}
+}
+
+// 将会话加入用户的索引
+func (index *MemoryUserSessionIndex) Add(userID, sessID string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	sids, ok := index.sessions[userID]
+	if !ok {
+		sids = make(map[string]bool)
+		index.sessions[userID] = sids
+	}
+	sids[sessID] = true
+}
+
+// 将会话移出用户的索引
+func (index *MemoryUserSessionIndex) Remove(userID, sessID string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	sids, ok := index.sessions[userID]
+	if !ok {
+		return
+	}
+	delete(sids, sessID)
+	if len(sids) == 0 {
+		delete(index.sessions, userID)
+	}
+}
+
+// 获取用户的所有会话ID
+func (index *MemoryUserSessionIndex) Members(userID string) []string {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	sids := make([]string, 0, len(index.sessions[userID]))
+	for sid := range index.sessions[userID] {
+		sids = append(sids, sid)
+	}
+	return sids
+}
@@ -0,0 +1,186 @@
+package session
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoDB中会话键的转义规则，字段名不能包含.且不能以$开头
+var (
+	mongoDBKeyEscaper   = strings.NewReplacer("%", "%25", ".", "%2E", "$", "%24")
+	mongoDBKeyUnescaper = strings.NewReplacer("%2E", ".", "%24", "$", "%25", "%")
+)
+
+// MongoDB会话存储器使用的集合
+const (
+	// 会话
+	MONGODB_COLLECTION_SESSIONS = "Sessions"
+
+	// 刷新令牌
+	MONGODB_COLLECTION_REFRESH_TOKENS = "RefreshTokens"
+)
+
+// MongoDB会话存储器
+// 每个会话存储为集合中的一个文档，过期的文档由expireAt字段上的TTL索引清理
+type MongoDBSessionStore struct {
+	// 会话集合
+	sessionCollection *mgo.Collection
+
+	// 过期时间
+	expiration        time.Duration
+}
+
+// MongoDB会话
+type mongoDBSession struct {
+	// ID
+	ID       string `bson:"_id"`
+
+	// 键值对，键已转义
+	KVs      map[string]string `bson:"kvs"`
+
+	// 过期时刻
+	ExpireAt time.Time `bson:"expireAt"`
+}
+
+// 创建一个MongoDB会话存储器
+// 会话存储于MONGODB_COLLECTION_SESSIONS，刷新令牌存储于MONGODB_COLLECTION_REFRESH_TOKENS
+func NewMongoDBSessionStore(mxsDB *mgo.Database, collectionName string, expiration time.Duration) *MongoDBSessionStore {
+	return &MongoDBSessionStore{
+		sessionCollection: mxsDB.C(collectionName),
+		expiration: expiration,
+	}
+}
+
+// 获取会话的所有键值对
+// TTL索引的清理有延迟，因此读取时再次检查过期时刻
+func (store *MongoDBSessionStore) Get(sessID string) (map[string]string, bool) {
+	ms := &mongoDBSession{}
+	if err := store.sessionCollection.FindId(sessID).One(ms); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, false
+		}
+		panic(err)
+	}
+	return ms.kvs()
+}
+
+// 原子地取出并删除会话的所有键值对
+func (store *MongoDBSessionStore) Take(sessID string) (map[string]string, bool) {
+	ms := &mongoDBSession{}
+	if _, err := store.sessionCollection.FindId(sessID).Apply(mgo.Change{
+		Remove: true,
+	}, ms); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, false
+		}
+		panic(err)
+	}
+	return ms.kvs()
+}
+
+// 写入变更的键值对、删除已移除的键，并刷新过期时间
+func (store *MongoDBSessionStore) Update(sessID string, changed map[string]string, removed []string) {
+	set := bson.M{
+		"expireAt": time.Now().Add(store.expiration),
+	}
+	for key, val := range changed {
+		set["kvs." + mongoDBKeyEscaper.Replace(key)] = val
+	}
+	upd := bson.M{
+		"$set": set,
+	}
+	if len(removed) != 0 {
+		unset := bson.M{}
+		for _, key := range removed {
+			unset["kvs." + mongoDBKeyEscaper.Replace(key)] = ""
+		}
+		upd["$unset"] = unset
+	}
+
+	if _, err := store.sessionCollection.UpsertId(sessID, upd); err != nil {
+		panic(err)
+	}
+}
+
+// 反转义会话的键值对，已过期时返回false
+func (ms *mongoDBSession) kvs() (map[string]string, bool) {
+	if time.Now().After(ms.ExpireAt) {
+		return nil, false
+	}
+
+	kvs := make(map[string]string, len(ms.KVs))
+	for key, val := range ms.KVs {
+		kvs[mongoDBKeyUnescaper.Replace(key)] = val
+	}
+	return kvs, len(kvs) != 0
+}
+
+// 仅刷新过期时间
+func (store *MongoDBSessionStore) Touch(sessID string) {
+	err := store.sessionCollection.UpdateId(sessID, bson.M{
+		"$set": bson.M{
+			"expireAt": time.Now().Add(store.expiration),
+		},
+	})
+	if err != nil && err != mgo.ErrNotFound {
+		panic(err)
+	}
+}
+
+// 删除会话
+func (store *MongoDBSessionStore) Delete(sessID string) {
+	if err := store.sessionCollection.RemoveId(sessID); err != nil && err != mgo.ErrNotFound {
+		panic(err)
+	}
+}
+
+// MongoDB用户会话索引
+// 直接按会话及刷新令牌中的SESS_KEY_USER_ID查询各集合，无需单独维护索引
+type MongoDBUserSessionIndex struct {
+	// 会话及刷新令牌集合
+	sessionCollections []*mgo.Collection
+}
+
+// 创建一个MongoDB用户会话索引，须配合MongoDB会话存储器使用
+func NewMongoDBUserSessionIndex(mxsDB *mgo.Database) *MongoDBUserSessionIndex {
+	return &MongoDBUserSessionIndex{
+		sessionCollections: []*mgo.Collection{
+			mxsDB.C(MONGODB_COLLECTION_SESSIONS),
+			mxsDB.C(MONGODB_COLLECTION_REFRESH_TOKENS),
+		},
+	}
+}
+
+// 将会话加入用户的索引
+// 会话保存时随SESS_KEY_USER_ID写入，无需操作
+func (index *MongoDBUserSessionIndex) Add(userID, sessID string) {}
+
+// 将会话移出用户的索引
+// 会话删除时随文档删除，无需操作
+func (index *MongoDBUserSessionIndex) Remove(userID, sessID string) {}
+
+// 获取用户的所有会话ID
+func (index *MongoDBUserSessionIndex) Members(userID string) []string {
+	userIDJSON := `"` + userID + `"`
+
+	sids := make([]string, 0)
+	for _, coll := range index.sessionCollections {
+		mss := make([]*mongoDBSession, 0)
+		err := coll.Find(bson.M{
+			"kvs." + mongoDBKeyEscaper.Replace(SESS_KEY_USER_ID): userIDJSON,
+		}).Select(bson.M{
+			"_id": 1,
+		}).All(&mss)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, ms := range mss {
+			sids = append(sids, ms.ID)
+		}
+	}
+	return sids
+}
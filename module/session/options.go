@@ -0,0 +1,95 @@
+package session
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// SameSite属性
+const (
+	// 不下发SameSite属性，由浏览器决定
+	SAME_SITE_DEFAULT = ""
+
+	SAME_SITE_LAX = "Lax"
+
+	SAME_SITE_STRICT = "Strict"
+
+	// 跨站请求也携带Cookie，须同时启用Secure
+	SAME_SITE_NONE = "None"
+)
+
+// 会话选项
+type SessionOptions struct {
+	// Cookie键
+	CookieName      string
+
+	// Cookie的Domain属性，为空时仅限当前主机
+	// 前后端部署在不同主机时，须设置为两者共同的父域才能共享Cookie
+	Domain          string
+
+	// Cookie的Path属性
+	Path            string
+
+	// Cookie的Secure属性
+	Secure          bool
+
+	// Cookie的HttpOnly属性
+	HTTPOnly        bool
+
+	// Cookie的SameSite属性，取值见SAME_SITE_*
+	SameSite        string
+
+	// Cookie的Max-Age属性，为0时为浏览器会话Cookie
+	MaxAge          time.Duration
+
+	// 空闲超时，超过该时间未访问的会话过期，应与存储器的过期时间一致
+	IdleTimeout     time.Duration
+
+	// 绝对超时，自会话创建（或登录轮换会话ID）起超过该时间的会话过期，为0时不限制
+	AbsoluteTimeout time.Duration
+
+	// 刷新令牌的有效期，应与刷新令牌存储器的过期时间一致
+	RefreshTimeout  time.Duration
+}
+
+// 从配置读取会话选项
+// 配置项位于session下，时长的单位为毫秒
+func OptionsFromConfig(v *viper.Viper) *SessionOptions {
+	return &SessionOptions{
+		CookieName: v.GetString("session.cookie.name"),
+		Domain: v.GetString("session.cookie.domain"),
+		Path: v.GetString("session.cookie.path"),
+		Secure: v.GetBool("session.cookie.secure"),
+		HTTPOnly: v.GetBool("session.cookie.httpOnly"),
+		SameSite: v.GetString("session.cookie.sameSite"),
+		MaxAge: time.Duration(v.GetInt("session.cookie.maxAge")) * time.Millisecond,
+		IdleTimeout: time.Duration(v.GetInt("session.idleTimeout")) * time.Millisecond,
+		AbsoluteTimeout: time.Duration(v.GetInt("session.absoluteTimeout")) * time.Millisecond,
+		RefreshTimeout: time.Duration(v.GetInt("session.token.refreshTimeout")) * time.Millisecond,
+	}
+}
+
+// 生成Set-Cookie响应头的值
+func (opts *SessionOptions) cookieHeader(value string) string {
+	ck := &http.Cookie{
+		Name: opts.CookieName,
+		Value: value,
+		Domain: opts.Domain,
+		Path: opts.Path,
+		Secure: opts.Secure,
+		HttpOnly: opts.HTTPOnly,
+	}
+	if opts.MaxAge > 0 {
+		ck.MaxAge = int(opts.MaxAge / time.Second)
+		ck.Expires = time.Now().Add(opts.MaxAge)
+	}
+
+	// net/http的Cookie不支持SameSite属性，直接追加
+	header := ck.String()
+	if opts.SameSite != SAME_SITE_DEFAULT {
+		header += "; SameSite=" + opts.SameSite
+	}
+	return header
+}
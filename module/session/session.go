@@ -1,6 +1,8 @@
 package session
 
 import (
+	"context"
+	"net/http"
 	"time"
 	"strings"
 
@@ -12,20 +14,67 @@ import (
 
 const CTX_KEY_SESSION = "SESSION.SESSION"
 
+// 会话键
+const (
+	// 会话所属用户，用于维护用户会话索引
+	SESS_KEY_USER_ID = "SESSION.USER_ID"
+
+	// 会话创建时刻（Unix时间戳，毫秒），用于绝对超时
+	SESS_KEY_CREATED_TIME = "SESSION.CREATED_TIME"
+
+	// 访问令牌会话对应的刷新令牌
+	SESS_KEY_REFRESH_TOKEN = "SESSION.REFRESH_TOKEN"
+)
+
+// net/http请求上下文中会话的键类型
+type contextKey struct{}
+
 // 会话存储器
+// 键值对按键独立存储，并发请求修改不同的键时互不覆盖
 type SessionStore interface {
-	// 获取会话的所有键值对
-	Get(sessID string) (kvsJSON string, ok bool)
+	// 获取会话的所有键值对，值为JSON
+	Get(sessID string) (kvs map[string]string, ok bool)
+
+	// 写入变更的键值对、删除已移除的键，并刷新过期时间
+	Update(sessID string, changed map[string]string, removed []string)
+
+	// 仅刷新过期时间
+	Touch(sessID string)
+
+	// 删除会话
+	Delete(sessID string)
+}
 
-	// 设置会话的所有键值对
-	Set(sessID string, kvsJSON string)
+// 客户端会话存储器
+// 键值对编码在Cookie中由客户端保存，SessionStore的方法均不生效，因此无法在服务端销毁会话
+type ClientSessionStore interface {
+	SessionStore
+
+	// 将会话ID与键值对编码为Cookie值
+	Encode(sessID string, kvs map[string]string) string
+
+	// 从Cookie值解码出会话ID与键值对，Cookie值无效或已过期时返回false
+	Decode(value string) (sessID string, kvs map[string]string, ok bool)
+}
+
+// 刷新令牌存储器
+// 须与会话存储器使用不同的键空间，否则刷新令牌可作为访问令牌使用
+type RefreshTokenStore interface {
+	SessionStore
+
+	// 原子地取出并删除会话的所有键值对，并发取出同一会话时仅一个成功
+	Take(sessID string) (kvs map[string]string, ok bool)
 }
 
 // Redis会话存储器
+// 每个会话存储为一个哈希，字段为会话的键
 type RedisSessionStore struct {
 	// Redis客户端
 	client     *redis.Client
 
+	// 键前缀，多个租户共用Redis时用于隔离各租户的会话
+	prefix     string
+
 	// 过期时间
 	expiration time.Duration
 }
@@ -33,63 +82,136 @@ type RedisSessionStore struct {
 // 会话
 type Session struct {
 	// 缓存
-	cache map[string]string
+	cache          map[string]string
+
+	// 自载入以来变更的键，值为false表示已移除
+	dirty          map[string]bool
 
 	// ID
-	id    string
+	id             string
 
 	// 存储器
-	store SessionStore
+	store          SessionStore
+
+	// 自载入以来是否已刷新过期时间
+	touched        bool
+
+	// 管理器，直接创建的会话为nil
+	mgr            *SessionManager
+
+	// Cookie值变更时的回调，用于下发新的Cookie，为nil时会话ID不可轮换
+	onCookieChange func(value string)
 }
 
 type SessionManager struct {
-	// Cookie键
-	cookieName string
+	// 选项
+	options      *SessionOptions
 
 	// 存储器
-	store      SessionStore
+	store        SessionStore
+
+	// 刷新令牌存储器，为nil时不支持令牌
+	refreshStore RefreshTokenStore
+
+	// 用户会话索引，为nil时不维护
+	index        UserSessionIndex
 }
 
 // 创建一个Redis会话存储器
-func NewRedisSessionStore(client *redis.Client, expiration time.Duration) *RedisSessionStore {
+func NewRedisSessionStore(client *redis.Client, prefix string, expiration time.Duration) *RedisSessionStore {
 	return &RedisSessionStore{
 		client: client,
+		prefix: prefix,
 		expiration: expiration,
 	}
 }
 
 // 获取会话的所有键值对
-func (store *RedisSessionStore) Get(sessID string) (string, bool) {
-	existsStat := store.client.Exists(sessID)
-	if existsStat.Err() != nil {
-		panic(existsStat.Err())
+// 旧版以字符串整体存储的会话视为不存在并删除
+func (store *RedisSessionStore) Get(sessID string) (map[string]string, bool) {
+	kvs, err := store.client.HGetAll(store.prefix + sessID).Result()
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			store.client.Del(store.prefix + sessID)
+			return nil, false
+		}
+		panic(err)
 	}
 
-	if !existsStat.Val() {
-		return "", false
-	}
+	return kvs, len(kvs) != 0
+}
 
-	getStat := store.client.Get(sessID)
+// 写入变更的键值对、删除已移除的键，并刷新过期时间
+func (store *RedisSessionStore) Update(sessID string, changed map[string]string, removed []string) {
+	_, err := store.client.Pipelined(func(pipe *redis.Pipeline) error {
+		if len(changed) != 0 {
+			pipe.HMSet(store.prefix + sessID, changed)
+		}
+		if len(removed) != 0 {
+			pipe.HDel(store.prefix + sessID, removed...)
+		}
+		pipe.Expire(store.prefix + sessID, store.expiration)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
 
-	return getStat.Val(), true
+// 仅刷新过期时间
+func (store *RedisSessionStore) Touch(sessID string) {
+	if stat := store.client.Expire(store.prefix + sessID, store.expiration); stat.Err() != nil {
+		panic(stat.Err())
+	}
 }
 
-// 设置会话的所有键值对
-func (store *RedisSessionStore) Set(sessID string, kvsJSON string) {
-	if stat := store.client.Set(sessID, kvsJSON, store.expiration); stat.Err() != nil {
+// 删除会话
+func (store *RedisSessionStore) Delete(sessID string) {
+	if stat := store.client.Del(store.prefix + sessID); stat.Err() != nil {
 		panic(stat.Err())
 	}
 }
 
+// 取出会话脚本
+// KEYS[1]为会话键，返回哈希的全部字段与值，会话不存在时返回空数组
+var takeSessionScript = redis.NewScript(`
+local kvs = redis.call("HGETALL", KEYS[1])
+redis.call("DEL", KEYS[1])
+return kvs
+`)
+
+// 原子地取出并删除会话的所有键值对
+func (store *RedisSessionStore) Take(sessID string) (map[string]string, bool) {
+	res, err := takeSessionScript.Run(store.client, []string{store.prefix + sessID}).Result()
+	if err != nil {
+		panic(err)
+	}
+
+	vals, _ := res.([]interface{})
+	kvs := make(map[string]string, len(vals) / 2)
+	for i := 0; i + 1 < len(vals); i += 2 {
+		key, _ := vals[i].(string)
+		val, _ := vals[i + 1].(string)
+		kvs[key] = val
+	}
+	return kvs, len(kvs) != 0
+}
+
 // 创建一个会话
 func NewSession(id string, store SessionStore) *Session {
 	return &Session{
 		id: id,
 		cache: make(map[string]string),
+		dirty: make(map[string]bool),
 		store: store,
 	}
 }
 
+// 获取会话ID
+func (sess *Session) ID() string {
+	return sess.id
+}
+
 // 获取键对应的值
 func (sess *Session) Get(key string, val interface{}) bool {
 	valJSON, ok := sess.cache[key]
@@ -103,73 +225,324 @@ func (sess *Session) Get(key string, val interface{}) bool {
 }
 
 // 设置键对应的值
+// 值与当前值相同时不标记为已修改
 func (sess *Session) Set(key string, val interface{}) {
 	valJSON, err := ffjson.Marshal(val)
 	if err != nil {
 		panic(err)
 	}
+	if oldValJSON, ok := sess.cache[key]; ok && oldValJSON == string(valJSON) {
+		return
+	}
 	sess.cache[key] = string(valJSON)
+	sess.dirty[key] = true
 }
 
 // 删除键对应的值
 func (sess *Session) Remove(key string) {
+	if _, ok := sess.cache[key]; !ok {
+		return
+	}
 	delete(sess.cache, key)
+	sess.dirty[key] = false
+}
+
+// 自载入或保存以来是否修改过
+func (sess *Session) Modified() bool {
+	return len(sess.dirty) != 0
 }
 
-// 从存储器重新载入键值对
+// 从存储器重新载入键值对，未保存的修改将被丢弃
+// 客户端会话存储器的键值对随Cookie载入，此处仅丢弃未保存的修改标记
 func (sess *Session) Load() bool {
-	kvsJSON, ok := sess.store.Get(sess.id)
+	sess.dirty = make(map[string]bool)
+	sess.touched = false
+
+	if _, ok := sess.store.(ClientSessionStore); ok {
+		return len(sess.cache) != 0
+	}
+
+	kvs, ok := sess.store.Get(sess.id)
 	if ok {
-		kvs := make(map[string]string)
-		err := ffjson.Unmarshal([]byte(kvsJSON), &kvs)
-		if err != nil {
-			panic(err)
-		}
 		sess.cache = kvs
+	} else {
+		sess.cache = make(map[string]string)
 	}
 	return ok
 }
 
-// 保存键值对到存储器
+// 复制会话
+// 副本持有键值对的拷贝，与原会话互不影响，会话ID不可轮换，可交由其他协程使用
+func (sess *Session) Copy() *Session {
+	cp := NewSession(sess.id, sess.store)
+	for key, val := range sess.cache {
+		cp.cache[key] = val
+	}
+	cp.mgr = sess.mgr
+	return cp
+}
+
+// 保存修改到存储器
+// 仅写入修改过的键，未修改时只刷新过期时间，重复保存时不再刷新
+// 客户端会话存储器通过重新下发Cookie保存，无法下发Cookie时修改不会保存
+// 首次写入键值对时记录会话创建时刻
 func (sess *Session) Save() {
-	kvsJSON, err := ffjson.Marshal(sess.cache)
-	if err != nil {
-		panic(err)
+	if sess.Modified() {
+		if _, ok := sess.cache[SESS_KEY_CREATED_TIME]; !ok {
+			sess.Set(SESS_KEY_CREATED_TIME, time.Now().UnixNano() / int64(time.Millisecond))
+		}
+	}
+
+	if cs, ok := sess.store.(ClientSessionStore); ok {
+		if sess.onCookieChange != nil && (sess.Modified() || !sess.touched && len(sess.cache) != 0) {
+			sess.onCookieChange(cs.Encode(sess.id, sess.cache))
+		}
+		sess.dirty = make(map[string]bool)
+		sess.touched = true
+		return
+	}
+
+	if !sess.Modified() {
+		if !sess.touched {
+			sess.store.Touch(sess.id)
+			sess.touched = true
+		}
+		return
+	}
+
+	changed := make(map[string]string)
+	removed := make([]string, 0)
+	for key, set := range sess.dirty {
+		if set {
+			changed[key] = sess.cache[key]
+		} else {
+			removed = append(removed, key)
+		}
+	}
+	sess.store.Update(sess.id, changed, removed)
+	sess.dirty = make(map[string]bool)
+	sess.touched = true
+}
+
+// 设置Cookie值变更时的回调
+// 回调为nil时会话ID不可轮换，如WebSocket连接建立后无法再下发Cookie
+func (sess *Session) OnCookieChange(hook func(value string)) {
+	sess.onCookieChange = hook
+}
+
+// 获取会话的Cookie值
+// 客户端会话存储器为编码后的键值对，否则为会话ID
+func (sess *Session) cookieValue() string {
+	if cs, ok := sess.store.(ClientSessionStore); ok {
+		return cs.Encode(sess.id, sess.cache)
+	}
+	return sess.id
+}
+
+// 轮换会话ID
+// 键值对转移到新ID下，旧ID立即失效，用于登录等提升权限时防止会话固定
+// 会话创建时刻重新计算，绝对超时自轮换起算
+// 会话ID不可轮换时返回false
+func (sess *Session) Regenerate() bool {
+	if sess.onCookieChange == nil {
+		return false
+	}
+
+	oldID := sess.id
+	sess.id = newSessionID()
+	sess.store.Delete(oldID)
+	delete(sess.cache, SESS_KEY_CREATED_TIME)
+	for key := range sess.cache {
+		sess.dirty[key] = true
+	}
+
+	var userID string
+	if sess.Get(SESS_KEY_USER_ID, &userID) && sess.mgr != nil && sess.mgr.index != nil {
+		sess.mgr.index.Remove(userID, oldID)
+		sess.mgr.index.Add(userID, sess.id)
+	}
+
+	sess.onCookieChange(sess.cookieValue())
+	return true
+}
+
+// 将会话关联到用户，加入用户会话索引
+func (sess *Session) BindUser(userID string) {
+	sess.Set(SESS_KEY_USER_ID, userID)
+	if sess.mgr != nil && sess.mgr.index != nil {
+		sess.mgr.index.Add(userID, sess.id)
+	}
+}
+
+// 销毁会话
+// 删除存储器中的会话及所有键值对，并移出用户会话索引
+// 访问令牌会话对应的刷新令牌一并失效
+// 客户端会话存储器改为下发不含键值对的Cookie
+func (sess *Session) Destroy() {
+	var userID, refreshToken string
+	hasUser := sess.Get(SESS_KEY_USER_ID, &userID)
+	if hasUser && sess.mgr != nil && sess.mgr.index != nil {
+		sess.mgr.index.Remove(userID, sess.id)
+	}
+	if sess.Get(SESS_KEY_REFRESH_TOKEN, &refreshToken) && sess.mgr != nil && sess.mgr.refreshStore != nil {
+		sess.mgr.refreshStore.Delete(refreshToken)
+		if hasUser && sess.mgr.index != nil {
+			sess.mgr.index.Remove(userID, refreshToken)
+		}
+	}
+
+	sess.store.Delete(sess.id)
+	sess.cache = make(map[string]string)
+	sess.dirty = make(map[string]bool)
+
+	if _, ok := sess.store.(ClientSessionStore); ok && sess.onCookieChange != nil {
+		sess.onCookieChange(sess.cookieValue())
 	}
-	sess.store.Set(sess.id, string(kvsJSON))
 }
 
 // 创建一个会话管理器
-func NewManager(cookieName string, store SessionStore) *SessionManager {
+// 刷新令牌存储器为nil时不支持令牌，其过期时间即刷新令牌的有效期
+// 用户会话索引为nil时不支持按用户销毁会话
+func NewManager(options *SessionOptions, store SessionStore, refreshStore RefreshTokenStore, index UserSessionIndex) *SessionManager {
 	return &SessionManager{
-		cookieName: cookieName,
+		options: options,
 		store: store,
+		refreshStore: refreshStore,
+		index: index,
+	}
+}
+
+// 生成一个会话ID
+func newSessionID() string {
+	return strings.Replace(uuid.NewV4().String(), "-", "", -1)
+}
+
+// 打开会话并载入键值对
+// Cookie值为会话ID，客户端会话存储器为编码后的键值对
+// Cookie值为空或无效（含存储器中不存在的会话ID）时生成一个新的会话ID，会话已超过绝对超时时销毁并生成一个新的会话ID
+func (mgr *SessionManager) Open(ckVal string) *Session {
+	var sess *Session
+	if cs, ok := mgr.store.(ClientSessionStore); ok {
+		sid, kvs, ok := cs.Decode(ckVal)
+		if !ok {
+			sid, kvs = newSessionID(), make(map[string]string)
+		}
+
+		sess = NewSession(sid, mgr.store)
+		sess.mgr = mgr
+		sess.cache = kvs
+	} else {
+		sess = NewSession(ckVal, mgr.store)
+		sess.mgr = mgr
+		// 不沿用存储器中不存在的会话ID，防止攻击者植入会话ID造成会话固定
+		if ckVal == "" || !sess.Load() {
+			sess = NewSession(newSessionID(), mgr.store)
+			sess.mgr = mgr
+		}
+	}
+
+	if mgr.isAbsolutelyExpired(sess) {
+		sess.Destroy()
+		sess = NewSession(newSessionID(), mgr.store)
+		sess.mgr = mgr
 	}
+	return sess
 }
 
+// 以访问令牌打开会话并载入键值对
+// 访问令牌不存在、已过期或会话已超过绝对超时时返回false，不创建新会话
+func (mgr *SessionManager) OpenToken(token string) (*Session, bool) {
+	if !mgr.TokenEnabled() || token == "" {
+		return nil, false
+	}
+
+	sess := NewSession(token, mgr.store)
+	sess.mgr = mgr
+	if !sess.Load() {
+		return nil, false
+	}
+	if mgr.isAbsolutelyExpired(sess) {
+		sess.Destroy()
+		return nil, false
+	}
+	return sess, true
+}
+
+// 会话是否已超过绝对超时
+func (mgr *SessionManager) isAbsolutelyExpired(sess *Session) bool {
+	if mgr.options.AbsoluteTimeout <= 0 {
+		return false
+	}
+
+	var createdTime int64
+	if !sess.Get(SESS_KEY_CREATED_TIME, &createdTime) {
+		return false
+	}
+	return time.Now().After(time.Unix(0, createdTime * int64(time.Millisecond)).Add(mgr.options.AbsoluteTimeout))
+}
+
+// 设置会话的Cookie回调，新会话立即下发Cookie
+func (mgr *SessionManager) attach(sess *Session, ckVal string, setCookie func(value string)) {
+	sess.OnCookieChange(setCookie)
+	if _, ok := mgr.store.(ClientSessionStore); !ok && sess.ID() != ckVal {
+		setCookie(sess.ID())
+	}
+}
+
+// 销毁用户的所有会话及刷新令牌
+// exceptSessID不为空时保留该会话，用于修改密码时保留当前会话
+// 用户会话索引为nil时不生效
+func (mgr *SessionManager) DestroyUserSessions(userID string, exceptSessID string) {
+	if mgr.index == nil {
+		return
+	}
+
+	for _, sid := range mgr.index.Members(userID) {
+		if sid == exceptSessID {
+			continue
+		}
+		mgr.store.Delete(sid)
+		if mgr.refreshStore != nil {
+			mgr.refreshStore.Delete(sid)
+		}
+		mgr.index.Remove(userID, sid)
+	}
+}
+
+// 访问令牌无效时的质询
+const invalidTokenChallenge = TOKEN_TYPE_BEARER + ` error="invalid_token"`
+
 // 获取Echo中间件
+// 携带Authorization: Bearer请求头时以访问令牌打开会话，不下发Cookie，访问令牌无效时响应401
+// 处理器应在写响应前保存会话，否则客户端会话存储器的修改无法下发
 func (mgr *SessionManager) HandlerFunc() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) error {
-			var sid string
+			if token, ok := bearerToken(ctx.Request().Header().Get("Authorization")); ok {
+				sess, ok := mgr.OpenToken(token)
+				if !ok {
+					ctx.Response().Header().Set("WWW-Authenticate", invalidTokenChallenge)
+					return echo.NewHTTPError(http.StatusUnauthorized, "访问令牌无效或已过期")
+				}
+				ctx.Set(CTX_KEY_SESSION, sess)
+				defer sess.Save()
+
+				return next(ctx)
+			}
+
+			var ckVal string
 			for _, ck := range ctx.Cookies() {
-				if ck.Name() == mgr.cookieName {
-					sid = ck.Value()
+				if ck.Name() == mgr.options.CookieName {
+					ckVal = ck.Value()
 					break
 				}
 			}
 
-			if sid == "" {
-				sid = strings.Replace(uuid.NewV4().String(), "-", "", -1)
-				ck := new(echo.Cookie)
-				ck.SetName(mgr.cookieName)
-				ck.SetValue(sid)
-				ck.SetHTTPOnly(true)
-				ctx.SetCookie(ck)
-			}
+			sess := mgr.Open(ckVal)
+			mgr.attach(sess, ckVal, func(value string) {
+				ctx.Response().Header().Add("Set-Cookie", mgr.options.cookieHeader(value))
+			})
 
-			sess := NewSession(sid, mgr.store)
-			sess.Load()
 			ctx.Set(CTX_KEY_SESSION, sess)
 			defer sess.Save()
 
@@ -178,7 +551,44 @@ func (mgr *SessionManager) HandlerFunc() echo.MiddlewareFunc {
 	}
 }
 
+// 获取net/http中间件
+// 携带Authorization: Bearer请求头时以访问令牌打开会话，不下发Cookie，访问令牌无效时响应401
+// 会话Cookie写入响应头，WebSocket握手时需由处理器转交给握手响应
+// 处理器应在写响应前保存会话，否则客户端会话存储器的修改无法下发
+func (mgr *SessionManager) HTTPHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sess *Session
+		if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+			if sess, ok = mgr.OpenToken(token); !ok {
+				w.Header().Set("WWW-Authenticate", invalidTokenChallenge)
+				http.Error(w, "访问令牌无效或已过期", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			var ckVal string
+			if ck, err := r.Cookie(mgr.options.CookieName); err == nil {
+				ckVal = ck.Value
+			}
+
+			sess = mgr.Open(ckVal)
+			mgr.attach(sess, ckVal, func(value string) {
+				w.Header().Add("Set-Cookie", mgr.options.cookieHeader(value))
+			})
+		}
+
+		defer sess.Save()
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, sess)))
+	})
+}
+
 // 获取Echo上下文关联的会话
 func GetSessionByContext(ctx echo.Context) *Session {
 	return ctx.Get(CTX_KEY_SESSION).(*Session)
 }
+
+// 获取net/http请求关联的会话，未经过会话中间件时返回nil
+func GetSessionByRequest(r *http.Request) *Session {
+	sess, _ := r.Context().Value(contextKey{}).(*Session)
+	return sess
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSessionStore struct {
+	kvs     map[string]string
+	changed map[string]string
+	removed []string
+	touched int
+	deleted []string
+}
+
+func (store *recordingSessionStore) Get(sessID string) (map[string]string, bool) {
+	kvs := make(map[string]string)
+	for k, v := range store.kvs {
+		kvs[k] = v
+	}
+	return kvs, len(kvs) != 0
+}
+
+func (store *recordingSessionStore) Update(sessID string, changed map[string]string, removed []string) {
+	store.changed = changed
+	store.removed = removed
+}
+
+func (store *recordingSessionStore) Touch(sessID string) {
+	store.touched++
+}
+
+func (store *recordingSessionStore) Delete(sessID string) {
+	store.deleted = append(store.deleted, sessID)
+}
+
+func TestSessionSave(t *testing.T) {
+	store := &recordingSessionStore{
+		kvs: map[string]string{
+			"a": `1`,
+			"b": `"x"`,
+			SESS_KEY_CREATED_TIME: `0`,
+		},
+	}
+	sess := NewSession("sid", store)
+	assert.True(t, sess.Load())
+
+	sess.Set("a", 1)
+	sess.Remove("c")
+	assert.False(t, sess.Modified())
+	sess.Save()
+	assert.Equal(t, 1, store.touched)
+	assert.Nil(t, store.changed)
+
+	sess.Set("a", 2)
+	sess.Remove("b")
+	assert.True(t, sess.Modified())
+	sess.Save()
+	assert.Equal(t, 1, store.touched)
+	assert.Equal(t, map[string]string{"a": `2`}, store.changed)
+	assert.Equal(t, []string{"b"}, store.removed)
+	assert.False(t, sess.Modified())
+}
+
+func TestSessionOpenUnknownID(t *testing.T) {
+	mgr := NewManager(&SessionOptions{
+		CookieName: "sid",
+	}, &recordingSessionStore{}, nil, nil)
+
+	assert.NotEqual(t, "planted", mgr.Open("planted").ID())
+	assert.NotEmpty(t, mgr.Open("").ID())
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	store := &recordingSessionStore{
+		kvs: map[string]string{
+			"x": `0`,
+		},
+	}
+	index := NewMemoryUserSessionIndex()
+	mgr := NewManager(&SessionOptions{
+		CookieName: "sid",
+	}, store, nil, index)
+
+	sess := mgr.Open("old")
+	sess.Set("a", 1)
+	assert.False(t, sess.Regenerate())
+	assert.Equal(t, "old", sess.ID())
+
+	var cookieID string
+	sess.OnCookieChange(func(id string) {
+		cookieID = id
+	})
+	sess.BindUser("u1")
+	assert.True(t, sess.Regenerate())
+	assert.NotEqual(t, "old", sess.ID())
+	assert.Equal(t, sess.ID(), cookieID)
+	assert.Equal(t, []string{"old"}, store.deleted)
+	assert.Equal(t, []string{sess.ID()}, index.Members("u1"))
+
+	sess.Save()
+	assert.Equal(t, `1`, store.changed["a"])
+	assert.Equal(t, `"u1"`, store.changed[SESS_KEY_USER_ID])
+	assert.Contains(t, store.changed, SESS_KEY_CREATED_TIME)
+
+	other := mgr.Open("other")
+	other.BindUser("u1")
+	mgr.DestroyUserSessions("u1", sess.ID())
+	assert.Equal(t, []string{"old", "other"}, store.deleted)
+	assert.Equal(t, []string{sess.ID()}, index.Members("u1"))
+
+	sess.Destroy()
+	assert.Empty(t, index.Members("u1"))
+	assert.False(t, sess.Get("a", new(int)))
+}
+
+func TestSessionAbsoluteTimeout(t *testing.T) {
+	store := &recordingSessionStore{
+		kvs: map[string]string{
+			"a": `1`,
+			SESS_KEY_CREATED_TIME: `0`,
+		},
+	}
+	mgr := NewManager(&SessionOptions{
+		CookieName: "sid",
+		AbsoluteTimeout: time.Hour,
+	}, store, nil, nil)
+
+	sess := mgr.Open("old")
+	assert.NotEqual(t, "old", sess.ID())
+	assert.Equal(t, []string{"old"}, store.deleted)
+	assert.False(t, sess.Get("a", new(int)))
+}
+
+func TestSessionOptionsCookieHeader(t *testing.T) {
+	opts := &SessionOptions{
+		CookieName: "sid",
+		Domain: "example.com",
+		Path: "/",
+		Secure: true,
+		HTTPOnly: true,
+		SameSite: SAME_SITE_NONE,
+	}
+	assert.Equal(t, "sid=v; Path=/; Domain=example.com; HttpOnly; Secure; SameSite=None", opts.cookieHeader("v"))
+}
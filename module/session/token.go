@@ -0,0 +1,160 @@
+package session
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// 令牌类型
+const TOKEN_TYPE_BEARER = "Bearer"
+
+// 刷新令牌记录的键
+const (
+	// 签发的访问令牌
+	refreshKeyAccessToken = "SESSION.ACCESS_TOKEN"
+
+	// 签发时访问令牌会话的键值对快照，访问令牌过期后据此恢复
+	refreshKeySnapshot = "SESSION.SNAPSHOT"
+)
+
+// 令牌
+// 访问令牌为不透明的会话ID，通过Authorization: Bearer请求头携带，与Cookie会话共用会话存储器
+// 刷新令牌保存于单独的刷新令牌存储器，不能作为访问令牌使用
+type Token struct {
+	// 访问令牌
+	AccessToken  string `json:"access_token"`
+
+	// 令牌类型，固定为Bearer
+	TokenType    string `json:"token_type"`
+
+	// 访问令牌的空闲超时（秒）
+	ExpiresIn    int64 `json:"expires_in"`
+
+	// 刷新令牌，每次刷新后轮换，旧的刷新令牌立即失效
+	RefreshToken string `json:"refresh_token"`
+}
+
+// 是否支持令牌
+func (mgr *SessionManager) TokenEnabled() bool {
+	if _, ok := mgr.store.(ClientSessionStore); ok {
+		return false
+	}
+	return mgr.refreshStore != nil
+}
+
+// 签发令牌
+// 创建一个新的访问令牌会话，由init写入登录状态，并签发对应的刷新令牌
+// 不支持令牌时返回nil
+func (mgr *SessionManager) IssueToken(init func(sess *Session)) *Token {
+	if !mgr.TokenEnabled() {
+		return nil
+	}
+
+	sess := NewSession(newSessionID(), mgr.store)
+	sess.mgr = mgr
+	init(sess)
+	return mgr.issueToken(sess)
+}
+
+// 刷新令牌
+// 销毁旧的访问令牌会话，将其键值对转移到新的访问令牌会话，并轮换刷新令牌
+// 刷新令牌无效、已过期或已轮换时返回false，并发以同一刷新令牌刷新时仅一个成功
+// 刷新令牌记录无效时不删除
+func (mgr *SessionManager) RefreshToken(refreshToken string) (*Token, bool) {
+	if !mgr.TokenEnabled() {
+		return nil, false
+	}
+
+	rec, ok := mgr.refreshStore.Get(refreshToken)
+	if !ok {
+		return nil, false
+	}
+	var accToken string
+	if err := ffjson.Unmarshal([]byte(rec[refreshKeyAccessToken]), &accToken); err != nil {
+		return nil, false
+	}
+	snapshot := make(map[string]string)
+	if err := ffjson.Unmarshal([]byte(rec[refreshKeySnapshot]), &snapshot); err != nil {
+		return nil, false
+	}
+
+	// 校验后原子地取出，取出失败说明已被并发的刷新轮换
+	if _, ok := mgr.refreshStore.Take(refreshToken); !ok {
+		return nil, false
+	}
+
+	kvs, ok := mgr.store.Get(accToken)
+	if !ok {
+		kvs = snapshot
+	}
+
+	oldSess := NewSession(accToken, mgr.store)
+	oldSess.mgr = mgr
+	oldSess.cache = kvs
+	oldSess.Destroy()
+
+	// 刷新视为重新登录，会话创建时刻重新计算
+	delete(kvs, SESS_KEY_CREATED_TIME)
+	delete(kvs, SESS_KEY_REFRESH_TOKEN)
+
+	sess := NewSession(newSessionID(), mgr.store)
+	sess.mgr = mgr
+	for key, val := range kvs {
+		sess.cache[key] = val
+		sess.dirty[key] = true
+	}
+	var userID string
+	if sess.Get(SESS_KEY_USER_ID, &userID) && mgr.index != nil {
+		mgr.index.Add(userID, sess.id)
+	}
+
+	return mgr.issueToken(sess), true
+}
+
+// 为访问令牌会话签发刷新令牌并保存会话
+// 刷新令牌同样加入用户会话索引，按用户销毁会话时一并失效
+func (mgr *SessionManager) issueToken(sess *Session) *Token {
+	refreshToken := newSessionID()
+	sess.Set(SESS_KEY_REFRESH_TOKEN, refreshToken)
+	sess.Save()
+
+	accTokenJSON, err := ffjson.Marshal(sess.id)
+	if err != nil {
+		panic(err)
+	}
+	snapshotJSON, err := ffjson.Marshal(sess.cache)
+	if err != nil {
+		panic(err)
+	}
+	rec := map[string]string{
+		refreshKeyAccessToken: string(accTokenJSON),
+		refreshKeySnapshot: string(snapshotJSON),
+	}
+
+	var userID string
+	if sess.Get(SESS_KEY_USER_ID, &userID) {
+		rec[SESS_KEY_USER_ID] = sess.cache[SESS_KEY_USER_ID]
+		if mgr.index != nil {
+			mgr.index.Add(userID, refreshToken)
+		}
+	}
+	mgr.refreshStore.Update(refreshToken, rec, nil)
+
+	return &Token{
+		AccessToken: sess.id,
+		TokenType: TOKEN_TYPE_BEARER,
+		ExpiresIn: int64(mgr.options.IdleTimeout / time.Second),
+		RefreshToken: refreshToken,
+	}
+}
+
+// 从Authorization请求头取出Bearer令牌
+func bearerToken(authz string) (string, bool) {
+	prefix := TOKEN_TYPE_BEARER + " "
+	if len(authz) <= len(prefix) || !strings.EqualFold(authz[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authz[len(prefix):]), true
+}
@@ -0,0 +1,114 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToken(t *testing.T) {
+	store := NewMemorySessionStore(time.Minute)
+	refreshStore := NewMemorySessionStore(time.Hour)
+	index := NewMemoryUserSessionIndex()
+	mgr := NewManager(&SessionOptions{
+		CookieName: "sid",
+		IdleTimeout: time.Minute,
+	}, store, refreshStore, index)
+
+	tok := mgr.IssueToken(func(sess *Session) {
+		sess.Set("a", 1)
+		sess.BindUser("u1")
+	})
+	assert.Equal(t, TOKEN_TYPE_BEARER, tok.TokenType)
+	assert.Equal(t, int64(60), tok.ExpiresIn)
+
+	var a int
+	handler := mgr.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a = 0
+		GetSessionByRequest(r).Get("a", &a)
+	}))
+	serve := func(accToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer " + accToken)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := serve(tok.AccessToken)
+	assert.Equal(t, 1, a)
+	assert.Empty(t, rec.Header().Get("Set-Cookie"))
+
+	// 刷新令牌不能作为访问令牌，访问令牌不能用于刷新
+	rec = serve(tok.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	_, ok := mgr.RefreshToken(tok.AccessToken)
+	assert.False(t, ok)
+	rec = serve("unknown")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	_, ok = store.Get("unknown")
+	assert.False(t, ok)
+
+	refreshed, ok := mgr.RefreshToken(tok.RefreshToken)
+	assert.True(t, ok)
+	assert.NotEqual(t, tok.AccessToken, refreshed.AccessToken)
+	assert.NotEqual(t, tok.RefreshToken, refreshed.RefreshToken)
+	rec = serve(tok.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	serve(refreshed.AccessToken)
+	assert.Equal(t, 1, a)
+
+	_, ok = mgr.RefreshToken(tok.RefreshToken)
+	assert.False(t, ok)
+
+	mgr.DestroyUserSessions("u1", "")
+	rec = serve(refreshed.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	_, ok = mgr.RefreshToken(refreshed.RefreshToken)
+	assert.False(t, ok)
+}
+
+func TestTokenConcurrentRefresh(t *testing.T) {
+	refreshStore := NewMemorySessionStore(time.Hour)
+	mgr := NewManager(&SessionOptions{
+		CookieName: "sid",
+		IdleTimeout: time.Minute,
+	}, NewMemorySessionStore(time.Minute), refreshStore, NewMemoryUserSessionIndex())
+
+	tok := mgr.IssueToken(func(sess *Session) {
+		sess.BindUser("u1")
+	})
+
+	var wg sync.WaitGroup
+	results := make(chan bool, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok := mgr.RefreshToken(tok.RefreshToken)
+			results <- ok
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for ok := range results {
+		if ok {
+			succeeded++
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	// 无效的刷新令牌记录不被删除
+	refreshStore.Update("malformed", map[string]string{
+		refreshKeyAccessToken: `{`,
+	}, nil)
+	_, ok := mgr.RefreshToken("malformed")
+	assert.False(t, ok)
+	_, ok = refreshStore.Get("malformed")
+	assert.True(t, ok)
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"time"
+
+	"gopkg.in/redis.v4"
+)
+
+// 用户会话索引
+// 记录每个用户的活动会话，用于注销所有设备、修改密码及删除用户时销毁会话
+type UserSessionIndex interface {
+	// 将会话加入用户的索引
+	Add(userID, sessID string)
+
+	// 将会话移出用户的索引
+	Remove(userID, sessID string)
+
+	// 获取用户的所有会话ID，其中可能包含已过期的会话
+	Members(userID string) []string
+}
+
+// Redis用户会话索引
+// 每个用户的索引存储为一个集合，过期时间随加入会话刷新
+type RedisUserSessionIndex struct {
+	// Redis客户端
+	client     *redis.Client
+
+	// 键前缀
+	prefix     string
+
+	// 过期时间，应与会话过期时间一致
+	expiration time.Duration
+}
+
+// 创建一个Redis用户会话索引
+func NewRedisUserSessionIndex(client *redis.Client, prefix string, expiration time.Duration) *RedisUserSessionIndex {
+	return &RedisUserSessionIndex{
+		client: client,
+		prefix: prefix,
+		expiration: expiration,
+	}
+}
+
+// 将会话加入用户的索引
+func (index *RedisUserSessionIndex) Add(userID, sessID string) {
+	_, err := index.client.Pipelined(func(pipe *redis.Pipeline) error {
+		pipe.SAdd(index.prefix + userID, sessID)
+		pipe.Expire(index.prefix + userID, index.expiration)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// 将会话移出用户的索引
+func (index *RedisUserSessionIndex) Remove(userID, sessID string) {
+	if stat := index.client.SRem(index.prefix + userID, sessID); stat.Err() != nil {
+		panic(stat.Err())
+	}
+}
+
+// 获取用户的所有会话ID
+func (index *RedisUserSessionIndex) Members(userID string) []string {
+	sids, err := index.client.SMembers(index.prefix + userID).Result()
+	if err != nil {
+		panic(err)
+	}
+	return sids
+}
@@ -0,0 +1,99 @@
+package tenant
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/engine"
+)
+
+// 租户路由器
+// 先按Host头、再按路径前缀识别租户，并将请求转发给租户的处理器
+// 按路径前缀识别时，转发前从路径中去除前缀，租户的处理器无需感知前缀
+type Router struct {
+	// 主机名（小写）到处理器的映射
+	handlersByHost       map[string]engine.Handler
+
+	// 路径前缀到处理器的映射
+	handlersByPathPrefix map[string]engine.Handler
+
+	// 无法识别租户时使用的处理器，为nil时响应404
+	defaultHandler       engine.Handler
+}
+
+// 创建一个租户路由器
+func NewRouter() *Router {
+	return &Router{
+		handlersByHost: map[string]engine.Handler{},
+		handlersByPathPrefix: map[string]engine.Handler{},
+	}
+}
+
+// 按主机名识别租户，主机名可带端口
+func (router *Router) AddHost(host string, h engine.Handler) {
+	router.handlersByHost[strings.ToLower(host)] = h
+}
+
+// 按路径前缀识别租户，前缀形如/huahongxinxin
+func (router *Router) AddPathPrefix(prefix string, h engine.Handler) {
+	router.handlersByPathPrefix[prefix] = h
+}
+
+// 设置无法识别租户时使用的处理器
+func (router *Router) SetDefault(h engine.Handler) {
+	router.defaultHandler = h
+}
+
+func (router *Router) ServeHTTP(req engine.Request, res engine.Response) {
+	h, prefix, ok := router.resolve(req.Host(), req.URL().Path())
+	if !ok {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if len(prefix) != 0 {
+		req.URL().SetPath(stripPathPrefix(req.URL().Path(), prefix))
+		req.SetURI(stripPathPrefix(req.URI(), prefix))
+	}
+	h.ServeHTTP(req, res)
+}
+
+// 识别租户，返回租户的处理器及需去除的路径前缀
+func (router *Router) resolve(host, path string) (engine.Handler, string, bool) {
+	host = strings.ToLower(host)
+	if h, ok := router.handlersByHost[host]; ok {
+		return h, "", true
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		if h, ok := router.handlersByHost[hostname]; ok {
+			return h, "", true
+		}
+	}
+
+	// 取最长的匹配前缀
+	matchedPrefix := ""
+	var matched engine.Handler
+	for prefix, h := range router.handlersByPathPrefix {
+		if len(prefix) > len(matchedPrefix) && (path == prefix || strings.HasPrefix(path, prefix + "/")) {
+			matchedPrefix, matched = prefix, h
+		}
+	}
+	if matched != nil {
+		return matched, matchedPrefix, true
+	}
+
+	if router.defaultHandler != nil {
+		return router.defaultHandler, "", true
+	}
+	return nil, "", false
+}
+
+// 从路径（可带查询字符串）中去除前缀，去除后为空时返回/
+func stripPathPrefix(path, prefix string) string {
+	path = strings.TrimPrefix(path, prefix)
+	if len(path) == 0 || path[0] != '/' {
+		path = "/" + path
+	}
+	return path
+}
@@ -0,0 +1,50 @@
+package tenant
+
+import (
+	"testing"
+
+	"github.com/labstack/echo/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+type namedHandler string
+
+func (h namedHandler) ServeHTTP(engine.Request, engine.Response) {}
+
+func TestRouterResolve(t *testing.T) {
+	router := NewRouter()
+	router.AddHost("A.example.com", namedHandler("a"))
+	router.AddPathPrefix("/b", namedHandler("b"))
+	router.AddPathPrefix("/b/c", namedHandler("c"))
+
+	resolve := func(host, path string) (string, string) {
+		h, prefix, ok := router.resolve(host, path)
+		if !ok {
+			return "", ""
+		}
+		return string(h.(namedHandler)), prefix
+	}
+
+	h, prefix := resolve("a.example.com:8080", "/b/rest_json_rpc")
+	assert.Equal(t, "a", h)
+	assert.Equal(t, "", prefix)
+
+	h, prefix = resolve("other.example.com", "/b/rest_json_rpc")
+	assert.Equal(t, "b", h)
+	assert.Equal(t, "/b", prefix)
+
+	h, prefix = resolve("other.example.com", "/b/c/rest_json_rpc")
+	assert.Equal(t, "c", h)
+	assert.Equal(t, "/b/c", prefix)
+
+	h, _ = resolve("other.example.com", "/bc/rest_json_rpc")
+	assert.Equal(t, "", h)
+
+	router.SetDefault(namedHandler("default"))
+	h, _ = resolve("other.example.com", "/bc/rest_json_rpc")
+	assert.Equal(t, "default", h)
+
+	assert.Equal(t, "/rest_json_rpc?process=user.get", stripPathPrefix("/b/rest_json_rpc?process=user.get", "/b"))
+	assert.Equal(t, "/", stripPathPrefix("/b", "/b"))
+	assert.Equal(t, "/?a=1", stripPathPrefix("/b?a=1", "/b"))
+}
@@ -0,0 +1,255 @@
+package business
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"wawa_b.v1/module/js_regex"
+	"wawa_b.v1/module/rest_json_rpc/failure"
+	"wawa_b.v1/module/user/domain"
+	"wawa_b.v1/module/user/domain/permission"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// 失败代码
+const (
+	// 不能删除内置角色
+	FAIL_CD_CANNOT_DELETE_BUILTIN_ROLE = "USER.CANNOT_DELETE_BUILTIN_ROLE"
+
+	// 不能修改内置角色
+	FAIL_CD_CANNOT_MODIFY_BUILTIN_ROLE = "USER.CANNOT_MODIFY_BUILTIN_ROLE"
+
+	// 角色名重复
+	FAIL_CD_DUPLICATE_ROLE_NAME = "USER.DUPLICATE_ROLE_NAME"
+
+	// 角色不存在
+	FAIL_CD_NO_SUCH_ROLE = "USER.NO_SUCH_ROLE"
+
+	// 角色仍被用户持有
+	FAIL_CD_ROLE_IN_USE = "USER.ROLE_IN_USE"
+)
+
+// 角色缓存的有效期
+// 本实例修改角色时立即刷新，多实例部署时其他实例的修改（含删除角色、收窄角色的权限）最迟在该时间后生效
+// 每次权限检查都读取角色，缓存仅用于合并短时间内的读取，不宜延长
+const ROLE_CACHE_TTL = 5 * time.Second
+
+// 角色管理器
+type RoleManager interface {
+	// 创建一个角色，权限项须已注册
+	Create(name, title string, perms []string) error
+
+	// 删除一个角色，内置角色及仍被用户持有的角色不能删除
+	Delete(name string) error
+
+	// 获取一个角色，不存在时返回nil
+	Get(name string) (*domain.Role, error)
+
+	// 获取全部角色，按角色名排序
+	List() ([]*domain.Role, error)
+
+	// 获取角色集的权限集之并，忽略不存在的角色
+	// 角色名以@<资源ID>结尾时（即限定资源的角色），其权限项限定于该资源
+	Permissions(names []string) ([]string, error)
+
+	// 更新一个角色的显示名及权限集，权限项须已注册，内置角色不能更新
+	Update(name, title string, perms []string) error
+}
+
+// MongoDB角色管理器
+// 角色数量少且读多写少，全部角色缓存在内存中
+type MongoDBRoleManager struct {
+	// 角色集合
+	roleCollection      *mgo.Collection
+
+	// 用户集合
+	userCollection      *mgo.Collection
+
+	// 缓存互斥量
+	cacheMutex          sync.Mutex
+
+	// 角色名到角色的缓存，为nil时须重新加载
+	cache               map[string]*domain.Role
+
+	// 缓存过期时间
+	cacheExpirationTime time.Time
+}
+
+// 创建一个MongoDB角色管理器
+func NewMongoDBRoleManager(masterDB *mgo.Database) *MongoDBRoleManager {
+	return &MongoDBRoleManager{
+		roleCollection: masterDB.C("Roles"),
+		userCollection: masterDB.C("Users"),
+	}
+}
+
+func (mgr *MongoDBRoleManager) Create(name, title string, perms []string) error {
+	existing, err := mgr.Get(name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return failure.New(FAIL_CD_DUPLICATE_ROLE_NAME)
+	}
+	if unknown := permission.Unknown(perms); len(unknown) != 0 {
+		return failure.NewWithDetail(FAIL_CD_UNKNOWN_PERMISSIONS, unknown)
+	}
+
+	defer mgr.invalidateCache()
+	err = mgr.roleCollection.Insert(&domain.Role{
+		Name: name,
+		Title: title,
+		Permissions: permission.Permissions(perms),
+	})
+	if mgo.IsDup(err) {
+		return failure.New(FAIL_CD_DUPLICATE_ROLE_NAME)
+	}
+	return err
+}
+
+func (mgr *MongoDBRoleManager) Delete(name string) error {
+	role, err := mgr.Get(name)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		return nil
+	}
+	if role.Builtin {
+		return failure.New(FAIL_CD_CANNOT_DELETE_BUILTIN_ROLE)
+	}
+
+	// 包括限定资源的角色
+	n, err := mgr.userCollection.Find(bson.M{
+		"roles": bson.M{
+			"$in": []interface{}{
+				name,
+				bson.RegEx{
+					Pattern: "^" + js_regex.EscapeTextPattern(name + permission.RESOURCE_SEPARATOR),
+				},
+			},
+		},
+	}).Count()
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		return failure.New(FAIL_CD_ROLE_IN_USE)
+	}
+
+	defer mgr.invalidateCache()
+	return mgr.roleCollection.RemoveId(role.ID)
+}
+
+func (mgr *MongoDBRoleManager) Get(name string) (*domain.Role, error) {
+	roles, err := mgr.cachedRoles()
+	if err != nil {
+		return nil, err
+	}
+	return roles[name], nil
+}
+
+func (mgr *MongoDBRoleManager) List() ([]*domain.Role, error) {
+	roles, err := mgr.cachedRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*domain.Role, 0, len(names))
+	for _, name := range names {
+		list = append(list, roles[name])
+	}
+	return list, nil
+}
+
+func (mgr *MongoDBRoleManager) Permissions(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return []string{}, nil
+	}
+
+	roles, err := mgr.cachedRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	permSets := make([][]string, 0, len(names))
+	for _, name := range names {
+		name, resourceID := permission.SplitScope(name)
+		if role, ok := roles[name]; ok {
+			permSets = append(permSets, permission.ScopeAll(role.Permissions, resourceID))
+		}
+	}
+	return permission.Union(permSets...), nil
+}
+
+func (mgr *MongoDBRoleManager) Update(name, title string, perms []string) error {
+	if unknown := permission.Unknown(perms); len(unknown) != 0 {
+		return failure.NewWithDetail(FAIL_CD_UNKNOWN_PERMISSIONS, unknown)
+	}
+
+	role, err := mgr.Get(name)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		return failure.New(FAIL_CD_NO_SUCH_ROLE)
+	}
+	if role.Builtin {
+		return failure.New(FAIL_CD_CANNOT_MODIFY_BUILTIN_ROLE)
+	}
+
+	defer mgr.invalidateCache()
+	err = mgr.roleCollection.Update(bson.M{
+		"name": name,
+		"builtin": bson.M{
+			"$ne": true,
+		},
+	}, bson.M{
+		"$set": bson.M{
+			"title": title,
+			"permissions": permission.Permissions(perms),
+		},
+	})
+	if err == mgo.ErrNotFound {
+		return failure.New(FAIL_CD_NO_SUCH_ROLE)
+	}
+	return err
+}
+
+// 获取缓存的全部角色，缓存过期时重新加载
+// 返回的映射及角色不应修改
+func (mgr *MongoDBRoleManager) cachedRoles() (map[string]*domain.Role, error) {
+	mgr.cacheMutex.Lock()
+	defer mgr.cacheMutex.Unlock()
+
+	if mgr.cache != nil && time.Now().Before(mgr.cacheExpirationTime) {
+		return mgr.cache, nil
+	}
+
+	roles := make([]*domain.Role, 0)
+	if err := mgr.roleCollection.Find(nil).All(&roles); err != nil {
+		return nil, err
+	}
+	mgr.cache = make(map[string]*domain.Role, len(roles))
+	for _, role := range roles {
+		mgr.cache[role.Name] = role
+	}
+	mgr.cacheExpirationTime = time.Now().Add(ROLE_CACHE_TTL)
+	return mgr.cache, nil
+}
+
+// 使缓存失效，下次读取时重新加载
+func (mgr *MongoDBRoleManager) invalidateCache() {
+	mgr.cacheMutex.Lock()
+	defer mgr.cacheMutex.Unlock()
+	mgr.cache = nil
+}
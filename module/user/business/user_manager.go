@@ -1,8 +1,10 @@
 package business
 
 import (
-	"wawa_b.v1/module/md5"
+	"time"
+
 	"wawa_b.v1/module/js_regex"
+	"wawa_b.v1/module/password"
 	"wawa_b.v1/module/rest_json_rpc/failure"
 	"wawa_b.v1/module/user/domain"
 	"wawa_b.v1/module/user/domain/permission"
@@ -21,42 +23,76 @@ const (
 
 	// 用户类型无效
 	FAIL_CD_INVALID_USER_KIND = "USER.INVALID_USER_KIND"
+
+	// 未知的权限，详情为未知的权限项
+	FAIL_CD_UNKNOWN_PERMISSIONS = "USER.UNKNOWN_PERMISSIONS"
 )
 
 // 用户管理器
 type UserManager interface {
+	// 为用户分配角色，角色不存在时返回失败
+	// 角色名可以@<资源ID>结尾，此时角色的权限项限定于该资源
+	// 授权人须能授予角色（限定于资源时为限定后）的全部权限项，避免借助角色提升权限
+	AssignRole(granterID, userID, roleName string) error
+
 	// 绑定微信OpenID
-	BindWechatOpenID(userID, wechatOpenID string)
+	BindWechatOpenID(userID, wechatOpenID string) error
 
 	// 删除一个用户
-	Delete(id string)
+	Delete(id string) error
+
+	// 获取用户的有效权限集，即直接授予的权限集与各角色的权限集之并
+	EffectivePermissions(user *domain.User) ([]string, error)
 
-	// 获取一个用户
-	Get(id string) *domain.User
+	// 获取一个用户，不存在时返回nil
+	Get(id string) (*domain.User, error)
 
-	// 根据名称获取一个用户
-	GetByName(name string) *domain.User
+	// 根据名称获取一个用户，不存在时返回nil
+	GetByName(name string) (*domain.User, error)
 
-	// 根据微信OpenID获取一个用户
-	GetByWechatOpenID(wechatOpenID string) *domain.User
+	// 根据微信OpenID获取一个用户，不存在时返回nil
+	GetByWechatOpenID(wechatOpenID string) (*domain.User, error)
 
-	// 授予用户权限
-	GrantFlatPermissions(granterID, granteeID string, perms []string) error
+	// 授予用户权限，保留用户已有的权限项
+	// 权限项须已注册，授权人须能授予全部权限项
+	GrantPermissions(granterID, granteeID string, perms []string) error
+
+	// 授予用户限定于资源的权限，保留用户已有的权限项
+	// 权限项须已注册，授权人须能授予限定于该资源的全部权限项
+	GrantResourcePermissions(granterID, granteeID, resourceID string, perms []string) error
+
+	// 检索权限变更记录，按时间倒序，granteeID及granterID为空时不限
+	PermissionHistory(lastID *string, limit int, granteeID, granterID string) ([]*domain.PermissionGrant, error)
 
 	// 注册一个新用户
 	Register(kind, name, password, nickname, mobile string) error
 
 	// 用户检索
-	Retrieve(lastID *string, limit int, name string, nickname string) []*domain.User
+	Retrieve(lastID *string, limit int, name string, nickname string) ([]*domain.User, error)
+
+	// 撤销用户的角色，用户未持有该角色时不记录权限变更
+	// 撤销人须能授予角色的全部权限项，只能撤销自己能分配的角色
+	UnassignRole(revokerID, userID, roleName string) error
 
 	// 解绑微信OpenID
-	UnbindWechatOpenID(wechatOpenID string)
+	UnbindWechatOpenID(wechatOpenID string) error
+
+	// 撤销用户直接授予的权限项，撤销人须能授予这些权限项
+	// 用户未持有其中任何权限项时不记录权限变更
+	RevokePermissions(revokerID, granteeID string, perms []string) error
+
+	// 撤销用户限定于资源的权限，撤销人须能授予这些权限项
+	RevokeResourcePermissions(revokerID, granteeID, resourceID string, perms []string) error
 
 	// 更新一个用户的基本信息
-	Update(id, nickname string)
+	Update(id, nickname string) error
 
 	// 修改一个用户密码
-	UpdatePassword(name, password string)
+	UpdatePassword(id, password string) error
+
+	// 校验用户的密码
+	// 校验成功且保存的散列需要升级（旧版MD5摘要或散列参数已变化）时，以当前的算法重新计算并保存
+	VerifyPassword(user *domain.User, password string) (bool, error)
 }
 
 // MongoDB用户管理器
@@ -67,115 +103,219 @@ type MongoDBUserManager struct {
 	// 微信用户集合
 	wechatUserBindingCollection  *mgo.Collection
 
-	// 用户类型到权限集合的映射
-	flatPermissionsByKind map[string][]string
+	// 权限变更记录集合
+	permissionGrantCollection    *mgo.Collection
+
+	// 用户类型到角色名集的映射，注册时按类型分配角色
+	rolesByKind           map[string][]string
+
+	// 角色管理器
+	roleManager           RoleManager
+
+	// 密码散列器
+	passwordHasher        password.PasswordHasher
 }
 
 // 创建一个MongoDB用户管理器
-func NewMongoDBUserManager(masterDB *mgo.Database, flatPermissionsByKind map[string][]string) *MongoDBUserManager {
+func NewMongoDBUserManager(masterDB *mgo.Database, passwordHasher password.PasswordHasher, roleMgr RoleManager, rolesByKind map[string][]string) *MongoDBUserManager {
 	return &MongoDBUserManager{
 		userCollection: masterDB.C("Users"),
 		wechatUserBindingCollection: masterDB.C("WechatUserBindings"),
-		flatPermissionsByKind: flatPermissionsByKind,
+		permissionGrantCollection: masterDB.C("PermissionGrants"),
+		rolesByKind: rolesByKind,
+		roleManager: roleMgr,
+		passwordHasher: passwordHasher,
 	}
 }
 
-func (mgr *MongoDBUserManager) BindWechatOpenID(userID, openID string) {
-	if _, err := mgr.wechatUserBindingCollection.Upsert(bson.M{
+func (mgr *MongoDBUserManager) AssignRole(granterID, userID, roleName string) error {
+	name, resourceID := permission.SplitScope(roleName)
+	role, err := mgr.roleManager.Get(name)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		return failure.New(FAIL_CD_NO_SUCH_ROLE)
+	}
+	perms := permission.ScopeAll(role.Permissions, resourceID)
+	if err := mgr.ensureCanGrant(granterID, perms); err != nil {
+		return err
+	}
+	if err := mgr.userCollection.UpdateId(bson.ObjectIdHex(userID), bson.M{
+		"$addToSet": bson.M{
+			"roles": roleName,
+		},
+	}); err != nil {
+		return err
+	}
+	return mgr.recordPermissionGrant(domain.PERMISSION_GRANT_ACTION_ASSIGN_ROLE, granterID, userID, roleName, perms)
+}
+
+func (mgr *MongoDBUserManager) BindWechatOpenID(userID, openID string) error {
+	_, err := mgr.wechatUserBindingCollection.Upsert(bson.M{
 		"openId": openID,
 	}, bson.M{
 		"openId": openID,
 		"userId": bson.ObjectIdHex(userID),
-	}); err != nil {
-		panic(err)
-	}
+	})
+	return err
+}
+
+func (mgr *MongoDBUserManager) Delete(id string) error {
+	return mgr.userCollection.RemoveId(bson.ObjectIdHex(id))
 }
 
-func (mgr *MongoDBUserManager) Delete(id string) {
-	if err := mgr.userCollection.RemoveId(bson.ObjectIdHex(id)); err != nil {
-		panic(err)
+func (mgr *MongoDBUserManager) EffectivePermissions(user *domain.User) ([]string, error) {
+	rolePerms, err := mgr.roleManager.Permissions(user.Roles)
+	if err != nil {
+		return nil, err
 	}
+	return permission.Union(user.FlatPermissions, rolePerms), nil
 }
 
-func (mgr *MongoDBUserManager) Get(id string) *domain.User {
+func (mgr *MongoDBUserManager) Get(id string) (*domain.User, error) {
 	users := make([]*domain.User, 0)
 	if err := mgr.userCollection.FindId(bson.ObjectIdHex(id)).All(&users); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(users) == 0 {
-		return nil
+		return nil, nil
 	}
-	return users[0]
+	return users[0], nil
 }
 
-func (mgr *MongoDBUserManager) GetByName(name string) *domain.User {
+func (mgr *MongoDBUserManager) GetByName(name string) (*domain.User, error) {
 	users := make([]*domain.User, 0)
 	if err := mgr.userCollection.Find(bson.M{
 		"name": name,
 	}).All(&users); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(users) == 0 {
-		return nil
+		return nil, nil
 	}
-	return users[0]
+	return users[0], nil
 }
 
-func (mgr *MongoDBUserManager) GetByWechatOpenID(openID string) *domain.User {
+func (mgr *MongoDBUserManager) GetByWechatOpenID(openID string) (*domain.User, error) {
 	bindings := make([]*domain.WechatUserBinding, 0)
 	if err := mgr.wechatUserBindingCollection.Find(bson.M{
 		"openId": openID,
 	}).All(&bindings); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if len(bindings) == 0 {
-		return nil
+		return nil, nil
 	}
 	return mgr.Get(bindings[0].UserID.Hex())
 }
 
-func (mgr *MongoDBUserManager) GrantFlatPermissions(granterID, granteeID string, perms []string) error {
-	greater := mgr.Get(granterID)
-	if greater == nil {
-		return nil
-	}
-
-	if !permission.IsInclude(greater.FlatPermissions, perms) {
-		return failure.New(FAIL_CD_GRANTER_NO_THESE_PERMISSIONS)
+func (mgr *MongoDBUserManager) GrantPermissions(granterID, granteeID string, perms []string) error {
+	perms = permission.Permissions(perms)
+	if err := mgr.ensureCanGrant(granterID, perms); err != nil {
+		return err
 	}
-
 	if err := mgr.userCollection.UpdateId(bson.ObjectIdHex(granteeID), bson.M{
-		"$set": bson.M{
-			"flatPermissions": permission.Permissions(perms),
+		"$addToSet": bson.M{
+			"flatPermissions": bson.M{
+				"$each": perms,
+			},
 		},
 	}); err != nil {
-		panic(err)
+		return err
 	}
+	return mgr.recordPermissionGrant(domain.PERMISSION_GRANT_ACTION_GRANT, granterID, granteeID, "", perms)
+}
 
-	return nil
+func (mgr *MongoDBUserManager) GrantResourcePermissions(granterID, granteeID, resourceID string, perms []string) error {
+	return mgr.GrantPermissions(granterID, granteeID, permission.ScopeAll(perms, resourceID))
 }
 
-func (mgr *MongoDBUserManager) Register(kind, name, password, nickname, mobile string) error {
-	perms, ok := mgr.flatPermissionsByKind[kind]
+func (mgr *MongoDBUserManager) PermissionHistory(lastID *string, limit int, granteeID, granterID string) ([]*domain.PermissionGrant, error) {
+	grants := make([]*domain.PermissionGrant, 0)
+	query := bson.M{}
+	if lastID != nil {
+		query["_id"] = bson.M{
+			"$lt": bson.ObjectIdHex(*lastID),
+		}
+	}
+	if len(granteeID) != 0 {
+		query["granteeId"] = bson.ObjectIdHex(granteeID)
+	}
+	if len(granterID) != 0 {
+		query["granterId"] = bson.ObjectIdHex(granterID)
+	}
+	if err := mgr.permissionGrantCollection.Find(query).Sort("-_id").Limit(limit).All(&grants); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+func (mgr *MongoDBUserManager) Register(kind, name, passwd, nickname, mobile string) error {
+	roles, ok := mgr.rolesByKind[kind]
 	if !ok {
 		return failure.New(FAIL_CD_INVALID_USER_KIND)
 	}
-	if mgr.GetByName(name) != nil {
+	existing, err := mgr.GetByName(name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
 		return failure.New(FAIL_CD_DUPLICATE_USER_NAME)
 	}
-	if err := mgr.userCollection.Insert(&domain.User{
+	digest, err := mgr.passwordHasher.Hash(passwd)
+	if err != nil {
+		return err
+	}
+	return mgr.userCollection.Insert(&domain.User{
 		Name: name,
-		PasswordDigest: md5.StringDigest([]byte(password)),
+		PasswordDigest: digest,
 		Nickname: nickname,
 		Mobile: mobile,
-		FlatPermissions: permission.Permissions(perms),
+		FlatPermissions: []string{},
+		Roles: append([]string{}, roles...),
+	})
+}
+
+func (mgr *MongoDBUserManager) RevokePermissions(revokerID, granteeID string, perms []string) error {
+	perms = permission.Permissions(perms)
+	if err := mgr.ensureCanGrant(revokerID, perms); err != nil {
+		return err
+	}
+
+	grantee, err := mgr.Get(granteeID)
+	if err != nil {
+		return err
+	}
+	if grantee == nil {
+		return mgo.ErrNotFound
+	}
+	held := permission.PermissionSet(grantee.FlatPermissions)
+	revoked := make([]string, 0, len(perms))
+	for _, perm := range perms {
+		if held.Has(perm) {
+			revoked = append(revoked, perm)
+		}
+	}
+	if len(revoked) == 0 {
+		return nil
+	}
+
+	if err := mgr.userCollection.UpdateId(grantee.ID, bson.M{
+		"$pullAll": bson.M{
+			"flatPermissions": revoked,
+		},
 	}); err != nil {
-		panic(err)
+		return err
 	}
-	return nil
+	return mgr.recordPermissionGrant(domain.PERMISSION_GRANT_ACTION_REVOKE, revokerID, granteeID, "", revoked)
+}
+
+func (mgr *MongoDBUserManager) RevokeResourcePermissions(revokerID, granteeID, resourceID string, perms []string) error {
+	return mgr.RevokePermissions(revokerID, granteeID, permission.ScopeAll(perms, resourceID))
 }
 
-func (mgr *MongoDBUserManager) Retrieve(lastID *string, limit int, name, nickname string) []*domain.User {
+func (mgr *MongoDBUserManager) Retrieve(lastID *string, limit int, name, nickname string) ([]*domain.User, error) {
 	users := make([]*domain.User, 0)
 	query := bson.M{}
 	if lastID != nil {
@@ -194,35 +334,130 @@ func (mgr *MongoDBUserManager) Retrieve(lastID *string, limit int, name, nicknam
 		}
 	}
 	if err := mgr.userCollection.Find(query).Sort("_id").Limit(limit).All(&users); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return users
+	return users, nil
 }
 
-func (mgr *MongoDBUserManager) UnbindWechatOpenID(wechatOpenID string) {
-	if err := mgr.wechatUserBindingCollection.Remove(bson.M{
-		"openId": wechatOpenID,
-	}); err != nil {
-		panic(err)
+func (mgr *MongoDBUserManager) UnassignRole(revokerID, userID, roleName string) error {
+	// 角色已删除时权限项为空，任何人都可撤销
+	name, resourceID := permission.SplitScope(roleName)
+	perms := []string{}
+	role, err := mgr.roleManager.Get(name)
+	if err != nil {
+		return err
+	}
+	if role != nil {
+		perms = permission.ScopeAll(role.Permissions, resourceID)
+	}
+	if err := mgr.ensureCanGrant(revokerID, perms); err != nil {
+		return err
+	}
+
+	// 仅在用户持有该角色时撤销并记录
+	err = mgr.userCollection.Update(bson.M{
+		"_id": bson.ObjectIdHex(userID),
+		"roles": roleName,
+	}, bson.M{
+		"$pull": bson.M{
+			"roles": roleName,
+		},
+	})
+	if err == mgo.ErrNotFound {
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+	return mgr.recordPermissionGrant(domain.PERMISSION_GRANT_ACTION_UNASSIGN_ROLE, revokerID, userID, roleName, perms)
+}
+
+func (mgr *MongoDBUserManager) UnbindWechatOpenID(wechatOpenID string) error {
+	return mgr.wechatUserBindingCollection.Remove(bson.M{
+		"openId": wechatOpenID,
+	})
 }
 
-func (mgr *MongoDBUserManager) Update(id, nickname string) {
-	if err := mgr.userCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
+func (mgr *MongoDBUserManager) Update(id, nickname string) error {
+	return mgr.userCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
 		"$set": bson.M{
 			"nickname": nickname,
 		},
-	}); err != nil {
-		panic(err)
+	})
+}
+
+func (mgr *MongoDBUserManager) UpdatePassword(id, passwd string) error {
+	digest, err := mgr.passwordHasher.Hash(passwd)
+	if err != nil {
+		return err
 	}
+	return mgr.userCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
+		"$set": bson.M{
+			"passwordDigest": digest,
+		},
+	})
 }
 
-func (mgr *MongoDBUserManager) UpdatePassword(id, password string) {
-	if err := mgr.userCollection.UpdateId(bson.ObjectIdHex(id), bson.M{
+func (mgr *MongoDBUserManager) VerifyPassword(user *domain.User, passwd string) (bool, error) {
+	ok, err := mgr.passwordHasher.Verify(passwd, user.PasswordDigest)
+	if err != nil || !ok {
+		return false, err
+	}
+	if !mgr.passwordHasher.NeedsRehash(user.PasswordDigest) {
+		return true, nil
+	}
+
+	digest, err := mgr.passwordHasher.Hash(passwd)
+	if err != nil {
+		return false, err
+	}
+	// 仅在散列未被并发修改时升级，避免覆盖同时修改的新密码
+	err = mgr.userCollection.Update(bson.M{
+		"_id": user.ID,
+		"passwordDigest": user.PasswordDigest,
+	}, bson.M{
 		"$set": bson.M{
-			"passwordDigest": md5.StringDigest([]byte(password)),
+			"passwordDigest": digest,
 		},
-	}); err != nil {
-		panic(err)
+	})
+	if err != nil && err != mgo.ErrNotFound {
+		return false, err
 	}
+	user.PasswordDigest = digest
+	return true, nil
+}
+
+// 确保权限项均已注册且授权人能授予全部权限项
+func (mgr *MongoDBUserManager) ensureCanGrant(granterID string, perms []string) error {
+	if unknown := permission.Unknown(perms); len(unknown) != 0 {
+		return failure.NewWithDetail(FAIL_CD_UNKNOWN_PERMISSIONS, unknown)
+	}
+
+	granter, err := mgr.Get(granterID)
+	if err != nil {
+		return err
+	}
+	if granter == nil {
+		return failure.New(FAIL_CD_GRANTER_NO_THESE_PERMISSIONS)
+	}
+	granterPerms, err := mgr.EffectivePermissions(granter)
+	if err != nil {
+		return err
+	}
+	if !permission.CanGrant(granterPerms, perms) {
+		return failure.New(FAIL_CD_GRANTER_NO_THESE_PERMISSIONS)
+	}
+	return nil
+}
+
+// 记录一次权限变更，role为空时为直接授予或撤销权限项
+func (mgr *MongoDBUserManager) recordPermissionGrant(action, granterID, granteeID, role string, perms []string) error {
+	return mgr.permissionGrantCollection.Insert(&domain.PermissionGrant{
+		GranterID: bson.ObjectIdHex(granterID),
+		GranteeID: bson.ObjectIdHex(granteeID),
+		Action: action,
+		Role: role,
+		Permissions: perms,
+		CreatedTime: time.Now(),
+	})
 }
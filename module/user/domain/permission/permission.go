@@ -0,0 +1,163 @@
+package permission
+
+import "strings"
+
+// 权限以.分隔层级，如MERCHANT.STAFF.RETRIEVE
+// 授予的权限项支持以下形式：
+//   MERCHANT.STAFF      该权限及其全部下级权限
+//   MERCHANT.*          全部下级权限，不含MERCHANT本身
+//   *                   全部权限
+//   -MERCHANT.MODIFY    拒绝，优先于任何授予，同样支持上述层级及通配
+// 权限项可以@<资源ID>结尾限定于单个资源（如赛事、商家），如COMPETITION.DRAWN_TICKET.INSPECT@<赛事ID>
+// 限定资源的权限项仅在检查该资源时生效，不限定资源的权限项对全部资源生效
+
+const (
+	// 拒绝前缀
+	DENY_PREFIX = "-"
+
+	// 通配符
+	WILDCARD = "*"
+
+	// 资源分隔符
+	RESOURCE_SEPARATOR = "@"
+)
+
+// 将权限项限定于资源，resourceID为空时原样返回
+func Scoped(entry, resourceID string) string {
+	if len(resourceID) == 0 {
+		return entry
+	}
+	return entry + RESOURCE_SEPARATOR + resourceID
+}
+
+// 将未限定资源的权限项限定于资源，已限定资源的权限项原样保留
+func ScopeAll(entries []string, resourceID string) []string {
+	scoped := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, scope := SplitScope(entry); len(scope) == 0 {
+			entry = Scoped(entry, resourceID)
+		}
+		scoped = append(scoped, entry)
+	}
+	return scoped
+}
+
+// 拆分权限项为不含资源的部分及资源ID，未限定资源时资源ID为空
+func SplitScope(entry string) (string, string) {
+	if i := strings.LastIndex(entry, RESOURCE_SEPARATOR); i >= 0 {
+		return entry[:i], entry[i + len(RESOURCE_SEPARATOR):]
+	}
+	return entry, ""
+}
+
+// 是否为拒绝项
+func IsDeny(entry string) bool {
+	return strings.HasPrefix(entry, DENY_PREFIX)
+}
+
+// 去除拒绝前缀，得到权限项的模式
+func patternOf(entry string) string {
+	return strings.TrimPrefix(entry, DENY_PREFIX)
+}
+
+// 模式是否匹配权限
+func Matches(pattern, perm string) bool {
+	if pattern == WILDCARD {
+		return true
+	}
+	if strings.HasSuffix(pattern, "." + WILDCARD) {
+		return strings.HasPrefix(perm, pattern[:len(pattern) - len(WILDCARD)])
+	}
+	return perm == pattern || strings.HasPrefix(perm, pattern + ".")
+}
+
+// 模式p匹配的权限是否包含模式q匹配的全部权限
+func covers(p, q string) bool {
+	if p == WILDCARD {
+		return true
+	}
+	if q == WILDCARD {
+		return false
+	}
+	if p == q {
+		return true
+	}
+	// q为通配时以其层级判断，X.*的全部下级权限即X的全部下级权限
+	qBase := strings.TrimSuffix(q, "." + WILDCARD)
+	if strings.HasSuffix(p, "." + WILDCARD) {
+		return strings.HasPrefix(qBase, p[:len(p) - len(WILDCARD)])
+	}
+	return Matches(p, qBase)
+}
+
+// 两个模式匹配的权限是否有交集，层级模式之间要么包含要么不相交
+func overlaps(p, q string) bool {
+	return covers(p, q) || covers(q, p)
+}
+
+// 权限项集是否允许该权限，即存在匹配的授予项且不存在匹配的拒绝项，仅不限定资源的权限项生效
+func IsAllowed(entries []string, perm string) bool {
+	return IsAllowedOn(entries, perm, "")
+}
+
+// 权限项集是否允许对资源的该权限，不限定资源及限定于该资源的权限项生效
+func IsAllowedOn(entries []string, perm, resourceID string) bool {
+	allowed := false
+	for _, entry := range entries {
+		entry, scope := SplitScope(entry)
+		if len(scope) != 0 && scope != resourceID {
+			continue
+		}
+		if !Matches(patternOf(entry), perm) {
+			continue
+		}
+		if IsDeny(entry) {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// 权限项集是否允许全部权限
+func IsInclude(actualPerms, expectedPerms []string) bool {
+	return IsIncludeOn(actualPerms, expectedPerms, "")
+}
+
+// 权限项集是否允许对资源的全部权限
+func IsIncludeOn(actualPerms, expectedPerms []string, resourceID string) bool {
+	for _, perm := range expectedPerms {
+		if !IsAllowedOn(actualPerms, perm, resourceID) {
+			return false
+		}
+	}
+	return true
+}
+
+// 授权人的权限项集能否授予全部权限项
+// 授予项须被授权人的某个授予项包含，且与授权人的拒绝项不相交；拒绝项按其模式同样判断，只能拒绝授权人拥有的权限
+// 限定资源的权限项可由不限定资源或限定于同一资源的授予项授予，不限定资源的权限项只能由不限定资源的授予项授予
+func CanGrant(granterPerms, entries []string) bool {
+	for _, entry := range entries {
+		entry, scope := SplitScope(entry)
+		pattern := patternOf(entry)
+		granted := false
+		for _, granterEntry := range granterPerms {
+			granterEntry, granterScope := SplitScope(granterEntry)
+			granterPattern := patternOf(granterEntry)
+			// 限定于其他资源的授予项不能授予，拒绝项只要可能作用于同一资源即阻止授予
+			sameScope := len(granterScope) == 0 || granterScope == scope
+			if IsDeny(granterEntry) {
+				if (sameScope || len(scope) == 0) && overlaps(granterPattern, pattern) {
+					return false
+				}
+			} else if sameScope && covers(granterPattern, pattern) {
+				granted = true
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
+}
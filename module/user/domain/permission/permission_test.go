@@ -0,0 +1,82 @@
+package permission
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAllowed(t *testing.T) {
+	entries := []string{"MERCHANT", "COMPETITION.*", "-MERCHANT.MODIFY", "USER.RETRIEVE"}
+
+	assert.True(t, IsAllowed(entries, "MERCHANT"))
+	assert.True(t, IsAllowed(entries, "MERCHANT.STAFF.RETRIEVE"))
+	assert.False(t, IsAllowed(entries, "MERCHANT.MODIFY"))
+	assert.False(t, IsAllowed(entries, "MERCHANTS.RETRIEVE"))
+	assert.True(t, IsAllowed(entries, "COMPETITION.DRAWN_TICKET.INSPECT"))
+	assert.False(t, IsAllowed(entries, "COMPETITION"))
+	assert.True(t, IsAllowed(entries, "USER.RETRIEVE"))
+	assert.False(t, IsAllowed(entries, "USER.MODIFY"))
+
+	assert.True(t, IsAllowed([]string{"*", "-AUDIT"}, "USER.MODIFY"))
+	assert.False(t, IsAllowed([]string{"*", "-AUDIT"}, "AUDIT.RETRIEVE"))
+	assert.False(t, IsAllowed([]string{"-MERCHANT.*", "MERCHANT.STAFF.MODIFY"}, "MERCHANT.STAFF.MODIFY"))
+
+	assert.True(t, IsInclude(entries, []string{"MERCHANT.RETRIEVE", "USER.RETRIEVE"}))
+	assert.False(t, IsInclude(entries, []string{"MERCHANT.RETRIEVE", "MERCHANT.MODIFY"}))
+	assert.True(t, IsInclude(entries, []string{}))
+}
+
+func TestCanGrant(t *testing.T) {
+	granter := []string{"MERCHANT", "-MERCHANT.MODIFY", "COMPETITION.*"}
+
+	assert.True(t, CanGrant(granter, []string{"MERCHANT.STAFF", "MERCHANT.STAFF.*", "COMPETITION.RETRIEVE", "COMPETITION.*"}))
+	assert.True(t, CanGrant(granter, []string{"-MERCHANT.STAFF.MODIFY"}))
+	assert.False(t, CanGrant(granter, []string{"MERCHANT"}))
+	assert.False(t, CanGrant(granter, []string{"MERCHANT.*"}))
+	assert.False(t, CanGrant(granter, []string{"MERCHANT.MODIFY"}))
+	assert.False(t, CanGrant(granter, []string{"COMPETITION"}))
+	assert.False(t, CanGrant(granter, []string{"*"}))
+	assert.True(t, CanGrant([]string{"*"}, []string{"*", "-AUDIT"}))
+}
+
+func TestScoped(t *testing.T) {
+	entries := []string{"MERCHANT.STAFF@m1", "COMPETITION.RETRIEVE", "-COMPETITION.DRAWN_TICKET@c2", "COMPETITION.DRAWN_TICKET.INSPECT@c2"}
+
+	assert.Equal(t, "MERCHANT.STAFF@m1", Scoped("MERCHANT.STAFF", "m1"))
+	assert.Equal(t, "MERCHANT.STAFF", Scoped("MERCHANT.STAFF", ""))
+	assert.Equal(t, []string{"MERCHANT@m1", "USER@m2"}, ScopeAll([]string{"MERCHANT", "USER@m2"}, "m1"))
+
+	assert.True(t, IsAllowedOn(entries, "MERCHANT.STAFF.MODIFY", "m1"))
+	assert.False(t, IsAllowedOn(entries, "MERCHANT.STAFF.MODIFY", "m2"))
+	assert.False(t, IsAllowed(entries, "MERCHANT.STAFF.MODIFY"))
+	assert.True(t, IsAllowedOn(entries, "COMPETITION.RETRIEVE", "c1"))
+	assert.False(t, IsAllowedOn(entries, "COMPETITION.DRAWN_TICKET.INSPECT", "c2"))
+
+	assert.True(t, CanGrant([]string{"MERCHANT.STAFF@m1"}, []string{"MERCHANT.STAFF.RETRIEVE@m1"}))
+	assert.False(t, CanGrant([]string{"MERCHANT.STAFF@m1"}, []string{"MERCHANT.STAFF.RETRIEVE@m2"}))
+	assert.False(t, CanGrant([]string{"MERCHANT.STAFF@m1"}, []string{"MERCHANT.STAFF.RETRIEVE"}))
+	assert.True(t, CanGrant([]string{"MERCHANT"}, []string{"MERCHANT.STAFF@m1"}))
+	assert.False(t, CanGrant([]string{"MERCHANT", "-MERCHANT.STAFF@m1"}, []string{"MERCHANT.STAFF.MODIFY@m1"}))
+	assert.True(t, CanGrant([]string{"MERCHANT", "-MERCHANT.STAFF@m1"}, []string{"MERCHANT.STAFF.MODIFY@m2"}))
+}
+
+func TestRegistry(t *testing.T) {
+	Register("TEST_REGISTRY.ITEM.RETRIEVE", "TEST_REGISTRY.ITEM.MODIFY")
+
+	assert.True(t, IsKnown("TEST_REGISTRY.ITEM.RETRIEVE"))
+	assert.True(t, IsKnown("TEST_REGISTRY.ITEM"))
+	assert.True(t, IsKnown("TEST_REGISTRY.*"))
+	assert.True(t, IsKnown("-TEST_REGISTRY.ITEM.MODIFY"))
+	assert.True(t, IsKnown("*"))
+	assert.False(t, IsKnown("TEST_REGISTRY.ITEM.DELETE"))
+	assert.False(t, IsKnown("TEST_REGISTRY.*.RETRIEVE"))
+	assert.False(t, IsKnown("test_registry.item"))
+	assert.True(t, IsKnown("TEST_REGISTRY.ITEM@5a1b2c3d"))
+	assert.False(t, IsKnown("TEST_REGISTRY.ITEM@"))
+	assert.False(t, IsKnown("TEST_REGISTRY.ITEM@a b"))
+	assert.Equal(t, []string{"TEST_REGISTRY.OTHER"}, Unknown([]string{"TEST_REGISTRY.ITEM", "TEST_REGISTRY.OTHER"}))
+	assert.Panics(t, func() {
+		Register("TEST_REGISTRY.*")
+	})
+}
@@ -16,8 +16,13 @@ func Permissions(perms []string) []string {
 	return set.StringSlice(PermissionSet(perms))
 }
 
-// 是否包含权限集
-func IsInclude(actualPerms, expectedPerms []string) bool {
-	expectedPermSet := PermissionSet(expectedPerms)
-	return set.Intersection(PermissionSet(actualPerms), expectedPermSet).Size() == expectedPermSet.Size()
+// 合并权限集
+func Union(permSets ...[]string) []string {
+	s := set.NewNonTS()
+	for _, perms := range permSets {
+		for _, perm := range perms {
+			s.Add(perm)
+		}
+	}
+	return set.StringSlice(s)
 }
@@ -0,0 +1,83 @@
+package permission
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 权限名，大写字母、数字及下划线，以.分隔层级
+var permissionRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9_]*(\.[A-Z][A-Z0-9_]*)*$`)
+
+// 权限项的模式，可以.*结尾或为*
+var patternRegexp = regexp.MustCompile(`^(\*|[A-Z][A-Z0-9_]*(\.[A-Z][A-Z0-9_]*)*(\.\*)?)$`)
+
+// 资源ID
+var resourceIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// 已注册的权限集
+var registered map[string]bool = map[string]bool{}
+
+// 已注册的权限集互斥量
+var registeredMutex *sync.RWMutex = &sync.RWMutex{}
+
+// 注册权限
+// 过程要求的权限由EnsureRequiredPermissionsProcessHandler在创建时注册，其他用于判断的权限须显式注册
+func Register(perms ...string) {
+	registeredMutex.Lock()
+	defer registeredMutex.Unlock()
+	for _, perm := range perms {
+		if !permissionRegexp.MatchString(perm) {
+			panic("无效的权限名：" + perm)
+		}
+		registered[perm] = true
+	}
+}
+
+// 获取已注册的权限，按名称排序
+func Registered() []string {
+	registeredMutex.RLock()
+	defer registeredMutex.RUnlock()
+	perms := make([]string, 0, len(registered))
+	for perm := range registered {
+		perms = append(perms, perm)
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+// 权限项是否已知，即格式正确且匹配至少一个已注册的权限
+func IsKnown(entry string) bool {
+	if strings.Contains(entry, RESOURCE_SEPARATOR) {
+		var scope string
+		entry, scope = SplitScope(entry)
+		if !resourceIDRegexp.MatchString(scope) {
+			return false
+		}
+	}
+	pattern := patternOf(entry)
+	if !patternRegexp.MatchString(pattern) {
+		return false
+	}
+
+	registeredMutex.RLock()
+	defer registeredMutex.RUnlock()
+	for perm := range registered {
+		if Matches(pattern, perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取未知的权限项
+func Unknown(entries []string) []string {
+	unknown := []string{}
+	for _, entry := range entries {
+		if !IsKnown(entry) {
+			unknown = append(unknown, entry)
+		}
+	}
+	return unknown
+}
@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// 权限变更动作
+const (
+	// 授予
+	PERMISSION_GRANT_ACTION_GRANT = "GRANT"
+
+	// 撤销
+	PERMISSION_GRANT_ACTION_REVOKE = "REVOKE"
+
+	// 分配角色
+	PERMISSION_GRANT_ACTION_ASSIGN_ROLE = "ASSIGN_ROLE"
+
+	// 撤销角色
+	PERMISSION_GRANT_ACTION_UNASSIGN_ROLE = "UNASSIGN_ROLE"
+)
+
+// 权限变更记录
+type PermissionGrant struct {
+	// ID
+	ID          bson.ObjectId `bson:"_id,omitempty" json:"id"`
+
+	// 授权（撤销）人用户ID
+	GranterID   bson.ObjectId `bson:"granterId" json:"granter_id"`
+
+	// 被授权用户ID
+	GranteeID   bson.ObjectId `bson:"granteeId" json:"grantee_id"`
+
+	// 动作
+	Action      string `bson:"action" json:"action"`
+
+	// 分配或撤销的角色名，限定资源的角色带@<资源ID>，仅角色变更时记录
+	Role        string `bson:"role,omitempty" json:"role,omitempty"`
+
+	// 授予或撤销的权限项，限定资源的权限项带@<资源ID>；角色变更时为变更时角色的权限项
+	Permissions []string `bson:"permissions" json:"permissions"`
+
+	// 创建时间
+	CreatedTime time.Time `bson:"createdTime" json:"created_time"`
+}
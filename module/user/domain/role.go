@@ -0,0 +1,37 @@
+package domain
+
+import "gopkg.in/mgo.v2/bson"
+
+// 内置角色名
+const (
+	// 主办方管理员
+	ROLE_SPONSOR_ADMIN = "SPONSOR_ADMIN"
+
+	// 商家管理员
+	ROLE_MERCHANT_MANAGER = "MERCHANT_MANAGER"
+
+	// 商家员工
+	ROLE_MERCHANT_STAFF = "MERCHANT_STAFF"
+
+	// 检票员
+	ROLE_INSPECTOR = "INSPECTOR"
+)
+
+// 角色
+// 用户持有角色名，其有效权限为自身的权限集与各角色的权限集之并
+type Role struct {
+	// ID
+	ID          bson.ObjectId `bson:"_id,omitempty" json:"id"`
+
+	// 角色名，唯一，形如SPONSOR_ADMIN
+	Name        string `bson:"name" json:"name"`
+
+	// 显示名
+	Title       string `bson:"title" json:"title"`
+
+	// 权限集
+	Permissions []string `bson:"permissions" json:"permissions"`
+
+	// 是否内置，内置角色由安装脚本创建，不能删除
+	Builtin     bool `bson:"builtin" json:"builtin"`
+}
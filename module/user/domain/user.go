@@ -10,8 +10,8 @@ type User struct {
 	// 用户名
 	Name            string `bson:"name" json:"name"`
 
-	// 密码摘要
-	PasswordDigest  string `bson:"passwordDigest" json:"password_digest"`
+	// 密码散列，不输出到JSON
+	PasswordDigest  string `bson:"passwordDigest" json:"-"`
 
 	// 昵称
 	Nickname        string `bson:"nickname" json:"nickname"`
@@ -19,6 +19,9 @@ type User struct {
 	// 手机
 	Mobile          string `bson:"mobile" json:"mobile"`
 
-	// 权限集
+	// 直接授予的权限集，不含角色的权限
 	FlatPermissions []string `bson:"flatPermissions" json:"flat_permissions"`
+
+	// 角色名集
+	Roles           []string `bson:"roles" json:"roles"`
 }
@@ -12,21 +12,68 @@ db.WechatUserBindings.ensureIndex({
     unique: true
 });
 
+db.createCollection('Roles');
+db.Roles.ensureIndex({
+    name: 1
+}, {
+    unique: true
+});
+db.Users.ensureIndex({
+    roles: 1
+});
+
+// 内置角色
+db.Roles.insert({
+    name: 'SPONSOR_ADMIN',
+    title: '主办方管理员',
+    permissions: [
+        'MERCHANT',
+        'COMPETITION',
+        'USER'
+    ],
+    builtin: true
+});
+db.Roles.insert({
+    name: 'MERCHANT_MANAGER',
+    title: '商家管理员',
+    permissions: [
+        'MERCHANT.STAFF'
+    ],
+    builtin: true
+});
+db.Roles.insert({
+    name: 'MERCHANT_STAFF',
+    title: '商家员工',
+    permissions: [],
+    builtin: true
+});
+db.Roles.insert({
+    name: 'INSPECTOR',
+    title: '检票员',
+    permissions: [
+        'COMPETITION.DRAWN_TICKET.INSPECT'
+    ],
+    builtin: true
+});
+
+// 初始密码为admin，argon2id散列，部署后应立即修改
 db.Users.insert({
     name: 'admin',
-    passwordDigest: '21232f297a57a5a743894a0e4a801fc3',
+    passwordDigest: '$argon2id$v=19$m=65536,t=3,p=2$dVZbQQtMHBMHmAeEaoCSJQ$JJBIdjvwYoNMDuYlsFSYES2xBqHaBMu4SoZRH3eqxNE',
     nickname: '主办方管理员',
     mobile: '',
-    flatPermissions: [
-        'MERCHANT.STAFF.RETRIEVE',
-        'MERCHANT.STAFF.MODIFY',
-        'MERCHANT.RETRIEVE',
-        'MERCHANT.MODIFY',
-        'COMPETITION.DRAWN_TICKET.INSPECT',
-        'COMPETITION.RETRIEVE',
-        'COMPETITION.MODIFY',
-        'USER.RETRIEVE',
-        'USER.MODIFY',
-        'USER.SPONSOR_MANAGER'
+    flatPermissions: [],
+    roles: [
+        'SPONSOR_ADMIN'
     ]
 });
+
+db.createCollection('PermissionGrants');
+db.PermissionGrants.ensureIndex({
+    granteeId: 1,
+    _id: -1
+});
+db.PermissionGrants.ensureIndex({
+    granterId: 1,
+    _id: -1
+});
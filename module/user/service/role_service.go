@@ -0,0 +1,182 @@
+package service
+
+import (
+	"errors"
+
+	"wawa_b.v1/module/rest_json_rpc"
+	"wawa_b.v1/module/rest_json_rpc/failure"
+	"wawa_b.v1/module/user/business"
+	"wawa_b.v1/module/user/domain/permission"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type AssignRoleParam struct {
+	// 用户ID
+	UserID     string `json:"user_id" validate:"required,objectid"`
+
+	// 角色名
+	RoleName   string `json:"role_name" validate:"required,maxlen=32"`
+
+	// 资源ID，不为空时角色的权限项限定于该资源
+	ResourceID string `json:"resource_id" validate:"maxlen=64,regex=^[A-Za-z0-9_-]*$"`
+}
+
+// 为用户分配角色
+// 当前用户须能授予角色的全部权限项，避免借助角色提升权限
+// + 确保登录
+func AssignRoleProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*AssignRoleParam)
+
+		userID := new(bson.ObjectId)
+		if !ctx.Session().Get(SESS_KEY_CURRENT_USER_ID, userID) {
+			return nil, errors.New("请确保用户已登录")
+		}
+		return nil, userMgr.AssignRole(userID.Hex(), param.UserID, permission.Scoped(param.RoleName, param.ResourceID))
+	}
+}
+
+type CreateRoleParam struct {
+	// 角色名
+	Name        string `json:"name" validate:"required,maxlen=32,regex=^[A-Z][A-Z0-9_]*$"`
+
+	// 显示名
+	Title       string `json:"title" validate:"required,maxlen=32"`
+
+	// 权限集
+	Permissions []string `json:"permissions"`
+}
+
+// 创建一个角色
+// 当前用户须拥有角色的全部权限
+// + 确保登录
+func CreateRoleProcessHandler(userMgr business.UserManager, roleMgr business.RoleManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*CreateRoleParam)
+		if err := ensureCurrentUserHasPermissions(ctx, userMgr, param.Permissions); err != nil {
+			return nil, err
+		}
+		return nil, roleMgr.Create(param.Name, param.Title, param.Permissions)
+	}
+}
+
+type DeleteRoleParam struct {
+	// 角色名
+	Name string `json:"name" validate:"required,maxlen=32"`
+}
+
+// 删除一个角色
+// 当前用户须拥有角色的全部权限
+// + 确保登录
+func DeleteRoleProcessHandler(userMgr business.UserManager, roleMgr business.RoleManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*DeleteRoleParam)
+
+		role, err := roleMgr.Get(param.Name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return nil, nil
+		}
+		if err := ensureCurrentUserHasPermissions(ctx, userMgr, role.Permissions); err != nil {
+			return nil, err
+		}
+		return nil, roleMgr.Delete(param.Name)
+	}
+}
+
+type GetCurrentUserPermissionsParam struct{}
+
+// 获取当前用户的有效权限集
+// + 确保登录
+func GetCurrentUserPermissionsProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, _ interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		return currentUserEffectivePermissions(ctx, userMgr)
+	}
+}
+
+type GetRoleParam struct {
+	// 角色名
+	Name string `json:"name" validate:"required,maxlen=32"`
+}
+
+// 获取一个角色
+func GetRoleProcessHandler(roleMgr business.RoleManager) rest_json_rpc.ProcessHandler {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*GetRoleParam)
+		return roleMgr.Get(param.Name)
+	}
+}
+
+type ListRolesParam struct{}
+
+// 获取全部角色
+func ListRolesProcessHandler(roleMgr business.RoleManager) rest_json_rpc.ProcessHandler {
+	return func(_ rest_json_rpc.Context, _ interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		return roleMgr.List()
+	}
+}
+
+type UnassignRoleParam struct {
+	// 用户ID
+	UserID     string `json:"user_id" validate:"required,objectid"`
+
+	// 角色名
+	RoleName   string `json:"role_name" validate:"required,maxlen=32"`
+
+	// 资源ID，撤销限定于该资源的角色
+	ResourceID string `json:"resource_id" validate:"maxlen=64,regex=^[A-Za-z0-9_-]*$"`
+}
+
+// 撤销用户的角色
+// 当前用户须能授予角色的全部权限项，只能撤销自己能分配的角色
+// + 确保登录
+func UnassignRoleProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*UnassignRoleParam)
+
+		userID := new(bson.ObjectId)
+		if !ctx.Session().Get(SESS_KEY_CURRENT_USER_ID, userID) {
+			return nil, errors.New("请确保用户已登录")
+		}
+		return nil, userMgr.UnassignRole(userID.Hex(), param.UserID, permission.Scoped(param.RoleName, param.ResourceID))
+	}
+}
+
+type UpdateRoleParam struct {
+	// 角色名
+	Name        string `json:"name" validate:"required,maxlen=32"`
+
+	// 显示名
+	Title       string `json:"title" validate:"required,maxlen=32"`
+
+	// 权限集
+	Permissions []string `json:"permissions"`
+}
+
+// 更新一个角色，内置角色不能更新
+// 当前用户须拥有更新后的全部权限
+// + 确保登录
+func UpdateRoleProcessHandler(userMgr business.UserManager, roleMgr business.RoleManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*UpdateRoleParam)
+		if err := ensureCurrentUserHasPermissions(ctx, userMgr, param.Permissions); err != nil {
+			return nil, err
+		}
+		return nil, roleMgr.Update(param.Name, param.Title, param.Permissions)
+	}
+}
+
+// 确保当前用户能授予全部权限项，否则返回授权人没有相应的权限的失败
+func ensureCurrentUserHasPermissions(ctx rest_json_rpc.Context, userMgr business.UserManager, perms []string) error {
+	userPerms, err := currentUserEffectivePermissions(ctx, userMgr)
+	if err != nil {
+		return err
+	}
+	if !permission.CanGrant(userPerms, perms) {
+		return failure.New(business.FAIL_CD_GRANTER_NO_THESE_PERMISSIONS)
+	}
+	return nil
+}
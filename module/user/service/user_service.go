@@ -3,12 +3,12 @@ package service
 import (
 	"errors"
 
-	"wawa_b.v1/module/md5"
 	"wawa_b.v1/module/mobile_captcha"
 	"wawa_b.v1/module/rest_json_rpc"
 	"wawa_b.v1/module/rest_json_rpc/failure"
 	"wawa_b.v1/module/session"
 	"wawa_b.v1/module/user/business"
+	"wawa_b.v1/module/user/domain"
 	"wawa_b.v1/module/user/domain/permission"
 	"wawa_b.v1/module/wechat_client"
 
@@ -60,77 +60,144 @@ const (
 	// 用户未登录
 	FAIL_CD_USER_NOT_LOGGED_IN = "USER.USER_NOT_LOGGED_IN"
 
+	// 刷新令牌无效、已过期或已轮换
+	FAIL_CD_INVALID_REFRESH_TOKEN = "USER.INVALID_REFRESH_TOKEN"
+
+	// 当前会话存储器不支持令牌
+	FAIL_CD_TOKEN_UNSUPPORTED = "USER.TOKEN_UNSUPPORTED"
+
 	// 微信授权重定向
 	FAIL_CD_WECHAT_REDIRECT = "USER.WECHAT_AUTH_REDIRECT"
 )
 
 type DeleteParam struct {
 	// ID
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required,objectid"`
 }
 
-// 删除一个用户
-func DeleteProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+// 删除一个用户，并销毁其所有会话
+func DeleteProcessHandler(userMgr business.UserManager, sessMgr *session.SessionManager) rest_json_rpc.ProcessHandler {
+	permission.Register("USER.SPONSOR_MANAGER")
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*DeleteParam)
 
-		user := userMgr.Get(param.ID)
-		if user != nil && permission.IsInclude(user.FlatPermissions, []string{"USER.SPONSOR_MANAGER"}) {
-			panic(failure.New(FAIL_CD_CANNOT_DELETE_SPONSOR_MANAGER))
+		user, err := userMgr.Get(param.ID)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			perms, err := userMgr.EffectivePermissions(user)
+			if err != nil {
+				return nil, err
+			}
+			if permission.IsInclude(perms, []string{"USER.SPONSOR_MANAGER"}) {
+				return nil, failure.New(FAIL_CD_CANNOT_DELETE_SPONSOR_MANAGER)
+			}
 		}
 
-		userMgr.Delete(param.ID)
-		return nil
+		if err := userMgr.Delete(param.ID); err != nil {
+			return nil, err
+		}
+
+		sessMgr.DestroyUserSessions(param.ID, "")
+		return nil, nil
 	}
 }
 
 // 确保登录
 func EnsureLoggedInProcessHandler() rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, _ interface{}, ch *rest_json_rpc.ProcessChain) interface{} {
-		sess := session.GetSessionByContext(ctx)
+	return func(ctx rest_json_rpc.Context, _ interface{}, ch *rest_json_rpc.ProcessChain) (interface{}, error) {
+		sess := ctx.Session()
 		userID := new(bson.ObjectId)
 		if !sess.Get(SESS_KEY_CURRENT_USER_ID, userID) {
-			panic(failure.New(FAIL_CD_USER_NOT_LOGGED_IN))
+			return nil, failure.New(FAIL_CD_USER_NOT_LOGGED_IN)
 		}
 		return ch.Next()
 	}
 }
 
 // 确保用户有权限操作资源
+// 要求的权限在创建时注册，可被授予
 // + 确保登录
 func EnsureRequiredPermissionsProcessHandler(userMgr business.UserManager, perms []string) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, ch *rest_json_rpc.ProcessChain) interface{} {
-		sess := session.GetSessionByContext(ctx)
-		userID := new(bson.ObjectId)
-		if !sess.Get(SESS_KEY_CURRENT_USER_ID, userID) {
-			panic(errors.New("请确保用户已登录"))
+	permission.Register(perms...)
+	return func(ctx rest_json_rpc.Context, p interface{}, ch *rest_json_rpc.ProcessChain) (interface{}, error) {
+		userPerms, err := currentUserEffectivePermissions(ctx, userMgr)
+		if err != nil {
+			return nil, err
+		}
+		if !permission.IsInclude(userPerms, perms) {
+			return nil, failure.New(FAIL_CD_PERMISSION_DENIED)
 		}
 
-		user := userMgr.Get(userID.Hex())
-		if !permission.IsInclude(user.FlatPermissions, perms) {
-			panic(failure.New(FAIL_CD_PERMISSION_DENIED))
+		return ch.Next()
+	}
+}
+
+// 从过程参数中获取资源ID
+type ResourceIDOf func(ctx rest_json_rpc.Context, param interface{}) (string, error)
+
+// 确保用户有权限操作指定的资源
+// 不限定资源及限定于该资源（形如PERM@<资源ID>）的权限项生效
+// 要求的权限在创建时注册，可被授予
+// + 确保登录
+func EnsureRequiredResourcePermissionsProcessHandler(userMgr business.UserManager, perms []string, resourceIDOf ResourceIDOf) rest_json_rpc.ProcessHandler {
+	permission.Register(perms...)
+	return func(ctx rest_json_rpc.Context, p interface{}, ch *rest_json_rpc.ProcessChain) (interface{}, error) {
+		userPerms, err := currentUserEffectivePermissions(ctx, userMgr)
+		if err != nil {
+			return nil, err
+		}
+		resourceID, err := resourceIDOf(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		// 未能确定资源时仅不限定资源的权限项生效
+		if !permission.IsIncludeOn(userPerms, perms, resourceID) {
+			return nil, failure.New(FAIL_CD_PERMISSION_DENIED)
 		}
 
 		return ch.Next()
 	}
 }
 
+// 获取当前用户的有效权限集，用户不存在时为空
+func currentUserEffectivePermissions(ctx rest_json_rpc.Context, userMgr business.UserManager) ([]string, error) {
+	sess := ctx.Session()
+	userID := new(bson.ObjectId)
+	if !sess.Get(SESS_KEY_CURRENT_USER_ID, userID) {
+		return nil, errors.New("请确保用户已登录")
+	}
+
+	user, err := userMgr.Get(userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return []string{}, nil
+	}
+	return userMgr.EffectivePermissions(user)
+}
+
 // 资源是否属于该用户
-type ResourceBelongToUser func(ctx echo.Context, param interface{}, userID string) bool
+type ResourceBelongToUser func(ctx rest_json_rpc.Context, param interface{}, userID string) bool
 
 // 确保操作属于当前用户的资源
 // + 确保登录
 func EnsureResourceBelongToCurrentUserProcessHandler(userMgr business.UserManager, rbtu ResourceBelongToUser) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, ch *rest_json_rpc.ProcessChain) interface{} {
-		sess := session.GetSessionByContext(ctx)
+	return func(ctx rest_json_rpc.Context, p interface{}, ch *rest_json_rpc.ProcessChain) (interface{}, error) {
+		sess := ctx.Session()
 		userID := new(bson.ObjectId)
 		if !sess.Get(SESS_KEY_CURRENT_USER_ID, userID) {
-			panic(errors.New("请确保用户已登录"))
+			return nil, errors.New("请确保用户已登录")
 		}
 
-		user := userMgr.Get(userID.Hex())
-		if !rbtu(ctx, p, user.ID.Hex()) {
-			panic(failure.New(FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER))
+		user, err := userMgr.Get(userID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || !rbtu(ctx, p, user.ID.Hex()) {
+			return nil, failure.New(FAIL_CD_RESOURCE_NOT_BELONG_TO_CURRENT_USER)
 		}
 
 		return ch.Next()
@@ -144,18 +211,19 @@ type WechatAuthRedirectFailDetail struct {
 
 // 确保微信授权
 func EnsureWechatAuthorizedProcessHandler(wcCli *wechat_client.WechatClient, redirectURI, scope, state string) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, _ interface{}, ch *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, _ interface{}, ch *rest_json_rpc.ProcessChain) (interface{}, error) {
+		state := state
 		if len(state) == 0 {
-			state = ctx.Request().Referer()
+			state = ctx.Header("Referer")
 		}
 
-		sess := session.GetSessionByContext(ctx)
+		sess := ctx.Session()
 
 		accToken := &wechat_client.WechatAccessToken{}
 		if !sess.Get(SESS_KEY_CURRENT_USER_WECHAT_ACCESS_TOKEN, accToken) {
-			panic(failure.NewWithDetail(FAIL_CD_WECHAT_REDIRECT, &WechatAuthRedirectFailDetail{
+			return nil, failure.NewWithDetail(FAIL_CD_WECHAT_REDIRECT, &WechatAuthRedirectFailDetail{
 				Location: wcCli.GetAuthorizeURL(redirectURI, scope, state),
-			}))
+			})
 		}
 
 		return ch.Next()
@@ -164,12 +232,12 @@ func EnsureWechatAuthorizedProcessHandler(wcCli *wechat_client.WechatClient, red
 
 type GetParam struct {
 	// ID
-	ID string `json:"id"`
+	ID string `json:"id" validate:"required,objectid"`
 }
 
 // 获取一个用户
 func GetProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*GetParam)
 		return userMgr.Get(param.ID)
 	}
@@ -180,11 +248,11 @@ type GetCurrentUserParam struct{}
 // 获取当前用户
 // + 确保登录
 func GetCurrentUserProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
-		sess := session.GetSessionByContext(ctx)
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		sess := ctx.Session()
 		userID := new(bson.ObjectId)
 		if !sess.Get(SESS_KEY_CURRENT_USER_ID, userID) {
-			panic(errors.New("请确保用户已登录"))
+			return nil, errors.New("请确保用户已登录")
 		}
 		return userMgr.Get(userID.Hex())
 	}
@@ -192,69 +260,210 @@ func GetCurrentUserProcessHandler(userMgr business.UserManager) rest_json_rpc.Pr
 
 type GrantFlatPermissionsParam struct {
 	// 授权用户ID
-	GranterID       string `json:"granter_id"`
+	GranterID       string `json:"granter_id" validate:"required,objectid"`
 
 	// 被授权用户ID
-	GranteeID       string `json:"grantee_id"`
+	GranteeID       string `json:"grantee_id" validate:"required,objectid"`
 
 	// 权限集
 	FlatPermissions []string `json:"flat_permissions"`
 }
 
-// 授予用户权限
+// 替换用户直接授予的权限集，已弃用，由授予及撤销权限替代
+// 以授予及撤销实现：授予新增的权限项，撤销不在权限集中的不限定资源的权限项，限定资源的权限项保留
 func GrantFlatPermissionsProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*GrantFlatPermissionsParam)
 
-		if err := userMgr.GrantFlatPermissions(param.GranterID, param.GranteeID, param.FlatPermissions); err != nil {
-			panic(err)
+		grantee, err := userMgr.Get(param.GranteeID)
+		if err != nil {
+			return nil, err
+		}
+		if grantee == nil {
+			return nil, failure.New(FAIL_CD_NO_SUCH_USER)
 		}
 
-		return nil
+		perms := permission.Permissions(param.FlatPermissions)
+		wanted := permission.PermissionSet(perms)
+		held := permission.PermissionSet(grantee.FlatPermissions)
+		granted := make([]string, 0)
+		for _, perm := range perms {
+			if !held.Has(perm) {
+				granted = append(granted, perm)
+			}
+		}
+		revoked := make([]string, 0)
+		for _, perm := range grantee.FlatPermissions {
+			if _, scope := permission.SplitScope(perm); len(scope) == 0 && !wanted.Has(perm) {
+				revoked = append(revoked, perm)
+			}
+		}
+
+		if len(granted) != 0 {
+			if err := userMgr.GrantPermissions(param.GranterID, param.GranteeID, granted); err != nil {
+				return nil, err
+			}
+		}
+		if len(revoked) != 0 {
+			if err := userMgr.RevokePermissions(param.GranterID, param.GranteeID, revoked); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+}
+
+type GrantPermissionsParam struct {
+	// 授权用户ID
+	GranterID   string `json:"granter_id" validate:"required,objectid"`
+
+	// 被授权用户ID
+	GranteeID   string `json:"grantee_id" validate:"required,objectid"`
+
+	// 权限集
+	Permissions []string `json:"permissions"`
+}
+
+// 授予用户权限，保留用户已有的权限
+func GrantPermissionsProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*GrantPermissionsParam)
+		return nil, userMgr.GrantPermissions(param.GranterID, param.GranteeID, param.Permissions)
+	}
+}
+
+type GrantResourcePermissionsParam struct {
+	// 授权用户ID
+	GranterID   string `json:"granter_id" validate:"required,objectid"`
+
+	// 被授权用户ID
+	GranteeID   string `json:"grantee_id" validate:"required,objectid"`
+
+	// 资源ID，如赛事ID、商家ID
+	ResourceID  string `json:"resource_id" validate:"required,maxlen=64,regex=^[A-Za-z0-9_-]+$"`
+
+	// 权限集，不带@<资源ID>
+	Permissions []string `json:"permissions"`
+}
+
+// 授予用户限定于资源的权限，保留用户已有的权限
+func GrantResourcePermissionsProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*GrantResourcePermissionsParam)
+		return nil, userMgr.GrantResourcePermissions(param.GranterID, param.GranteeID, param.ResourceID, param.Permissions)
 	}
 }
 
 type LoginParam struct {
 	// 名称
-	Name     string `json:"name"`
+	Name       string `json:"name" validate:"required"`
+
+	// 密码，不超过72字节
+	Password   string `json:"password" validate:"required,maxbytes=72"`
+
+	// 是否签发令牌，用于小程序等不支持Cookie的客户端
+	IssueToken bool `json:"issue_token"`
+}
+
+// 签发令牌的登录结果
+type TokenLoginResult struct {
+	// 用户
+	User  *domain.User `json:"user"`
 
-	// 密码
-	Password string `json:"password"`
+	// 令牌
+	Token *session.Token `json:"token"`
 }
 
 // 登录
-func LoginProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+// 登录成功后轮换会话ID并将会话加入用户会话索引
+// 要求签发令牌或会话ID不可轮换（如以访问令牌或经WebSocket调用）时不修改当前会话，另建访问令牌会话并返回*TokenLoginResult
+func LoginProcessHandler(userMgr business.UserManager, sessMgr *session.SessionManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*LoginParam)
-		user := userMgr.GetByName(param.Name)
+		user, err := userMgr.GetByName(param.Name)
+		if err != nil {
+			return nil, err
+		}
 		if user == nil {
-			panic(failure.New(FAIL_CD_NO_SUCH_USER))
+			return nil, failure.New(FAIL_CD_NO_SUCH_USER)
 		}
 
-		if md5.StringDigest([]byte(param.Password)) != user.PasswordDigest {
-			panic(failure.New(FAIL_CD_INCORRECT_PASSWORD))
+		ok, err := userMgr.VerifyPassword(user, param.Password)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, failure.New(FAIL_CD_INCORRECT_PASSWORD)
 		}
 
-		sess := session.GetSessionByContext(ctx)
-		sess.Set(SESS_KEY_CURRENT_USER_ID, user.ID)
+		sess := ctx.Session()
+		if !param.IssueToken && sess.Regenerate() {
+			sess.Set(SESS_KEY_CURRENT_USER_ID, user.ID)
+			sess.BindUser(user.ID.Hex())
 
-		accToken := &wechat_client.WechatAccessToken{}
-		if sess.Get(SESS_KEY_CURRENT_USER_WECHAT_ACCESS_TOKEN, accToken) {
-			userMgr.BindWechatOpenID(user.ID.Hex(), accToken.OpenID)
+			accToken := &wechat_client.WechatAccessToken{}
+			if sess.Get(SESS_KEY_CURRENT_USER_WECHAT_ACCESS_TOKEN, accToken) {
+				if err := userMgr.BindWechatOpenID(user.ID.Hex(), accToken.OpenID); err != nil {
+					return nil, err
+				}
+			}
+
+			return user, nil
+		}
+
+		token := sessMgr.IssueToken(func(tokSess *session.Session) {
+			tokSess.Set(SESS_KEY_CURRENT_USER_ID, user.ID)
+			tokSess.BindUser(user.ID.Hex())
+		})
+		if token == nil {
+			return nil, failure.New(FAIL_CD_TOKEN_UNSUPPORTED)
 		}
 
-		return user
+		return &TokenLoginResult{
+			User: user,
+			Token: token,
+		}, nil
+	}
+}
+
+// 按参数中的用户名取限流键，用于登录等调用时尚未登录的过程
+// 登录按用户名与远端IP取键，避免他人以任意IP耗尽某用户的令牌使其无法登录，跨IP的尝试由IP维度限流
+func UserNameRateLimitKey(ctx rest_json_rpc.Context, p interface{}) (string, bool) {
+	switch param := p.(type) {
+	case *LoginParam:
+		return param.Name + "@" + ctx.RemoteIP(), true
+	case *SendMobileCaptchaForRetakePasswordParam:
+		return param.Name, true
 	}
+	return "", false
 }
 
 type LogoutParam struct{}
 
 // 注销
+// 销毁当前会话
 func LogoutProcessHandler() rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
-		sess := session.GetSessionByContext(ctx)
-		sess.Remove(SESS_KEY_CURRENT_USER_ID)
-		return nil
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		ctx.Session().Destroy()
+		return nil, nil
+	}
+}
+
+type LogoutEverywhereParam struct{}
+
+// 在所有设备上注销
+// + 确保登录
+func LogoutEverywhereProcessHandler(sessMgr *session.SessionManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		sess := ctx.Session()
+		userID := new(bson.ObjectId)
+		if !sess.Get(SESS_KEY_CURRENT_USER_ID, userID) {
+			return nil, errors.New("请确保已登录")
+		}
+
+		sessMgr.DestroyUserSessions(userID.Hex(), "")
+		sess.Destroy()
+		return nil, nil
 	}
 }
 
@@ -263,85 +472,170 @@ type LogoutForWechatParam struct{}
 // 微信注销
 // + 确保微信授权
 func LogoutForWechatProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
-		sess := session.GetSessionByContext(ctx)
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		sess := ctx.Session()
 
 		accToken := &wechat_client.WechatAccessToken{}
 		if !sess.Get(SESS_KEY_CURRENT_USER_WECHAT_ACCESS_TOKEN, accToken) {
-			panic(errors.New("请确保微信已授权"))
+			return nil, errors.New("请确保微信已授权")
+		}
+		if err := userMgr.UnbindWechatOpenID(accToken.OpenID); err != nil {
+			return nil, err
 		}
-		userMgr.UnbindWechatOpenID(accToken.OpenID)
 
-		sess.Remove(SESS_KEY_CURRENT_USER_ID)
-		return nil
+		sess.Destroy()
+		return nil, nil
+	}
+}
+
+type RefreshTokenParam struct {
+	// 刷新令牌
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// 刷新令牌
+// 旧的访问令牌与刷新令牌立即失效
+func RefreshTokenProcessHandler(sessMgr *session.SessionManager) rest_json_rpc.ProcessHandler {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*RefreshTokenParam)
+		if !sessMgr.TokenEnabled() {
+			return nil, failure.New(FAIL_CD_TOKEN_UNSUPPORTED)
+		}
+
+		token, ok := sessMgr.RefreshToken(param.RefreshToken)
+		if !ok {
+			return nil, failure.New(FAIL_CD_INVALID_REFRESH_TOKEN)
+		}
+		return token, nil
 	}
 }
 
 type RegisterParam struct {
 	// 用户类型
-	Kind              string `json:"kind"`
+	Kind              string `json:"kind" validate:"required"`
 
 	// 用户名
-	Name              string `json:"name"`
+	Name              string `json:"name" validate:"required,maxlen=32"`
 
-	// 密码
-	Password          string `json:"password"`
+	// 密码，不超过72字节
+	Password          string `json:"password" validate:"required,maxbytes=72"`
 
 	// 昵称
-	Nickname          string `json:"nickname"`
+	Nickname          string `json:"nickname" validate:"maxlen=32"`
 
 	// 手机
-	Mobile            string `json:"mobile"`
+	Mobile            string `json:"mobile" validate:"required,mobile"`
 
 	// 手机验证码
-	MobileCaptchaCode string `json:"mobile_captcha_code"`
+	MobileCaptchaCode string `json:"mobile_captcha_code" validate:"required"`
 }
 
 // 注册一个新用户
 func RegisterProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*RegisterParam)
-		if err := mobile_captcha.CheckMobileCaptcha(ctx, SESS_KEY_MOBILE_CAPTCHA_FOR_REGISTER, param.Mobile, param.MobileCaptchaCode); err != nil {
-			panic(err)
+		if err := mobile_captcha.CheckMobileCaptcha(ctx.Session(), SESS_KEY_MOBILE_CAPTCHA_FOR_REGISTER, param.Mobile, param.MobileCaptchaCode); err != nil {
+			return nil, err
 		}
-		if err := userMgr.Register(param.Kind, param.Name, param.Password, param.Nickname, param.Mobile); err != nil {
-			panic(err)
-		}
-		return nil
+		return nil, userMgr.Register(param.Kind, param.Name, param.Password, param.Nickname, param.Mobile)
 	}
 }
 
 type RetakePasswordParam struct {
 	// 手机验证码
-	MobileCaptchaCode string `json:"mobile_captcha_code"`
+	MobileCaptchaCode string `json:"mobile_captcha_code" validate:"required"`
 
-	// 新密码
-	Password          string `json:"password"`
+	// 新密码，不超过72字节
+	Password          string `json:"password" validate:"required,maxbytes=72"`
 }
 
-// 修改一个用户密码
-func RetakePasswordProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+// 找回一个用户密码，并销毁该用户当前会话以外的所有会话
+func RetakePasswordProcessHandler(userMgr business.UserManager, sessMgr *session.SessionManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*RetakePasswordParam)
 
-		sess := session.GetSessionByContext(ctx)
+		sess := ctx.Session()
 		var userID, mobi string
 		if !sess.Get(SESS_KEY_USER_ID_FOR_RETAKE_PASSWORD, &userID) || !sess.Get(SESS_KEY_MOBILE_FOR_RETAKE_PASSWORD, &mobi) {
-			panic(failure.New(mobile_captcha.FAIL_CD_INCORRECT_MOBILE_CAPTCHA))
+			return nil, failure.New(mobile_captcha.FAIL_CD_INCORRECT_MOBILE_CAPTCHA)
 		}
 
-		if err := mobile_captcha.CheckMobileCaptcha(ctx, SESS_KEY_MOBILE_CAPTCHA_FOR_RETAKE_PASSWORD, mobi, param.MobileCaptchaCode); err != nil {
-			panic(err)
+		if err := mobile_captcha.CheckMobileCaptcha(sess, SESS_KEY_MOBILE_CAPTCHA_FOR_RETAKE_PASSWORD, mobi, param.MobileCaptchaCode); err != nil {
+			return nil, err
 		}
 
-		userMgr.UpdatePassword(userID, param.Password)
-		return nil
+		if err := userMgr.UpdatePassword(userID, param.Password); err != nil {
+			return nil, err
+		}
+
+		sessMgr.DestroyUserSessions(userID, sess.ID())
+		return nil, nil
+	}
+}
+
+type PermissionHistoryParam struct {
+	// 最后一个ID
+	LastID    *string `json:"last_id" validate:"objectid"`
+
+	// 被授权用户ID
+	GranteeID string `json:"grantee_id" validate:"objectid"`
+
+	// 授权（撤销）人用户ID
+	GranterID string `json:"granter_id" validate:"objectid"`
+}
+
+// 检索权限变更记录
+func PermissionHistoryProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*PermissionHistoryParam)
+		return userMgr.PermissionHistory(param.LastID, 15, param.GranteeID, param.GranterID)
+	}
+}
+
+type RevokePermissionsParam struct {
+	// 撤销人用户ID
+	RevokerID   string `json:"revoker_id" validate:"required,objectid"`
+
+	// 被撤销用户ID
+	GranteeID   string `json:"grantee_id" validate:"required,objectid"`
+
+	// 权限集
+	Permissions []string `json:"permissions"`
+}
+
+// 撤销用户直接授予的权限，撤销人只能撤销其能授予的权限
+func RevokePermissionsProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*RevokePermissionsParam)
+		return nil, userMgr.RevokePermissions(param.RevokerID, param.GranteeID, param.Permissions)
+	}
+}
+
+type RevokeResourcePermissionsParam struct {
+	// 撤销授权的用户ID
+	RevokerID   string `json:"revoker_id" validate:"required,objectid"`
+
+	// 被撤销授权的用户ID
+	GranteeID   string `json:"grantee_id" validate:"required,objectid"`
+
+	// 资源ID
+	ResourceID  string `json:"resource_id" validate:"required,maxlen=64,regex=^[A-Za-z0-9_-]+$"`
+
+	// 权限集，不带@<资源ID>
+	Permissions []string `json:"permissions"`
+}
+
+// 撤销用户限定于资源的权限
+func RevokeResourcePermissionsProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
+		param := p.(*RevokeResourcePermissionsParam)
+		return nil, userMgr.RevokeResourcePermissions(param.RevokerID, param.GranteeID, param.ResourceID, param.Permissions)
 	}
 }
 
 type RetrieveParam struct {
 	// 最后一个ID
-	LastID   *string `json:"last_id"`
+	LastID   *string `json:"last_id" validate:"objectid"`
 
 	// 用户名
 	Name     string `json:"name"`
@@ -352,7 +646,7 @@ type RetrieveParam struct {
 
 // 检索用户
 func RetrieveProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(_ echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(_ rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*RetrieveParam)
 		return userMgr.Retrieve(param.LastID, 15, param.Name, param.Nickname)
 	}
@@ -360,81 +654,87 @@ func RetrieveProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessH
 
 type SendMobileCaptchaForRegisterParam struct {
 	// 手机
-	Mobile string `json:"mobile"`
+	Mobile string `json:"mobile" validate:"required,mobile"`
 }
 
 // 发送用户注册手机验证码
 func SendMobileCaptchaForRegisterProcessHandler(regMobiCaptMgr mobile_captcha.MobileCaptchaManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*SendMobileCaptchaForRegisterParam)
 		mobiCapt, err := regMobiCaptMgr.Send(param.Mobile)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		session.GetSessionByContext(ctx).Set(SESS_KEY_MOBILE_CAPTCHA_FOR_REGISTER, mobiCapt)
-		return nil
+		ctx.Session().Set(SESS_KEY_MOBILE_CAPTCHA_FOR_REGISTER, mobiCapt)
+		return nil, nil
 	}
 }
 
 type SendMobileCaptchaForRetakePasswordParam struct {
 	// 用户名
-	Name string `json:"name"`
+	Name string `json:"name" validate:"required"`
 }
 
 // 发送用户注册手机验证码
 func SendMobileCaptchaForRetakePasswordProcessHandler(userMgr business.UserManager, rtPwdMobiCaptMgr mobile_captcha.MobileCaptchaManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*SendMobileCaptchaForRetakePasswordParam)
 
-		user := userMgr.GetByName(param.Name)
+		user, err := userMgr.GetByName(param.Name)
+		if err != nil {
+			return nil, err
+		}
 		if user == nil {
-			return nil
+			return nil, nil
 		}
 
 		mobiCapt, err := rtPwdMobiCaptMgr.Send(user.Mobile)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
-		sess := session.GetSessionByContext(ctx)
+		sess := ctx.Session()
 		sess.Set(SESS_KEY_USER_ID_FOR_RETAKE_PASSWORD, user.ID)
 		sess.Set(SESS_KEY_MOBILE_FOR_RETAKE_PASSWORD, user.Mobile)
 		sess.Set(SESS_KEY_MOBILE_CAPTCHA_FOR_RETAKE_PASSWORD, mobiCapt)
-		return nil
+		return nil, nil
 	}
 }
 
 type UpdateParam struct {
 	// ID
-	ID       string `json:"id"`
+	ID       string `json:"id" validate:"required,objectid"`
 
 	// 昵称
-	Nickname string `json:"nickname"`
+	Nickname string `json:"nickname" validate:"maxlen=32"`
 }
 
 // 更新一个用户的基本信息
 func UpdateProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*UpdateParam)
-		userMgr.Update(param.ID, param.Nickname)
-		return nil
+		return nil, userMgr.Update(param.ID, param.Nickname)
 	}
 }
 
 type UpdatePasswordParam struct {
 	// ID
-	ID       string `json:"id"`
+	ID       string `json:"id" validate:"required,objectid"`
 
-	// 密码
-	Password string `json:"password"`
+	// 密码，不超过72字节
+	Password string `json:"password" validate:"required,maxbytes=72"`
 }
 
-// 修改一个用户密码
-func UpdatePasswordProcessHandler(userMgr business.UserManager) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+// 修改一个用户密码，并销毁该用户当前会话以外的所有会话
+func UpdatePasswordProcessHandler(userMgr business.UserManager, sessMgr *session.SessionManager) rest_json_rpc.ProcessHandler {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*UpdatePasswordParam)
-		userMgr.UpdatePassword(param.ID, param.Password)
-		return nil
+		if err := userMgr.UpdatePassword(param.ID, param.Password); err != nil {
+			return nil, err
+		}
+
+		sessMgr.DestroyUserSessions(param.ID, ctx.Session().ID())
+		return nil, nil
 	}
 }
 
@@ -460,13 +760,23 @@ func WechatAuthHandlerFunc(userMgr business.UserManager, wcCli *wechat_client.We
 			sess.Remove(SESS_KEY_CURRENT_USER_WECHAT_USER_INFO)
 		}
 
-		user := userMgr.GetByWechatOpenID(accToken.OpenID)
+		user, err := userMgr.GetByWechatOpenID(accToken.OpenID)
+		if err != nil {
+			return err
+		}
 		if user == nil {
+			sess.Save()
 			ctx.Redirect(302, loginURL)
 			return nil
 		}
+		if !sess.Regenerate() {
+			return errors.New("会话ID不可轮换，无法登录")
+		}
 		sess.Set(SESS_KEY_CURRENT_USER_ID, user.ID)
+		sess.BindUser(user.ID.Hex())
 
+		// 重定向前保存会话，使会话Cookie随响应下发
+		sess.Save()
 		ctx.Redirect(302, rdURL)
 		return nil
 	}
@@ -3,8 +3,6 @@ package service
 import (
 	"wawa_b.v1/module/rest_json_rpc"
 	"wawa_b.v1/module/wechat_client"
-
-	"github.com/labstack/echo"
 )
 
 type GetWechatJSSDKConfigParam struct {
@@ -14,14 +12,14 @@ type GetWechatJSSDKConfigParam struct {
 
 // 获取微信JSSDK配置
 func GetWechatJSSDKConfigProcessHandler(wcCli *wechat_client.WechatClient) rest_json_rpc.ProcessHandler {
-	return func(ctx echo.Context, p interface{}, _ *rest_json_rpc.ProcessChain) interface{} {
+	return func(ctx rest_json_rpc.Context, p interface{}, _ *rest_json_rpc.ProcessChain) (interface{}, error) {
 		param := p.(*GetWechatJSSDKConfigParam)
 
-		url := ctx.Request().Referer()
+		url := ctx.Header("Referer")
 		if param.URL != nil {
 			url = *param.URL
 		}
 
-		return wcCli.GetJSAPIConfig(url)
+		return wcCli.GetJSAPIConfig(url), nil
 	}
 }
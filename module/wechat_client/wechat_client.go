@@ -2,7 +2,9 @@ package wechat_client
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/Jeffail/gabs"
@@ -31,6 +33,12 @@ type WechatClient struct {
 
 	// 微信JSAPI票据过期时间
 	jsapiTicketExpirationTime time.Time
+
+	// 凭据刷新结果互斥量
+	tokenRefreshMutex         sync.Mutex
+
+	// 最近一次刷新凭据或票据的错误，成功时为nil
+	tokenRefreshErr           error
 }
 
 // 创建一个微信客户端
@@ -44,6 +52,8 @@ func NewWechatClient(httpCli *fasthttp.Client, appID string, appSecret string) *
 
 // 刷新AccessToken
 func (cli *WechatClient) RefreshAccessToken() {
+	defer cli.recordTokenRefresh()
+
 	// 未超时
 	if len(cli.accessToken) != 0 && time.Now().Before(cli.accessTokenExpirationTime) {
 		return
@@ -80,3 +90,28 @@ func (cli *WechatClient) RefreshAccessToken() {
 	cli.accessToken = res.Path("access_token").Data().(string)
 	cli.accessTokenExpirationTime = time.Now().Add((7200 - 200) * time.Second)
 }
+
+// 检查凭据缓存
+// 凭据及票据在使用前刷新，未超时时不重新获取，因此最近一次刷新失败即表示缓存已失效且无法更新
+func (cli *WechatClient) CheckTokenCache() error {
+	cli.tokenRefreshMutex.Lock()
+	defer cli.tokenRefreshMutex.Unlock()
+	return cli.tokenRefreshErr
+}
+
+// 记录刷新凭据或票据的结果，须以defer调用，刷新失败时继续panic
+func (cli *WechatClient) recordTokenRefresh() {
+	r := recover()
+
+	cli.tokenRefreshMutex.Lock()
+	if r != nil {
+		cli.tokenRefreshErr = fmt.Errorf("刷新微信接口调用凭据失败：%v", r)
+	} else {
+		cli.tokenRefreshErr = nil
+	}
+	cli.tokenRefreshMutex.Unlock()
+
+	if r != nil {
+		panic(r)
+	}
+}
@@ -18,6 +18,7 @@ import (
 // 刷新微信JSAPI票据
 func (cli *WechatClient) RefreshJSAPITicket() {
 	cli.RefreshAccessToken()
+	defer cli.recordTokenRefresh()
 
 	// 未超时
 	if len(cli.jsapiTicket) != 0 && time.Now().Before(cli.jsapiTicketExpirationTime) {
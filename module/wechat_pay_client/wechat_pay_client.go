@@ -6,9 +6,11 @@ import (
 	"sort"
 	"strings"
 
+	"wawa_b.v1/module/log"
 	"wawa_b.v1/module/md5"
 
 	"github.com/Jeffail/gabs"
+	"github.com/Sirupsen/logrus"
 	"github.com/clbanning/mxj"
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
@@ -17,6 +19,9 @@ import (
 
 // 微信支付客户端
 type WechatPayClient struct {
+	// 日志
+	logger     *logrus.Logger
+
 	// HTTP客户端
 	httpClient *fasthttp.Client
 
@@ -33,6 +38,7 @@ type WechatPayClient struct {
 // 创建一个微信支付客户端
 func NewWechatPayClient(httpCli *fasthttp.Client, appID, mchID, ptrKey string) *WechatPayClient {
 	return &WechatPayClient{
+		logger: log.GetLogger("wechat_pay_client.wechatPayClient"),
 		httpClient: httpCli,
 		appID: appID,
 		mchID: mchID,
@@ -201,19 +207,23 @@ func stringMap2InterfaceMap(m map[string]string) map[string]interface{} {
 	return r
 }
 
-type PayNotifyCallbackHandler func(param *gabs.Container)
+// 微信支付通知回调处理器，返回错误时通知微信支付稍后重新通知
+type PayNotifyCallbackHandler func(param *gabs.Container) error
 
 // 微信支付通知回调
 func (cli *WechatPayClient) PayNotifyCallbackHandlerFunc(handler PayNotifyCallbackHandler) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
+		// 返回状态码与返回信息
+		returnCode, returnMsg := "SUCCESS", "OK"
+
 		defer func() {
 			// 响应请求
 			// - 构建响应
 			resMap := mxj.New()
-			if err := resMap.SetValueForPath("SUCCESS", "return_code"); err != nil {
+			if err := resMap.SetValueForPath(returnCode, "return_code"); err != nil {
 				panic(err)
 			}
-			if err := resMap.SetValueForPath("OK", "return_msg"); err != nil {
+			if err := resMap.SetValueForPath(returnMsg, "return_msg"); err != nil {
 				panic(err)
 			}
 
@@ -246,7 +256,11 @@ func (cli *WechatPayClient) PayNotifyCallbackHandlerFunc(handler PayNotifyCallba
 			panic(err)
 		}
 
-		handler(param.S("xml"))
+		// 处理失败时以FAIL应答，微信支付将稍后重新通知；错误仅记录日志，不再交由Echo响应，以免覆盖已发送的应答
+		if err := handler(param.S("xml")); err != nil {
+			returnCode, returnMsg = "FAIL", err.Error()
+			cli.logger.WithError(err).WithField("body", string(reqJSON)).Error("处理微信支付通知失败")
+		}
 
 		return nil
 	}